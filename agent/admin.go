@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// adminHeaders is the response of the headers endpoint of the admin API
+type adminHeaders struct {
+	Headers  map[string]string            `json:"headers"`  // header lines by metric
+	Metadata map[string]map[string]string `json:"metadata"` // metadata lines by metric and name
+}
+
+// startAdminServer serves the admin API at the unix socket path, relative to the working directory.
+// The socket can only be used by the user of the agent
+func (a *Agent) startAdminServer(path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(a.Config.Chdir, path)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// left behind by an agent which did not stop
+		os.Remove(path)
+	}
+	mask := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.serveAdmin(http.MethodGet, a.adminStatus))
+	mux.HandleFunc("/collectors", a.serveAdmin(http.MethodGet, a.adminCollectors))
+	mux.HandleFunc("/collectors/run", a.serveAdmin(http.MethodPost, a.adminRunCollector))
+	mux.HandleFunc("/destinations", a.serveAdmin(http.MethodGet, a.adminDestinations))
+	mux.HandleFunc("/headers", a.serveAdmin(http.MethodGet, a.adminHeaders))
+	mux.HandleFunc("/inventory", a.serveAdmin(http.MethodGet, a.adminInventory))
+	a.adminServer = &http.Server{Handler: mux, ReadHeaderTimeout: httpTimeout}
+	go func() {
+		if err := a.adminServer.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("admin server error: %v", err)
+		}
+	}()
+	log.Infof("serving admin API at %s", path)
+	return nil
+}
+
+// stopAdminServer stops the admin server, if started, which removes its socket
+func (a *Agent) stopAdminServer() {
+	if a.adminServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.adminServer.Shutdown(ctx); err != nil {
+		log.Errorf("can't stop admin server: %v", err)
+	}
+}
+
+// serveAdmin returns a handler which answers requests of given method with the JSON encoded result of
+// handle, or with {"error": ...} if it failed
+func (a *Agent) serveAdmin(method string, handle func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("method %s not allowed", r.Method)})
+			return
+		}
+		res, err := handle(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	}
+}
+
+// adminStatus returns the state of the agent, its destinations and collectors
+func (a *Agent) adminStatus(r *http.Request) (interface{}, error) {
+	return a.status(), nil
+}
+
+// adminCollectors returns the collectors with their state, error and timeout counts and last run
+func (a *Agent) adminCollectors(r *http.Request) (interface{}, error) {
+	return a.status().Collectors, nil
+}
+
+// adminDestinations returns the connection and queue state of the destinations
+func (a *Agent) adminDestinations(r *http.Request) (interface{}, error) {
+	return a.status().Destinations, nil
+}
+
+// adminRunCollector runs the collectors given in the name query parameters now, like RunCollector
+func (a *Agent) adminRunCollector(r *http.Request) (interface{}, error) {
+	a.controlMtx.Lock()
+	defer a.controlMtx.Unlock()
+	out, err := a.cmdRunCollector(r.URL.Query()["name"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"result": out}, nil
+}
+
+// adminHeaders returns the current metric headers and metadata
+func (a *Agent) adminHeaders(r *http.Request) (interface{}, error) {
+	res := adminHeaders{Headers: make(map[string]string), Metadata: make(map[string]map[string]string)}
+	a.metricHeaders.RLock()
+	for name, header := range a.metricHeaders.Map {
+		res.Headers[name] = header
+	}
+	a.metricHeaders.RUnlock()
+	a.metricMetadata.RLock()
+	for name, metadata := range a.metricMetadata.Map {
+		res.Metadata[name] = make(map[string]string)
+		for k, v := range metadata {
+			res.Metadata[name][k] = v
+		}
+	}
+	a.metricMetadata.RUnlock()
+	return res, nil
+}
+
+// adminInventory returns the last inventory blobs by type
+func (a *Agent) adminInventory(r *http.Request) (interface{}, error) {
+	res := make(map[string]json.RawMessage)
+	a.lastInventory.RLock()
+	for key, data := range a.lastInventory.Map {
+		res[key] = json.RawMessage(data)
+	}
+	a.lastInventory.RUnlock()
+	return res, nil
+}
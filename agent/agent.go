@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/inventory"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 //Start checks for errors in configuration, initializes nodeid and starts the agent
@@ -27,64 +29,113 @@ func Start(a *Agent) {
 	a.WaitGroup.Wait()
 }
 
-// Stop the agent:
+// Stop the agent: collection is cancelled, which kills child processes, and data still queued
+// is delivered to the destinations, or spooled, within shutdownTimeout
 func (a *Agent) Stop() {
-	log.Info("Stopping agent")
-
-	a.inventoryCollectors.Lock()
-	for _, invCol := range a.inventoryCollectors.List {
-		if invCol.state == runningState {
-			invCol.state = stopState
+	a.stopOnce.Do(func() {
+		log.Info("Stopping agent")
+		a.cancel()
+
+		// wait for running collections and commands, they return as soon as they are cancelled.
+		// Reloads and control commands which started before see the agent stopping once they finish
+		a.collecting.Lock()
+		a.collecting.Unlock()
+		a.commandsMtx.Lock()
+		a.commandsMtx.Unlock()
+		a.commands.Wait()
+		a.controlMtx.Lock()
+		a.controlMtx.Unlock()
+		a.stopRecording()
+
+		a.destinationsMtx.Lock()
+		dsts := a.Destinations
+		a.Destinations = nil
+		a.destinationsMtx.Unlock()
+		for _, d := range dsts {
+			close(d.quit)
 		}
-	}
-	a.inventoryCollectors.Unlock()
-	a.metricCollectors.Lock()
-	for _, metCol := range a.metricCollectors.List {
-		if metCol.state == runningState {
-			metCol.state = stopState
+		deadline := time.Now().Add(shutdownTimeout + time.Second)
+		for _, d := range dsts {
+			select {
+			case <-d.done:
+			case <-time.After(time.Until(deadline)):
+				log.Errorf("timeout when stopping destination %s", d.dst)
+			}
 		}
-	}
-	a.metricCollectors.Unlock()
+		a.stopMetricsServer()
+		a.stopAdminServer()
 
-	log.Info("Finished stopping agent")
-	a.WaitGroup.Done()
+		log.Info("Finished stopping agent")
+		a.WaitGroup.Done()
+	})
 }
 
-// NonBlockingSend sends data to stdout and over channel and is non-blocking
-func (a *Agent) NonBlockingSend(data []byte) {
+// NonBlockingSend sends data of given class to stdout and to the destinations which accept it, it is non-blocking
+func (a *Agent) NonBlockingSend(class string, data []byte) {
 	// Send data to stdout
-	if a.Config.Stdout {
+	if a.config().Stdout {
 		os.Stdout.Write(data)
 		os.Stdout.Write([]byte{'\n'})
 	}
 
-	if a.Config.Destination != "" || a.Config.DryRun {
-		select {
-		case a.SendCh <- data:
-		default:
-			log.Errorf("unable to send data to destination %v", a.Config.Destination)
+	a.destinationsMtx.RLock()
+	for _, d := range a.Destinations {
+		if d.accepts(class) {
+			a.sendTo(d, data)
+		}
+	}
+	a.destinationsMtx.RUnlock()
+}
+
+// sendTo queues data for the destination, data goes to the spool when the queue is full
+func (a *Agent) sendTo(d *Destination, data []byte) {
+	select {
+	case d.sendCh <- data:
+	default:
+		if d.spool != nil {
+			a.spoolData(d, data)
+			return
 		}
+		a.countDestination(d, statDrops, 1)
+		log.Errorf("unable to send data to destination %v", d.dst)
+	}
+}
+
+// spoolData stores data which can't be sent now in the spool of the destination
+func (a *Agent) spoolData(d *Destination, data []byte) {
+	if err := d.spool.Push(data); err != nil {
+		a.countDestination(d, statDrops, 1)
+		log.Errorf("unable to spool data for destination %v: %v", d.dst, err)
 	}
 }
 
 // showHelp outputs the help if given invalid arguments and exits
 func showUsage(err error) {
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "\n")
-		fmt.Fprintln(os.Stderr, err, "\n")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprint(os.Stderr, err, "\n\n")
 	}
 
 	flag.Usage()
 }
 
-// Trap interrupts and exit
+// Trap interrupts and stop the agent, SIGHUP reloads the config file
 func handleInterrupt(a *Agent, intrptChSize int) {
 	a.SigChan = make(chan os.Signal, intrptChSize)
-	signal.Notify(a.SigChan, os.Interrupt, os.Kill, syscall.SIGTERM)
+	signal.Notify(a.SigChan, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
 		for sig := range a.SigChan {
-			log.Infof("agent received %s signal, exiting", sig)
-			os.Exit(1)
+			if sig == syscall.SIGHUP {
+				// a reload waits for running inventory collections, signals are handled meanwhile
+				go a.reload()
+				continue
+			}
+			if a.ctx.Err() != nil {
+				log.Infof("agent received %s signal while stopping, exiting", sig)
+				os.Exit(1)
+			}
+			log.Infof("agent received %s signal, stopping", sig)
+			go a.Stop()
 		}
 	}()
 }
@@ -92,9 +143,8 @@ func handleInterrupt(a *Agent, intrptChSize int) {
 // Initialize initializes the Agent
 func (a *Agent) Initialize() error {
 	var err error
-	var dst *Destination
-
 	config := a.Config
+	a.ctx, a.cancel = context.WithCancel(context.Background())
 
 	//check for wrong arguments
 	if len(flag.Args()) > 0 {
@@ -103,13 +153,40 @@ func (a *Agent) Initialize() error {
 		return err
 	}
 
-	//handle dry-run
+	// Load config file, flags given on command line override its values
+	a.cmdFlags = make(map[string]string)
+	flag.Visit(func(f *flag.Flag) { a.cmdFlags[f.Name] = f.Value.String() })
+	if config.ConfigFile != "" {
+		if config.ConfigFile, err = filepath.Abs(config.ConfigFile); err != nil {
+			log.Errorf("resolving config file [%s] error: %v", config.ConfigFile, err)
+			return err
+		}
+		if err = config.ReadFile(config.ConfigFile); err != nil {
+			log.Errorf("can't load config file, %v", err)
+			return err
+		}
+		for name, value := range a.cmdFlags {
+			flag.Set(name, value)
+		}
+	}
+
+	// dry-run reports the collectors and exits, nothing is sent
 	if config.DryRun {
-		config.Destination = ""
-		initialFreq := config.Freq
-		config.Freq = 0
-		config.Stdout = true
-		dryRun(a, initialFreq)
+		os.Exit(a.dryRun())
+	}
+
+	// directories of captures are relative to the working directory the agent is started in
+	for _, dir := range []*string{&config.Record, &config.Replay} {
+		if *dir == "" {
+			continue
+		}
+		if *dir, err = filepath.Abs(*dir); err != nil {
+			log.Errorf("resolving capture directory [%s] error: %v", *dir, err)
+			return err
+		}
+	}
+	if config.Replay != "" {
+		os.Exit(a.replay())
 	}
 
 	//check parameters are valid
@@ -128,6 +205,32 @@ func (a *Agent) Initialize() error {
 		config.Chdir = path
 	}
 
+	if err := a.configure(); err != nil {
+		return err
+	}
+
+	go handleInterrupt(a, intrptChSize)
+
+	return nil
+}
+
+// configure sets up the agent from its checked config: its node ID, destinations and collectors,
+// without starting them
+func (a *Agent) configure() error {
+	var err error
+	var dsts []*Destination
+	config := a.Config
+
+	if err := a.setHostRoot(); err != nil {
+		log.Errorf("resolving host root [%s] error: %v", config.HostRoot, err)
+		return err
+	}
+
+	if config.Chdir, err = filepath.Abs(config.Chdir); err != nil {
+		log.Errorf("resolving directory [%s] error: %v", config.Chdir, err)
+		return err
+	}
+
 	if len(config.NodeID) == 0 {
 		if err := config.InitializeNodeID(); err != nil {
 			fmt.Printf("Error initializing NodeId: %s", err)
@@ -136,55 +239,53 @@ func (a *Agent) Initialize() error {
 	}
 
 	// Skips collectors:
-	a.Skipmap = make(map[string]struct{})
-	if config.SkipStr != "" {
-		skiplist := strings.Split(config.SkipStr, ",")
-	SKIPLOOP:
-		for _, skipName := range skiplist {
-			if "all" == skipName {
-				a.Skipmap[skipName] = struct{}{}
-				log.Infof("skipping collector %s", skipName)
-				break
-			}
+	a.Skipmap = newSkipmap(config.SkipStr)
 
-			for invScriptName := range inventory.Collectors {
-				if invScriptName == skipName {
-					a.Skipmap[skipName] = struct{}{}
-					log.Infof("skipping collector %s", skipName)
-					continue SKIPLOOP
-				}
-			}
-
-			for metricScriptName := range metricCollectors {
-				if metricScriptName == skipName {
-					a.Skipmap[skipName] = struct{}{}
-					log.Infof("skipping collector %s", skipName)
-					continue SKIPLOOP
-				}
-			}
-			err := fmt.Errorf("Collector %s not found", skipName)
-			log.Errorf("%s", err)
-		}
+	// Keys trusted to sign files run by ExecCommand
+	keys, err := loadTrustedKeys(config.TrustedKeys)
+	if err != nil {
+		log.Errorf("invalid command line arguments to -trusted-keys, %v", err)
+		return err
+	}
+	a.setTrustedKeys(keys)
 
+	// Restrictions of ExecCommand files and user scripts
+	policy, err := newExecPolicy(config)
+	if err != nil {
+		log.Errorf("invalid execution policy, %v", err)
+		return err
 	}
+	a.setExecPolicy(policy)
 
-	// Set destination
-	if dst, err = parseDest(config.Destination); err != nil {
+	// Set destinations
+	if dsts, err = newDestinations(config); err != nil {
 		log.Errorf("invalid command line arguments to -destination, %v", err)
 		return err
 	}
-	a.Destination = dst
+	dsts = append(dsts, a.sinks...)
+	for _, dst := range dsts {
+		if err = dst.open(config); err != nil {
+			return err
+		}
+	}
+	a.Destinations = dsts
+	a.lastInventory.Map = make(map[string][]byte)
+	a.latestMetrics.Map = make(map[string]*metric)
+	if config.Listen != "" {
+		if err = a.startMetricsServer(config.Listen); err != nil {
+			log.Errorf("can't serve metrics at %s, %v", config.Listen, err)
+			return err
+		}
+	}
 	a.MetricFrequency = time.Duration(config.Freq) * time.Second
 	a.WaitTime = time.Duration(config.WaitTime) * time.Second
 	a.CollectorTimeout = time.Duration(config.CollectorTimeout) * time.Second
 
-	go handleInterrupt(a, intrptChSize)
-
 	// Handle timeout on agent:
 	a.WaitGroup.Add(1)
-	if a.Config.Duration > 0 {
+	if duration := a.Config.Duration; duration > 0 {
 		go func() {
-			<-time.After(time.Duration(a.Config.Duration) * time.Second)
+			<-time.After(time.Duration(duration) * time.Second)
 			log.Info("Duration complete stopping agent")
 			a.Stop()
 		}()
@@ -196,10 +297,13 @@ func (a *Agent) Initialize() error {
 		a.InvFrequency = 0 * time.Nanosecond
 	}
 
-	a.SendCh = make(chan []byte, len(inventory.Collectors))
+	a.invScheduleCh = make(chan struct{}, 1)
+	a.collectorFreqs, _ = parseCollectorSettings(config.CollectorFreqStr)
+	a.collectorTimeouts, _ = parseCollectorSettings(config.CollectorTimeoutStr)
 	a.TimeoutLimit = failureLimit
 	a.ErrorLimit = failureLimit
 	a.timeoutConnSend = timeoutConnSend
+	a.telemetry = gometrics.NewRegistry()
 	a.dialTimeout = dialTimeout
 
 	// Get agent's hostname
@@ -209,29 +313,58 @@ func (a *Agent) Initialize() error {
 	}
 	a.hostname = hostname
 
+	if config.Record != "" {
+		if err := a.startRecording(); err != nil {
+			log.Errorf("can't record into %s, %v", config.Record, err)
+			return err
+		}
+	}
+
+	a.initCollectors()
+
+	if err := a.monitorUserScripts(typeInventory); err != nil {
+		log.Infof("error monitoring inventory scripts: %s", err)
+	}
+
+	if err := a.monitorUserScripts(typeMetric); err != nil {
+		log.Infof("error monitoring metric scripts: %s", err)
+	}
+
+	a.metricMetadata.Map = make(map[string]map[string]string)
+
+	if config.AdminSocket != "" {
+		if err := a.startAdminServer(config.AdminSocket); err != nil {
+			log.Errorf("can't serve admin API at %s, %v", config.AdminSocket, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// initCollectors sets up the built-in collectors and the user scripts found under -chdir
+func (a *Agent) initCollectors() {
 	// Core-Scripts
-	_, skipAll := a.Skipmap["all"]
 	a.metricHeaders.Map = make(map[string]string)
+	registry.RLock()
 
 	a.inventoryCollectors.Lock()
 	a.inventoryCollectors.List = make(map[string]*InventoryCollector)
 	for invScriptName, invCollectorFuncWrapper := range inventory.Collectors {
 
 		newScript := &InventoryCollector{
-			collect: invCollectorFuncWrapper.RunFn,
+			collect:   invCollectorFuncWrapper.RunFn,
+			frequency: a.inventoryFrequency(invScriptName),
 			BaseCollector: BaseCollector{
 				name:          invScriptName,
 				precheck:      invCollectorFuncWrapper.PrecheckFn,
 				dependencies:  invCollectorFuncWrapper.Dependencies,
 				collectorType: invCollectorFuncWrapper.Type,
-				timeout:       a.CollectorTimeout,
+				timeout:       a.collectorTimeout(invScriptName),
 				state:         runningState,
 			},
 		}
 
-		if _, ok := a.Skipmap[invScriptName]; ok || skipAll || newScript.Precheck(a) != nil {
-			newScript.state = stopState
-		}
+		newScript.state = a.initialState(&newScript.BaseCollector)
 		a.inventoryCollectors.List[invScriptName] = newScript
 	}
 
@@ -242,38 +375,83 @@ func (a *Agent) Initialize() error {
 	for metricScriptName, metricCollectorFuncWrapper := range metricCollectors {
 		newScript := &MetricCollector{
 			collect:   metricCollectorFuncWrapper.RunFn,
-			frequency: a.MetricFrequency,
+			derive:    metricCollectorFuncWrapper.DeriveFn,
+			frequency: a.metricFrequency(metricScriptName),
 			BaseCollector: BaseCollector{
 				name:          metricScriptName,
 				precheck:      metricCollectorFuncWrapper.PrecheckFn,
 				dependencies:  metricCollectorFuncWrapper.Dependencies,
 				collectorType: builtIn,
-				timeout:       a.CollectorTimeout,
+				timeout:       a.collectorTimeout(metricScriptName),
 				state:         runningState,
 			},
 			killCh: make(chan struct{}),
 		}
 
-		if _, ok := a.Skipmap[metricScriptName]; ok || skipAll || newScript.Precheck(a) != nil {
-			newScript.state = stopState
-		}
+		newScript.state = a.initialState(&newScript.BaseCollector)
 		a.metricCollectors.List[metricScriptName] = newScript
 	}
+	// the agent reports its own health like a built-in collector
+	telemetry := &MetricCollector{
+		collect:   a.telemetryRunner(),
+		frequency: a.metricFrequency(telemetryCollector),
+		BaseCollector: BaseCollector{
+			name:          telemetryCollector,
+			collectorType: builtIn,
+			timeout:       a.collectorTimeout(telemetryCollector),
+			state:         runningState,
+		},
+		killCh: make(chan struct{}),
+	}
+	telemetry.state = a.initialState(&telemetry.BaseCollector)
+	a.metricCollectors.List[telemetryCollector] = telemetry
 	a.metricCollectors.Unlock()
+	registry.RUnlock()
 
 	// User-scripts
 	log.Info("checking for user scripts")
 	a.addUserScripts()
+}
 
-	if err := a.monitorUserScripts(typeInventory); err != nil {
-		log.Infof("error monitoring inventory scripts: %s", err)
-	}
+// newSkipmap returns collectors to skip from given comma separated list of names
+func newSkipmap(skipStr string) map[string]struct{} {
+	skip := make(map[string]struct{})
+	registry.RLock()
+	defer registry.RUnlock()
+	if skipStr != "" {
+		skiplist := strings.Split(skipStr, ",")
+	SKIPLOOP:
+		for _, skipName := range skiplist {
+			if "all" == skipName {
+				skip[skipName] = struct{}{}
+				log.Infof("skipping collector %s", skipName)
+				break
+			}
 
-	if err := a.monitorUserScripts(typeMetric); err != nil {
-		log.Infof("error monitoring metric scripts: %s", err)
-	}
+			for invScriptName := range inventory.Collectors {
+				if invScriptName == skipName {
+					skip[skipName] = struct{}{}
+					log.Infof("skipping collector %s", skipName)
+					continue SKIPLOOP
+				}
+			}
 
-	a.metricMetadata.Map = make(map[string]map[string]string)
+			for metricScriptName := range metricCollectors {
+				if metricScriptName == skipName {
+					skip[skipName] = struct{}{}
+					log.Infof("skipping collector %s", skipName)
+					continue SKIPLOOP
+				}
+			}
+			if skipName == telemetryCollector {
+				skip[skipName] = struct{}{}
+				log.Infof("skipping collector %s", skipName)
+				continue
+			}
+			err := fmt.Errorf("Collector %s not found", skipName)
+			log.Errorf("%s", err)
+		}
 
-	return nil
+	}
+	return skip
 }
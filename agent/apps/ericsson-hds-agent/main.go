@@ -9,6 +9,10 @@ import (
 
 //this is the default behavior for agent
 func main() {
+	if len(os.Args) > 1 && agent.IsSubcommand(os.Args[1]) {
+		os.Exit(agent.RunSubcommand(os.Args[1], os.Args[2:]))
+	}
+
 	config := agent.NewDefaultConfig()
 	agent.InitFlags(config)
 	flag.Parse()
@@ -0,0 +1,342 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// captureSettingsFile is the file of a capture made with -record with the settings of the recorded agent
+const captureSettingsFile = "agent.json"
+
+// kinds of the capture events of the agent, the inputs of the collectors are recorded by collectors.Capture
+const (
+	eventMetric    = "metric"    // metric collection, Key is the collector
+	eventInventory = "inventory" // inventory collection of one or more collectors
+	eventResult    = "result"    // results of a collector which reads no input of the host, Key is the collector
+	eventConnect   = "connect"   // a tcp or tls destination sent its initial data, Key is the destination
+)
+
+// replayConnectTimeout is how long -replay waits for the destinations to connect
+const replayConnectTimeout = 30 * time.Second
+
+// captureSettings are the settings of the recorded agent, the collectors are replayed with them
+type captureSettings struct {
+	NodeID             string    `json:"nodeID"`
+	Hostname           string    `json:"hostname"`
+	HostRoot           string    `json:"hostRoot"`
+	Frequency          int       `json:"frequency"`
+	CollectionTimeout  int       `json:"collectionTimeout"`
+	CollectorFrequency string    `json:"collectorFrequency"`
+	CollectorTimeout   string    `json:"collectorTimeout"`
+	CounterMode        string    `json:"counterMode"`
+	Skip               string    `json:"skip"`
+	Started            time.Time `json:"started"`
+}
+
+// collectionEvent is the value of a metric or inventory collection event
+type collectionEvent struct {
+	Script     string              `json:"script,omitempty"`     // user script of a metric collector
+	Collectors []capturedCollector `json:"collectors,omitempty"` // inventory collectors which ran
+	Scheduled  bool                `json:"scheduled,omitempty"`  // blobs were compared with those last sent by the schedule
+}
+
+// capturedCollector is an inventory collector of a collection event
+type capturedCollector struct {
+	Name   string `json:"name"`
+	Script string `json:"script,omitempty"` // user script
+}
+
+// startRecording records the inputs of the collectors into -record from now on
+func (a *Agent) startRecording() error {
+	c := a.Config
+	capture, err := collectors.NewCapture(c.Record, collectors.ExecRunner{})
+	if err != nil {
+		return err
+	}
+	settings := captureSettings{
+		NodeID:             c.NodeID,
+		Hostname:           a.hostname,
+		HostRoot:           collectors.HostRoot(),
+		Frequency:          c.Freq,
+		CollectionTimeout:  c.CollectorTimeout,
+		CollectorFrequency: c.CollectorFreqStr,
+		CollectorTimeout:   c.CollectorTimeoutStr,
+		CounterMode:        c.CounterMode,
+		Skip:               c.SkipStr,
+		Started:            time.Now(),
+	}
+	data, _ := json.MarshalIndent(settings, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(c.Record, captureSettingsFile), append(data, '\n'), 0644); err != nil {
+		capture.Close()
+		return err
+	}
+	a.capture = capture
+	a.ctx = collectors.WithRunner(a.ctx, capture)
+	collectors.SetFileSource(capture)
+	log.Infof("recording inputs of collectors into %s", c.Record)
+	return nil
+}
+
+// stopRecording closes the capture, when the collections have stopped
+func (a *Agent) stopRecording() {
+	if a.capture == nil {
+		return
+	}
+	collectors.SetFileSource(nil)
+	if err := a.capture.Close(); err != nil {
+		log.Errorf("error closing capture %s: %v", a.config().Record, err)
+	}
+}
+
+// recordEvent records an event of the agent with -record
+func (a *Agent) recordEvent(kind, key string, value interface{}) {
+	if a.capture == nil {
+		return
+	}
+	e := &collectors.CaptureEvent{Kind: kind, Key: key}
+	if value != nil {
+		e.Value, _ = json.Marshal(value)
+	}
+	if err := a.capture.Record(e); err != nil {
+		log.Errorf("error recording %s event: %v", kind, err)
+	}
+}
+
+// recordMetric records a collection of metric collector c with -record
+func (a *Agent) recordMetric(c *MetricCollector) {
+	a.recordEvent(eventMetric, c.name, collectionEvent{Script: c.script})
+}
+
+// recordInventory records a collection of the inventory collectors with results with -record
+func (a *Agent) recordInventory(results []Inventory, scheduled bool) {
+	if a.capture == nil || len(results) == 0 {
+		return
+	}
+	v := collectionEvent{Scheduled: scheduled}
+	a.inventoryCollectors.RLock()
+	for _, inv := range results {
+		cc := capturedCollector{Name: inv.Name}
+		if c, ok := a.inventoryCollectors.List[inv.Name]; ok {
+			cc.Script = c.script
+		}
+		v.Collectors = append(v.Collectors, cc)
+	}
+	a.inventoryCollectors.RUnlock()
+	a.recordEvent(eventInventory, "", v)
+}
+
+// destinationConnected records that tcp or tls destination ds sent its initial data with -record, and
+// signals it to the collections of -replay
+func (a *Agent) destinationConnected(ds *Destination) {
+	a.recordEvent(eventConnect, ds.dst, nil)
+	if a.replayConnected != nil {
+		select {
+		case a.replayConnected <- ds:
+		default:
+		}
+	}
+}
+
+// telemetryRunner returns the run function of the agent metric collector. Its results are not read
+// from the host, they are recorded with -record and replayed with -replay
+func (a *Agent) telemetryRunner() collectors.MetricRunner {
+	return func(ctx context.Context) ([]*collectors.MetricResult, error) {
+		if r, ok := collectors.RunnerFrom(ctx).(*collectors.CaptureReplay); ok {
+			e, ok := r.Next(eventResult, telemetryCollector)
+			if !ok {
+				return nil, errors.New("results not in capture")
+			}
+			if e.Error != "" {
+				return nil, errors.New(e.Error)
+			}
+			var res []*collectors.MetricResult
+			err := json.Unmarshal(e.Value, &res)
+			return res, err
+		}
+
+		res, err := a.collectTelemetry(ctx)
+		if a.capture != nil {
+			e := &collectors.CaptureEvent{Kind: eventResult, Key: telemetryCollector}
+			if err != nil {
+				e.Error = err.Error()
+			} else {
+				e.Value, _ = json.Marshal(res)
+			}
+			a.capture.Record(e)
+		}
+		return res, err
+	}
+}
+
+// replay runs the collections recorded in -replay in their order on the recorded inputs, with the
+// settings of the recorded agent, sends the data to -stdout or -destination and returns the exit code
+func (a *Agent) replay() int {
+	config := a.Config
+	capture, err := collectors.OpenCapture(config.Replay)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open capture, %v\n", err)
+		return exitFailure
+	}
+	var settings captureSettings
+	data, err := ioutil.ReadFile(filepath.Join(config.Replay, captureSettingsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't read settings of capture, %v\n", err)
+		return exitFailure
+	}
+
+	config.NodeID = settings.NodeID
+	config.HostRoot = ""
+	config.Freq = settings.Frequency
+	config.CollectorTimeout = settings.CollectionTimeout
+	config.CollectorFreqStr = settings.CollectorFrequency
+	config.CollectorTimeoutStr = settings.CollectorTimeout
+	config.CounterMode = settings.CounterMode
+	config.SkipStr = settings.Skip
+	if !config.Stdout && config.Destination == "" {
+		config.Stdout = true
+	}
+	if err := config.CheckErrs(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// the host root of the recorded agent is under the root of the capture
+	collectors.SetFileSource(capture)
+	collectors.SetHostRoot(settings.HostRoot)
+	a.ctx = collectors.WithRunner(a.ctx, capture)
+	a.standalone = true
+	if err := a.setupCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	a.hostname = settings.Hostname
+
+	dsts, err := newDestinations(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid command line arguments to -destination, %v\n", err)
+		return exitUsage
+	}
+	for _, d := range dsts {
+		if err := d.open(config); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		// inventory is sent as it was recorded, not when the destination connects
+		d.inventorySent = true
+	}
+	a.Destinations = dsts
+	a.initialInventory.Do(func() {})
+	a.replayConnected = make(chan *Destination, len(dsts))
+	a.WaitGroup.Add(1)
+
+	// the destinations connect where the first destination of the recorded agent did, so that their
+	// initial data has the same headers
+	events := capture.Events()
+	connected := true
+	for _, e := range events {
+		if e.Kind == eventConnect {
+			connected = false
+			break
+		}
+	}
+	if connected {
+		a.connect()
+	}
+	sha1cache := make(map[string]string)
+	n := 0
+	for _, e := range events {
+		switch e.Kind {
+		case eventMetric:
+			a.replayMetric(e)
+		case eventInventory:
+			a.replayInventory(e, sha1cache)
+		case eventConnect:
+			if !connected {
+				a.replayConnect()
+				connected = true
+			}
+			continue
+		default:
+			continue
+		}
+		n++
+	}
+	log.Infof("replayed %d collections of %s", n, config.Replay)
+	a.Stop()
+	return exitOK
+}
+
+// replayConnect connects the destinations and waits until the tcp and tls ones have sent their initial data
+func (a *Agent) replayConnect() {
+	a.connect()
+	n := 0
+	for _, d := range a.Destinations {
+		if d.proto == protoTCP || d.proto == protoTLS {
+			n++
+		}
+	}
+	timeout := time.After(replayConnectTimeout)
+	for ; n > 0; n-- {
+		select {
+		case <-a.replayConnected:
+		case <-timeout:
+			log.Errorf("destinations did not connect in %v, replaying without them", replayConnectTimeout)
+			return
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// replayMetric runs the metric collection of event e
+func (a *Agent) replayMetric(e *collectors.CaptureEvent) {
+	var v collectionEvent
+	json.Unmarshal(e.Value, &v)
+	a.metricCollectors.Lock()
+	c, ok := a.metricCollectors.List[e.Key]
+	if !ok && v.Script != "" {
+		c, ok = a.newMetricsScript(v.Script), true
+		a.metricCollectors.List[e.Key] = c
+	}
+	a.metricCollectors.Unlock()
+	if !ok {
+		log.Errorf("metric collector %s of capture not found", e.Key)
+		return
+	}
+	a.runMetricCollector(c)
+}
+
+// replayInventory runs the inventory collection of event e. Scheduled collections compare their
+// blobs with sha1cache
+func (a *Agent) replayInventory(e *collectors.CaptureEvent, sha1cache map[string]string) {
+	var v collectionEvent
+	json.Unmarshal(e.Value, &v)
+	var names []string
+	a.inventoryCollectors.Lock()
+	for _, cc := range v.Collectors {
+		if _, ok := a.inventoryCollectors.List[cc.Name]; !ok && cc.Script != "" {
+			a.inventoryCollectors.List[cc.Name] = a.newInventoryScript(cc.Script)
+		}
+		names = append(names, cc.Name)
+	}
+	a.inventoryCollectors.Unlock()
+	if len(names) == 0 {
+		return
+	}
+	if !v.Scheduled {
+		sha1cache = nil
+	}
+	a.runInvCollectors(sha1cache, names, true)
+}
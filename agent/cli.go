@@ -0,0 +1,437 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/inventory"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// subcommand is a command of the agent binary other than running the agent
+type subcommand struct {
+	args string                            // arguments after the flags, for the usage
+	help string                            // what the command does
+	run  func(a *Agent, args []string) int // runs the command, it returns the exit code
+}
+
+// subcommands of the agent binary, they take the flags of the agent, e.g. -config, -chdir or -skip
+var subcommands = map[string]subcommand{
+	"list-collectors": {help: "list built-in and user collectors and whether they can run", run: (*Agent).listCollectors},
+	"run":             {args: "collector...", help: "run collectors once and print their results", run: (*Agent).runCollectors},
+	"validate-config": {help: "check the config file and flags, and exit with status 1 if they are invalid", run: (*Agent).validateConfig},
+	"show-node-id":    {help: "print the node ID stored under -chdir", run: (*Agent).showNodeID},
+	"record-commands": {args: "bundle-dir [collector...]", help: "run collectors once and save the commands they run with their outputs into a bundle", run: (*Agent).recordCommands},
+	"replay-commands": {args: "bundle-dir [collector...]", help: "run collectors once with the command outputs of a bundle and print their results", run: (*Agent).replayCommands},
+}
+
+// exit codes of subcommands
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// IsSubcommand reports if name is a subcommand of the agent binary, e.g. list-collectors
+func IsSubcommand(name string) bool {
+	_, ok := subcommands[name]
+	return ok
+}
+
+// RunSubcommand runs subcommand name with args, flags of the agent first, and returns the exit code
+func RunSubcommand(name string, args []string) int {
+	cmd, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
+		return exitUsage
+	}
+
+	c := NewDefaultConfig()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	bindFlags(fs, c)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n%s\n\nFlags:\n", filepath.Base(os.Args[0]), name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	a := &Agent{Config: c, standalone: true, cmdFlags: make(map[string]string)}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	defer a.cancel()
+	fs.Visit(func(f *flag.Flag) { a.cmdFlags[f.Name] = f.Value.String() })
+	if c.ConfigFile != "" {
+		if err := c.ReadFile(c.ConfigFile); err != nil {
+			fmt.Fprintf(os.Stderr, "can't load config file, %v\n", err)
+			return exitFailure
+		}
+		for name, value := range a.cmdFlags {
+			fs.Set(name, value)
+		}
+	}
+	return cmd.run(a, fs.Args())
+}
+
+// printUsage prints the usage of the agent binary with its subcommands and flags
+func printUsage() {
+	prog := filepath.Base(os.Args[0])
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n       %s <command> [flags] [args]\n\nCommands:\n", prog, prog)
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-16s %s\n", name, subcommands[name].help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// setupCollectors sets up the collectors of the config, without scheduling them
+func (a *Agent) setupCollectors() error {
+	c := a.Config
+	var err error
+	if c.Chdir, err = filepath.Abs(c.Chdir); err != nil {
+		return err
+	}
+	if err = a.setHostRoot(); err != nil {
+		return err
+	}
+	if a.collectorFreqs, err = parseCollectorSettings(c.CollectorFreqStr); err != nil {
+		return fmt.Errorf("invalid value passed to flag -collector-frequency. %v", err)
+	}
+	if a.collectorTimeouts, err = parseCollectorSettings(c.CollectorTimeoutStr); err != nil {
+		return fmt.Errorf("invalid value passed to flag -collector-timeout. %v", err)
+	}
+	policy, err := newExecPolicy(c)
+	if err != nil {
+		return err
+	}
+	a.setExecPolicy(policy)
+
+	a.MetricFrequency = time.Duration(c.Freq) * time.Second
+	a.InvFrequency = invFrequency
+	a.CollectorTimeout = time.Duration(c.CollectorTimeout) * time.Second
+	a.TimeoutLimit = failureLimit
+	a.ErrorLimit = failureLimit
+	a.telemetry = gometrics.NewRegistry()
+	if a.hostname, err = os.Hostname(); err != nil {
+		a.hostname = "unidentified"
+	}
+	a.lastInventory.Map = make(map[string][]byte)
+	a.latestMetrics.Map = make(map[string]*metric)
+	a.metricMetadata.Map = make(map[string]map[string]string)
+	a.Skipmap = newSkipmap(c.SkipStr)
+	a.initCollectors()
+	return nil
+}
+
+// setHostRoot makes collectors read the files of the host under -host-root, if given. Otherwise the
+// host root is taken from the environment
+func (a *Agent) setHostRoot() error {
+	if a.Config.HostRoot == "" {
+		return nil
+	}
+	root, err := filepath.Abs(a.Config.HostRoot)
+	if err != nil {
+		return err
+	}
+	a.Config.HostRoot = root
+	collectors.SetHostRoot(root)
+	return nil
+}
+
+// listCollectors prints the collectors, whether they are ready to run, skipped or unavailable, and why
+func (a *Agent) listCollectors(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %v\n", args)
+		return exitUsage
+	}
+	if err := a.setupCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	d := detectDistro(collectors.HostPath(osRelease))
+	var missing []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKIND\tTYPE\tSTATUS\tDETAIL")
+	for _, name := range a.allCollectorNames() {
+		kind, u := a.collectorByName(name)
+		status, detail := "ready", ""
+		if _, skip := a.Skipmap[name]; skip {
+			status = "skipped"
+		} else if _, skipAll := a.Skipmap["all"]; skipAll {
+			status = "skipped"
+		}
+		if err := u.check(a); err != nil {
+			if status == "ready" {
+				status = "unavailable"
+			}
+			detail = err.Error()
+			if deps := u.missingDependencies(a.ctx); len(deps) > 0 {
+				detail += fmt.Sprintf(" (package %s)", strings.Join(d.packages(deps), " "))
+				missing = append(missing, deps...)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, kind, u.collectorType, status, detail)
+	}
+	w.Flush()
+	if len(missing) > 0 {
+		name := d.Name
+		if name == "" {
+			name = "this system"
+		}
+		fmt.Printf("\nTo install missing dependencies on %s:\n  %s\n", name, d.installCommand(d.packages(missing)))
+	}
+	return exitOK
+}
+
+// runCollectors runs the named collectors once, also skipped ones, and prints their results. It
+// fails if a collector can't run or its collection failed
+func (a *Agent) runCollectors(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no collector given")
+		return exitUsage
+	}
+	if err := a.setupCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if _, _, err := a.lookupCollectors(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, name := range args {
+		if err := a.runCollector(os.Stdout, name); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			code = exitFailure
+		}
+	}
+	return code
+}
+
+// runCollector runs the named collector and writes its result to w. Metric samples are written
+// as a table, inventory as indented JSON
+func (a *Agent) runCollector(w io.Writer, name string) error {
+	kind, u := a.collectorByName(name)
+	if err := u.check(a); err != nil {
+		return fmt.Errorf("can't run, %v", err)
+	}
+
+	start := time.Now()
+	if kind == classInventory {
+		inv := handleInventoryCollection(a.ctx, a.inventoryCollectors.List[name])
+		switch {
+		case inv.Timeout:
+			return fmt.Errorf("timeout after %v", u.timeout)
+		case inv.err != nil:
+			return inv.err
+		}
+		fmt.Fprintf(w, "== %s (%s, %v)\n", name, kind, time.Since(start).Round(time.Millisecond))
+		var out bytes.Buffer
+		if err := json.Indent(&out, inv.Data, "", "  "); err != nil {
+			out.Write(inv.Data)
+		}
+		fmt.Fprintln(w, out.String())
+		return nil
+	}
+
+	// rates need two collections, so counters are shown raw
+	c := a.metricCollectors.List[name]
+	m := handleMetricCollection(a.ctx, c, c.timeout, c.frequency, counterModeRaw)
+	switch {
+	case m.Timeout:
+		return fmt.Errorf("timeout after %v", u.timeout)
+	case m.Err != nil:
+		return m.Err
+	}
+	fmt.Fprintf(w, "== %s (%s, %v)\n", name, kind, time.Since(start).Round(time.Millisecond))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tVALUE\tTYPE\tKIND\tUNIT")
+	for _, mr := range m.Data {
+		for i := range mr.Samples {
+			s := &mr.Samples[i]
+			column := s.ColumnName()
+			if mr.Sufix != "" {
+				column = strings.TrimPrefix(mr.Sufix, ".") + " " + column
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", column, s.FormatValue(), s.Type, s.Kind, s.Unit)
+		}
+	}
+	return tw.Flush()
+}
+
+// recordCommands runs the named collectors, or all which are not skipped, and saves the commands they
+// ran, with their outputs, into a bundle. Failed collectors are reported, but are recorded too
+func (a *Agent) recordCommands(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no bundle directory given")
+		return exitUsage
+	}
+	recorder := collectors.NewRecorder(collectors.ExecRunner{})
+	a.ctx = collectors.WithRunner(a.ctx, recorder)
+	names, code := a.setupCollectorArgs(args[1:])
+	if code != exitOK {
+		return code
+	}
+
+	for _, name := range names {
+		if err := a.runCollector(ioutil.Discard, name); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		}
+	}
+	if err := recorder.Save(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "can't save bundle, %v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("commands of %d collectors recorded in %s\n", len(names), args[0])
+	return exitOK
+}
+
+// replayCommands runs the named collectors, or all which are not skipped, with the command outputs of
+// a bundle and prints their results like run
+func (a *Agent) replayCommands(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no bundle directory given")
+		return exitUsage
+	}
+	replayer, err := collectors.LoadReplayer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't load bundle, %v\n", err)
+		return exitFailure
+	}
+	a.ctx = collectors.WithRunner(a.ctx, replayer)
+	names, code := a.setupCollectorArgs(args[1:])
+	if code != exitOK {
+		return code
+	}
+
+	for _, name := range names {
+		if err := a.runCollector(os.Stdout, name); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			code = exitFailure
+		}
+	}
+	return code
+}
+
+// setupCollectorArgs sets up the collectors and returns the named ones, or all which are not skipped
+// if none is named, and the exit code
+func (a *Agent) setupCollectorArgs(names []string) ([]string, int) {
+	if err := a.setupCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitFailure
+	}
+	if len(names) > 0 {
+		if _, _, err := a.lookupCollectors(names); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, exitUsage
+		}
+		return names, exitOK
+	}
+	if _, skipAll := a.Skipmap["all"]; skipAll {
+		return nil, exitOK
+	}
+	for _, name := range a.allCollectorNames() {
+		if _, skip := a.Skipmap[name]; !skip {
+			names = append(names, name)
+		}
+	}
+	return names, exitOK
+}
+
+// validateConfig checks the config like the agent does at start, and the files it refers to
+func (a *Agent) validateConfig(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %v\n", args)
+		return exitUsage
+	}
+	c := a.Config
+	var errs []error
+	if err := c.CheckErrs(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newDestinations(c); err != nil {
+		errs = append(errs, fmt.Errorf("invalid value passed to flag -destination. %v", err))
+	}
+	if _, err := loadTrustedKeys(c.TrustedKeys); err != nil {
+		errs = append(errs, fmt.Errorf("invalid value passed to flag -trusted-keys. %v", err))
+	}
+	if _, err := newExecPolicy(c); err != nil {
+		errs = append(errs, fmt.Errorf("invalid execution policy. %v", err))
+	}
+	registry.RLock()
+	for _, name := range strings.Split(c.SkipStr, ",") {
+		if name = strings.TrimSpace(name); name == "" || name == "all" || name == telemetryCollector {
+			continue
+		}
+		_, isInventory := inventory.Collectors[name]
+		if _, isMetric := metricCollectors[name]; !isInventory && !isMetric {
+			errs = append(errs, fmt.Errorf("invalid value passed to flag -skip. Collector %s not found", name))
+		}
+	}
+	registry.RUnlock()
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitFailure
+	}
+	fmt.Println("config is valid")
+	return exitOK
+}
+
+// showNodeID prints the node ID stored in the node.id file under -chdir
+func (a *Agent) showNodeID(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %v\n", args)
+		return exitUsage
+	}
+	nodeID, err := a.Config.ReadNodeID()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no node ID in %s: %v\n", filepath.Join(a.Config.Chdir, "node.id"), err)
+		return exitFailure
+	}
+	fmt.Println(nodeID)
+	return exitOK
+}
+
+// allCollectorNames returns the sorted names of all metric and inventory collectors
+func (a *Agent) allCollectorNames() []string {
+	var names []string
+	for name := range a.metricCollectors.List {
+		names = append(names, name)
+	}
+	for name := range a.inventoryCollectors.List {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectorByName returns the kind and the base of the named collector, which must exist
+func (a *Agent) collectorByName(name string) (string, *BaseCollector) {
+	if c, ok := a.metricCollectors.List[name]; ok {
+		return classMetric, &c.BaseCollector
+	}
+	return classInventory, &a.inventoryCollectors.List[name].BaseCollector
+}
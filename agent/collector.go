@@ -1,10 +1,14 @@
 package agent
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
@@ -24,27 +28,111 @@ const (
 
 // Precheck validates dependencies needed for collection by collectors
 func (u *BaseCollector) Precheck(a *Agent) error {
-	var missDeps []string
-	for _, dep := range u.dependencies {
-		_, err := exec.LookPath(dep)
-		if err != nil {
-			missDeps = append(missDeps, dep)
+	err := u.check(a)
+	if err != nil {
+		err = fmt.Errorf("Collector %s will not run because %v", u.name, err)
+		if !a.standalone {
+			log.Error(err.Error())
 		}
 	}
-	if len(missDeps) > 0 {
+	return err
+}
+
+// check returns why the collector can't run, or nil if its dependencies are installed and its precheck passed
+func (u *BaseCollector) check(a *Agent) error {
+	if missDeps := u.missingDependencies(a.ctx); len(missDeps) > 0 {
 		errorDeps := strings.Join(missDeps, ", ")
-		er := fmt.Errorf("Collector %s will not run because miss dependency: %s", u.name, errorDeps)
-		log.Error(er.Error())
-		return er
+		return fmt.Errorf("miss dependency: %s", errorDeps)
 	}
 	if u.precheck == nil {
 		return nil
 	}
-	err := u.precheck()
-	if err != nil {
-		er := fmt.Errorf("Collector %s will not run because it failed precheck: %v", u.name, err)
-		log.Error(er.Error())
-		return er
+	ctx, cancel := context.WithTimeout(a.ctx, u.timeout)
+	defer cancel()
+	if err := u.precheck(ctx); err != nil {
+		return fmt.Errorf("it failed precheck: %v", err)
 	}
 	return nil
 }
+
+// missingDependencies returns the dependencies of the collector which are not found in PATH by the
+// runner of ctx
+func (u *BaseCollector) missingDependencies(ctx context.Context) []string {
+	var missDeps []string
+	for _, dep := range u.dependencies {
+		if _, err := collectors.LookPath(ctx, dep); err != nil {
+			missDeps = append(missDeps, dep)
+		}
+	}
+	return missDeps
+}
+
+// initialState returns the state a built-in collector starts in, it is stopped when skipped or
+// when its precheck fails
+func (a *Agent) initialState(u *BaseCollector) string {
+	if a.isSkipped(u.name) || a.isSkipped("all") || u.Precheck(a) != nil {
+		return stopState
+	}
+	return runningState
+}
+
+// collectorSetting is a value set for collectors whose name matches the pattern
+type collectorSetting struct {
+	pattern string
+	value   time.Duration
+}
+
+// parseCollectorSettings parses comma separated list of name=seconds, name may be a glob pattern
+func parseCollectorSettings(str string) ([]collectorSetting, error) {
+	var settings []collectorSetting
+	for _, item := range strings.Split(str, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected name=seconds, but given %q", item)
+		}
+		pattern := strings.TrimSpace(parts[0])
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid collector name pattern %q", pattern)
+		}
+		secs, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("invalid number of seconds in %q", item)
+		}
+		settings = append(settings, collectorSetting{pattern: pattern, value: time.Duration(secs) * time.Second})
+	}
+	return settings, nil
+}
+
+// lookupSetting returns value of the first setting matching collector name, or def if none matches
+func lookupSetting(settings []collectorSetting, name string, def time.Duration) time.Duration {
+	for _, s := range settings {
+		if ok, _ := path.Match(s.pattern, name); ok {
+			return s.value
+		}
+	}
+	return def
+}
+
+// metricFrequency returns frequency of metric collector name
+func (a *Agent) metricFrequency(name string) time.Duration {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	return lookupSetting(a.collectorFreqs, name, a.MetricFrequency)
+}
+
+// inventoryFrequency returns frequency of inventory collector name
+func (a *Agent) inventoryFrequency(name string) time.Duration {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	return lookupSetting(a.collectorFreqs, name, a.InvFrequency)
+}
+
+// collectorTimeout returns timeout of collector name
+func (a *Agent) collectorTimeout(name string) time.Duration {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	return lookupSetting(a.collectorTimeouts, name, a.CollectorTimeout)
+}
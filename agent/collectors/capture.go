@@ -0,0 +1,455 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// files and directories of a capture
+const (
+	CaptureJournal = "inputs.jsonl" // events, one JSON object per line
+	captureData    = "data"         // file contents and command outputs
+	captureRoot    = "root"         // files, directories and links of the host looked at by collectors
+)
+
+// kinds of the capture events of collector inputs
+const (
+	EventLookPath = "lookpath" // executable looked up, Key is the file
+	EventCommand  = "command"  // command run, Args has its name and args
+	EventFile     = "file"     // file read, Key is its path
+	EventStatfs   = "statfs"   // file system statistics read, Key is the path
+	EventClock    = "clock"    // time read, Key is the collector
+)
+
+// maxLinks is the max number of symbolic links followed from a path visited by a capture
+const maxLinks = 40
+
+// CaptureEvent is an input of a collector, or an event of the agent, in a capture
+type CaptureEvent struct {
+	Time     time.Time       `json:"time"`
+	Kind     string          `json:"kind"`
+	Key      string          `json:"key,omitempty"`      // file, path or collector
+	Args     []string        `json:"args,omitempty"`     // name and args of a command
+	Path     string          `json:"path,omitempty"`     // path of an executable looked up
+	Data     string          `json:"data,omitempty"`     // file of the capture with file content or stdout
+	Stderr   string          `json:"stderr,omitempty"`   // file of the capture with stderr
+	ExitCode int             `json:"exitCode,omitempty"` // of a command
+	Killed   bool            `json:"killed,omitempty"`   // a command was killed
+	Error    string          `json:"error,omitempty"`
+	Errno    int             `json:"errno,omitempty"` // of a failed file access, so that it is replayed with its type
+	Value    json.RawMessage `json:"value,omitempty"` // statfs result, or the data of an event of the agent
+
+	data, stderr []byte
+}
+
+// key returns the key of the event, events with the same key are replayed in the order they were
+// recorded
+func (e *CaptureEvent) key() string {
+	if e.Kind == EventCommand {
+		return e.Kind + "\x00" + strings.Join(e.Args, "\x00")
+	}
+	return e.Kind + "\x00" + e.Key
+}
+
+// setError sets the error of the event, with its errno if it has one
+func (e *CaptureEvent) setError(err error) {
+	if err == nil {
+		return
+	}
+	e.Error = err.Error()
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		e.Errno = int(errno)
+	}
+}
+
+// err returns the error of the event for an operation on path
+func (e *CaptureEvent) err(op, path string) error {
+	switch {
+	case e.Errno != 0:
+		return &os.PathError{Op: op, Path: path, Err: syscall.Errno(e.Errno)}
+	case e.Error != "":
+		return errors.New(e.Error)
+	}
+	return nil
+}
+
+type collectorKey struct{}
+
+// WithCollector returns a context of a collection of the named collector
+func WithCollector(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, collectorKey{}, name)
+}
+
+// CollectorFrom returns the name of the collector of ctx, empty if it has none
+func CollectorFrom(ctx context.Context) string {
+	name, _ := ctx.Value(collectorKey{}).(string)
+	return name
+}
+
+// Clock is implemented by runners which record or replay the time collectors read
+type Clock interface {
+	Now(ctx context.Context) time.Time
+}
+
+// Now returns the current time, by the clock of the runner of ctx if it has one
+func Now(ctx context.Context) time.Time {
+	if c, ok := RunnerFrom(ctx).(Clock); ok {
+		return c.Now(ctx)
+	}
+	return time.Now()
+}
+
+// Capture records the inputs of collectors, the commands they run, the files they read and the time,
+// as events with timestamps in a directory, so that they can be replayed by a CaptureReplay. It is
+// a Runner and a FileSource. The contents of the files are in the journal, the directories and links
+// of the host collectors looked at are copied into the capture with empty files
+type Capture struct {
+	dir     string
+	runner  Runner
+	mtx     sync.Mutex
+	journal *os.File
+	enc     *json.Encoder
+	n       int             // number of data files
+	visited map[string]bool // paths copied into the capture
+}
+
+// NewCapture returns a capture into directory dir, which is created if needed, of the commands run
+// by r and the files of the host
+func NewCapture(dir string, r Runner) (*Capture, error) {
+	for _, d := range []string{captureData, captureRoot} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(dir, CaptureJournal), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{dir: dir, runner: r, journal: f, enc: json.NewEncoder(f), visited: make(map[string]bool)}, nil
+}
+
+// Record writes event e into the journal, its time is set if it is zero
+func (c *Capture) Record(e *CaptureEvent) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.journal == nil {
+		return errors.New("capture is closed")
+	}
+	for _, out := range []struct {
+		file *string
+		data []byte
+	}{{&e.Data, e.data}, {&e.Stderr, e.stderr}} {
+		if len(out.data) == 0 {
+			continue
+		}
+		c.n++
+		*out.file = filepath.Join(captureData, fmt.Sprintf("%06d", c.n))
+		if err := ioutil.WriteFile(filepath.Join(c.dir, *out.file), out.data, 0644); err != nil {
+			return err
+		}
+	}
+	return c.enc.Encode(e)
+}
+
+// Close closes the journal, events recorded after are dropped
+func (c *Capture) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.journal == nil {
+		return nil
+	}
+	err := c.journal.Close()
+	c.journal = nil
+	return err
+}
+
+// LookPath searches for executable file with the captured runner and records the result
+func (c *Capture) LookPath(file string) (string, error) {
+	path, err := c.runner.LookPath(file)
+	e := &CaptureEvent{Kind: EventLookPath, Key: file, Path: path}
+	e.setError(err)
+	c.Record(e)
+	return path, err
+}
+
+// Run runs name with args with the captured runner and records the result
+func (c *Capture) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	start := time.Now()
+	res, err := c.runner.Run(ctx, opts, name, args...)
+	e := &CaptureEvent{Time: start, Kind: EventCommand, Args: append([]string{name}, args...), ExitCode: -1}
+	if res != nil {
+		e.data, e.stderr, e.ExitCode, e.Killed = res.Stdout, res.Stderr, res.ExitCode, res.Killed
+	}
+	e.setError(err)
+	c.Record(e)
+	return res, err
+}
+
+// Now returns the current time and records it for the collector of ctx
+func (c *Capture) Now(ctx context.Context) time.Time {
+	now := time.Now()
+	c.Record(&CaptureEvent{Time: now, Kind: EventClock, Key: CollectorFrom(ctx)})
+	return now
+}
+
+// Root is /, files are read on the host
+func (c *Capture) Root() string {
+	return "/"
+}
+
+// ReadFile reads file name and records its content
+func (c *Capture) ReadFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	c.Visit(name)
+	e := &CaptureEvent{Kind: EventFile, Key: absPath(name), data: data}
+	e.setError(err)
+	c.Record(e)
+	return data, err
+}
+
+// Statfs returns statistics of the file system of path and records them
+func (c *Capture) Statfs(path string) (*FSStat, error) {
+	st, err := statfs(path)
+	e := &CaptureEvent{Kind: EventStatfs, Key: absPath(path)}
+	e.setError(err)
+	if st != nil {
+		e.Value, _ = json.Marshal(st)
+	}
+	c.Record(e)
+	return st, err
+}
+
+// Visit copies path name, its parents and the paths its links point to into the capture
+func (c *Capture) Visit(name string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.visit(absPath(name), 0)
+}
+
+// visit copies absolute path name into the capture, links have been followed depth times
+func (c *Capture) visit(name string, depth int) {
+	if c.visited[name] || depth > maxLinks {
+		return
+	}
+	c.visited[name] = true
+	if parent := filepath.Dir(name); parent != name {
+		c.visit(parent, depth)
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return
+	}
+	dst := filepath.Join(c.dir, captureRoot, name)
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return
+		}
+		// relative links are resolved from the directory the link is in, after its own links
+		dir, err := filepath.EvalSymlinks(filepath.Dir(name))
+		if err != nil {
+			return
+		}
+		if filepath.IsAbs(target) {
+			// the link must stay in the capture
+			if rel, err := filepath.Rel(dir, target); err == nil {
+				os.Symlink(rel, dst)
+			}
+		} else {
+			os.Symlink(target, dst)
+			target = filepath.Join(dir, target)
+		}
+		c.visit(target, depth+1)
+	case fi.IsDir():
+		os.MkdirAll(dst, 0755)
+	default:
+		// the contents of files are in the journal
+		if f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err == nil {
+			f.Close()
+		}
+	}
+}
+
+// CaptureReplay serves the inputs of collectors recorded by a Capture. It is a Runner and a
+// FileSource. An input read more often than it was recorded gets its last value again
+type CaptureReplay struct {
+	dir    string
+	events []*CaptureEvent
+	mtx    sync.Mutex
+	inputs map[string][]*CaptureEvent // events by key
+	next   map[string]int             // index of next event by key
+}
+
+// OpenCapture returns a replay of the capture in directory dir
+func OpenCapture(dir string) (*CaptureReplay, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, CaptureJournal))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &CaptureReplay{dir: dir, inputs: make(map[string][]*CaptureEvent), next: make(map[string]int)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		e := &CaptureEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("invalid %s line %d: %v", CaptureJournal, line, err)
+		}
+		r.events = append(r.events, e)
+		r.inputs[e.key()] = append(r.inputs[e.key()], e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Events returns the events of the capture in the order they were recorded
+func (r *CaptureReplay) Events() []*CaptureEvent {
+	return r.events
+}
+
+// Next returns the next event of kind with key, and false if there is none
+func (r *CaptureReplay) Next(kind, key string) (*CaptureEvent, bool) {
+	k := (&CaptureEvent{Kind: kind, Key: key}).key()
+	return r.nextOf(k)
+}
+
+// nextOf returns the next event with key k
+func (r *CaptureReplay) nextOf(k string) (*CaptureEvent, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	events := r.inputs[k]
+	if len(events) == 0 {
+		return nil, false
+	}
+	i := r.next[k]
+	if i < len(events)-1 {
+		r.next[k]++
+	}
+	return events[i], true
+}
+
+// load reads file of the capture, empty is no data
+func (r *CaptureReplay) load(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(filepath.Join(r.dir, file))
+}
+
+// LookPath returns the recorded path of executable file
+func (r *CaptureReplay) LookPath(file string) (string, error) {
+	e, ok := r.Next(EventLookPath, file)
+	if !ok {
+		return "", &os.PathError{Op: "lookpath", Path: file, Err: errors.New("executable file not found in capture")}
+	}
+	if e.Error != "" {
+		return "", &os.PathError{Op: "lookpath", Path: file, Err: errors.New(e.Error)}
+	}
+	return e.Path, nil
+}
+
+// Run returns the recorded result of name with args
+func (r *CaptureReplay) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	e, ok := r.nextOf((&CaptureEvent{Kind: EventCommand, Args: append([]string{name}, args...)}).key())
+	if !ok {
+		return nil, fmt.Errorf("%s: command not in capture: %s", name, strings.Join(append([]string{name}, args...), " "))
+	}
+	stdout, err := r.load(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := r.load(e.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Stdout: stdout, Stderr: stderr, ExitCode: e.ExitCode, Killed: e.Killed}, e.err("exec", name)
+}
+
+// Now returns the next time recorded for the collector of ctx, or the current time if none was
+func (r *CaptureReplay) Now(ctx context.Context) time.Time {
+	if e, ok := r.Next(EventClock, CollectorFrom(ctx)); ok {
+		return e.Time
+	}
+	return time.Now()
+}
+
+// Root is the directory of the capture the host root is under
+func (r *CaptureReplay) Root() string {
+	return filepath.Join(r.dir, captureRoot)
+}
+
+// hostName returns the recorded path of name, a path under the root of the replay
+func (r *CaptureReplay) hostName(name string) string {
+	abs := absPath(name)
+	rel, err := filepath.Rel(r.Root(), abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return abs
+	}
+	return filepath.Join("/", rel)
+}
+
+// ReadFile returns the next recorded content of file name, or the content of the copy of the file
+// in the capture if it was not read when recording
+func (r *CaptureReplay) ReadFile(name string) ([]byte, error) {
+	e, ok := r.Next(EventFile, r.hostName(name))
+	if !ok {
+		return ioutil.ReadFile(name)
+	}
+	if err := e.err("open", name); err != nil {
+		return nil, err
+	}
+	data, err := r.load(e.Data)
+	if data == nil && err == nil {
+		data = []byte{}
+	}
+	return data, err
+}
+
+// Statfs returns the next recorded statistics of the file system of path
+func (r *CaptureReplay) Statfs(path string) (*FSStat, error) {
+	e, ok := r.Next(EventStatfs, r.hostName(path))
+	if !ok {
+		return nil, &os.PathError{Op: "statfs", Path: path, Err: syscall.ENOENT}
+	}
+	if err := e.err("statfs", path); err != nil {
+		return nil, err
+	}
+	st := &FSStat{}
+	if err := json.Unmarshal(e.Value, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Visit does nothing, the directories and links of the capture are read
+func (r *CaptureReplay) Visit(name string) {}
+
+// absPath returns the absolute path of name, or name if it has none
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
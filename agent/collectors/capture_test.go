@@ -0,0 +1,76 @@
+package collectors
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	host := t.TempDir()
+	stat := filepath.Join(host, "proc", "stat")
+	os.MkdirAll(filepath.Dir(stat), 0755)
+	os.MkdirAll(filepath.Join(host, "sys", "block", "sda"), 0755)
+	os.MkdirAll(filepath.Join(host, "sys", "class", "block"), 0755)
+	os.Symlink("../../block/sda", filepath.Join(host, "sys", "class", "block", "sda"))
+	ioutil.WriteFile(stat, []byte("one"), 0644)
+	defer SetHostRoot("")
+	defer SetFileSource(nil)
+	SetHostRoot(host)
+
+	dir := t.TempDir()
+	capture, err := NewCapture(dir, &fakeRunner{})
+	if err != nil {
+		t.Fatalf("NewCapture() error: %v", err)
+	}
+	SetFileSource(capture)
+	ctx := WithCollector(WithRunner(context.Background(), capture), "cpu")
+	ReadFile(HostPath("/proc/stat"))
+	ioutil.WriteFile(stat, []byte("two"), 0644)
+	ReadFile(HostPath("/proc/stat"))
+	ReadFile(HostPath("/proc/missing"))
+	ReadDir(HostPath("/sys/class/block"))
+	Output(ctx, "/usr/bin/tool")
+	recorded := Now(ctx)
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	// the replay reads nothing of the host
+	os.RemoveAll(host)
+
+	replay, err := OpenCapture(dir)
+	if err != nil {
+		t.Fatalf("OpenCapture() error: %v", err)
+	}
+	SetFileSource(replay)
+	ctx = WithCollector(WithRunner(context.Background(), replay), "cpu")
+	// the last content of a file is repeated
+	for _, want := range []string{"one", "two", "two"} {
+		if data, err := ReadFile(HostPath("/proc/stat")); err != nil || string(data) != want {
+			t.Errorf("ReadFile(/proc/stat) = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := ReadFile(HostPath("/proc/missing")); !os.IsNotExist(err) {
+		t.Errorf("ReadFile(/proc/missing) error = %v, want not exist", err)
+	}
+	fis, err := ReadDir(HostPath("/sys/class/block"))
+	if err != nil || len(fis) != 1 || fis[0].Name() != "sda" {
+		t.Fatalf("ReadDir(/sys/class/block) = %v, %v, want sda", fis, err)
+	}
+	path, err := EvalSymlinks(HostPath("/sys/class/block/sda"))
+	if err != nil || HostRel(path) != "/sys/block/sda" {
+		t.Errorf("EvalSymlinks(/sys/class/block/sda) = %q, %v, want /sys/block/sda on the host", path, err)
+	}
+	if out, err := Output(ctx, "/usr/bin/tool"); err != nil || string(out) != "call 1\n" {
+		t.Errorf("tool = %q, %v, want call 1", out, err)
+	}
+	if now := Now(ctx); !now.Equal(recorded) {
+		t.Errorf("Now() = %v, want %v", now, recorded)
+	}
+	if !strings.HasPrefix(HostRoot(), replay.Root()) {
+		t.Errorf("HostRoot() = %q, want it under %q", HostRoot(), replay.Root())
+	}
+}
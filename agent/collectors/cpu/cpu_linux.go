@@ -1,42 +1,42 @@
 package cpu
 
 import (
-	"io/ioutil"
 	"strings"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
+// Linux v2.6.33+
+var cpuColumns = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
+
 func loader() ([]byte, error) {
-	return ioutil.ReadFile("/proc/stat")
+	return collectors.ReadFile(collectors.HostPath("/proc/stat"))
 }
 
 func preformatter(data []byte) ([]*collectors.MetricResult, error) {
-	// Linux v2.6.33+
-	cpuColumns := []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
 	metadataProto := map[string]string{
-		"user":          "int Time spent in user mode",
-		"nice":          "int Time spent in user mode with low priority (nice)",
-		"system":        "int Time spent in system mode.",
-		"idle":          "int Time spent in the idle task",
-		"iowait":        "int Time waiting for I/O to complete",
-		"irq":           "int Time servicing interrupts",
-		"softirq":       "int Time servicing softirqs",
-		"steal":         "int Stolen time, which is the time spent in other operating systems when running in a virtualized environment",
-		"guest":         "int Time spent running a virtual CPU",
-		"guest_nice":    "int Time spent running a niced guest",
-		"intr":          "int Counts of interrupts serviced since boot time",
-		"ctxt":          "int Total number of context switches across all CPUs",
-		"btime":         "int Time at which the system booted, in seconds since the Unix epoch (January 1, 1970)",
-		"processes":     "int Number of processes and threads",
-		"procs_running": "int Number of processes currently running on CPUs",
-		"procs_blocked": "int Number of processes currently blocked, waiting for I/O to complete",
+		"user":          "Time spent in user mode",
+		"nice":          "Time spent in user mode with low priority (nice)",
+		"system":        "Time spent in system mode.",
+		"idle":          "Time spent in the idle task",
+		"iowait":        "Time waiting for I/O to complete",
+		"irq":           "Time servicing interrupts",
+		"softirq":       "Time servicing softirqs",
+		"steal":         "Stolen time, which is the time spent in other operating systems when running in a virtualized environment",
+		"guest":         "Time spent running a virtual CPU",
+		"guest_nice":    "Time spent running a niced guest",
+		"intr":          "Counts of interrupts serviced since boot time",
+		"ctxt":          "Total number of context switches across all CPUs",
+		"btime":         "Time at which the system booted, in seconds since the Unix epoch (January 1, 1970)",
+		"processes":     "Number of processes and threads",
+		"procs_running": "Number of processes currently running on CPUs",
+		"procs_blocked": "Number of processes currently blocked, waiting for I/O to complete",
 	}
-	metadata := map[string]string{}
+	// cumulative values, the others are gauges
+	counters := map[string]bool{"intr": true, "ctxt": true, "processes": true, "softirq": true}
 	lines := strings.Split(string(data), "\n")
-	headers := make([]string, 0)
-	metrics := make([]string, 0)
+	samples := make([]collectors.Sample, 0)
 	// Could we handle all of the file's columns?
 	allCols := true
 	for _, line := range lines {
@@ -53,11 +53,11 @@ func preformatter(data []byte) ([]*collectors.MetricResult, error) {
 					log.Infof("more columns than expected while collecting from /proc/stat")
 					break
 				}
-				headers = append(headers, columns[0]+"."+cpuColumns[i])
-				metrics = append(metrics, value)
-				if v, ok := metadataProto[cpuColumns[i]]; ok {
-					metadata[columns[0]+"."+cpuColumns[i]] = v
-				}
+				s := collectors.NewSample(cpuColumns[i], value, collectors.IntValue, collectors.Label{Name: "cpu", Value: columns[0]})
+				s.Kind = collectors.Counter
+				s.Unit = "jiffies"
+				s.Help = metadataProto[cpuColumns[i]]
+				samples = append(samples, s)
 				end = i
 			}
 
@@ -65,11 +65,15 @@ func preformatter(data []byte) ([]*collectors.MetricResult, error) {
 				allCols = false
 			}
 		} else {
-			headers = append(headers, columns[0])
-			metrics = append(metrics, columns[1])
-			if v, ok := metadataProto[columns[0]]; ok {
-				metadata[columns[0]] = v
+			s := collectors.NewSample(columns[0], columns[1], collectors.IntValue)
+			if counters[columns[0]] {
+				s.Kind = collectors.Counter
+			}
+			if columns[0] == "btime" {
+				s.Unit = "seconds"
 			}
+			s.Help = metadataProto[columns[0]]
+			samples = append(samples, s)
 		}
 	}
 
@@ -77,6 +81,5 @@ func preformatter(data []byte) ([]*collectors.MetricResult, error) {
 		log.Infof("fewer columns than expected while collecting from /proc/stat")
 	}
 
-	result := collectors.BuildMetricResult(strings.Join(headers, " "), strings.Join(metrics, " "), "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	return []*collectors.MetricResult{{Samples: samples}}, nil
 }
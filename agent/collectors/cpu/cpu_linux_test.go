@@ -0,0 +1,57 @@
+package cpu
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host string
+		cpus int
+		user float64 // jiffies in user mode of all cpus
+	}{
+		{"linux-3.10", 2, 2100},
+		{"linux-4.19", 4, 4600},
+		{"linux-5.15", 8, 10800},
+		{"linux-6.1", 2, 2100},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+
+			cpuSamples := 0
+			for i := range res[0].Samples {
+				if res[0].Samples[i].Label("cpu") != "" {
+					cpuSamples++
+				}
+			}
+			if want := (tc.cpus + 1) * len(cpuColumns); cpuSamples != want {
+				t.Errorf("got %d cpu samples, want %d", cpuSamples, want)
+			}
+			index := collectors.IndexSamples(res[0].Samples)
+			if s := index["cpu.user"]; s == nil || s.Value != tc.user || s.Kind != collectors.Counter {
+				t.Errorf("cpu.user is %+v, want counter of %v", s, tc.user)
+			}
+			if s := index["procs_running"]; s == nil || s.Value != 2 || s.Kind != collectors.Gauge {
+				t.Errorf("procs_running is %+v, want gauge of 2", s)
+			}
+			if s := index["ctxt"]; s == nil || s.Kind != collectors.Counter {
+				t.Errorf("ctxt is %+v, want counter", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package cpu
+
+import (
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// time columns adding up to the total time of a cpu, guest time is already accounted in user and nice
+var cpuTimeColumns = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal"}
+
+// Rates returns the share in percent of each time column of every cpu and its utilisation since
+// the previous collection, and the per second rates of the other counters
+func Rates(prev, cur []collectors.Sample) []collectors.Sample {
+	old := collectors.IndexSamples(prev)
+	var cpus []string
+	deltas := make(map[string]map[string]float64)
+	reset := make(map[string]bool)
+	other := make([]collectors.Sample, 0)
+	for i := range cur {
+		s := &cur[i]
+		cpu := s.Label("cpu")
+		if cpu == "" {
+			other = append(other, *s)
+			continue
+		}
+		p, ok := old[s.ColumnName()]
+		if !ok {
+			continue
+		}
+		d, ok := collectors.Delta(p, s)
+		if !ok {
+			reset[cpu] = true
+			continue
+		}
+		if deltas[cpu] == nil {
+			cpus = append(cpus, cpu)
+			deltas[cpu] = make(map[string]float64)
+		}
+		deltas[cpu][s.Name] = d
+	}
+
+	rates := make([]collectors.Sample, 0)
+	for _, cpu := range cpus {
+		d := deltas[cpu]
+		var total float64
+		for _, col := range cpuTimeColumns {
+			total += d[col]
+		}
+		if reset[cpu] || total <= 0 {
+			continue
+		}
+
+		label := collectors.Label{Name: "cpu", Value: cpu}
+		for _, col := range cpuColumns {
+			if v, ok := d[col]; ok {
+				rates = append(rates, collectors.DerivedSample(col+"Pct", 100*v/total, "%", "Share of "+col+" time", label))
+			}
+		}
+		busy := total - d["idle"] - d["iowait"]
+		rates = append(rates, collectors.DerivedSample("utilisationPct", 100*busy/total, "%", "Share of time not idle or waiting for I/O", label))
+	}
+
+	return append(rates, collectors.CounterRates(prev, other)...)
+}
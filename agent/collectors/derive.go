@@ -0,0 +1,95 @@
+package collectors
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MetricDeriver returns samples derived from two successive collections of counters, e.g. rates
+type MetricDeriver func(prev, cur []Sample) []Sample
+
+// IndexSamples returns the samples by column name
+func IndexSamples(samples []Sample) map[string]*Sample {
+	index := make(map[string]*Sample, len(samples))
+	for i := range samples {
+		index[samples[i].ColumnName()] = &samples[i]
+	}
+	return index
+}
+
+// Delta returns the increase of counter cur since prev. ok is false when the counter went down,
+// it was reset by a reboot or a driver reload or it wrapped around, and the increase is unknown.
+// Int counters are subtracted as collected, they may be beyond the precision of float64
+func Delta(prev, cur *Sample) (delta float64, ok bool) {
+	if p, c, ok := uintValues(prev, cur); ok {
+		if c < p {
+			return 0, false
+		}
+		return float64(c - p), true
+	}
+	if cur.Value < prev.Value {
+		return 0, false
+	}
+	return cur.Value - prev.Value, true
+}
+
+// uintValues returns the values of int samples prev and cur as collected, ok is false if they are
+// not both unsigned integers
+func uintValues(prev, cur *Sample) (p, c uint64, ok bool) {
+	if prev.Type != IntValue || cur.Type != IntValue {
+		return 0, 0, false
+	}
+	p, perr := strconv.ParseUint(prev.Text, 10, 64)
+	c, cerr := strconv.ParseUint(cur.Text, 10, 64)
+	return p, c, perr == nil && cerr == nil
+}
+
+// Interval returns the number of seconds between prev and cur
+func Interval(prev, cur *Sample) float64 {
+	return cur.Timestamp.Sub(prev.Timestamp).Seconds()
+}
+
+// Rate returns the per second increase of counter cur since prev, ok is false when it is unknown
+func Rate(prev, cur *Sample) (rate float64, ok bool) {
+	delta, ok := Delta(prev, cur)
+	dt := Interval(prev, cur)
+	if !ok || dt <= 0 {
+		return 0, false
+	}
+	return delta / dt, true
+}
+
+// DerivedSample returns a float gauge sample, value is rounded to 3 decimal places
+func DerivedSample(name string, value float64, unit, help string, labels ...Label) Sample {
+	value = math.Round(value*1000) / 1000
+	return Sample{Name: name, Labels: labels, Type: FloatValue, Kind: Gauge, Value: value, Unit: unit, Help: help}
+}
+
+// CounterRates returns the per second rate <name>PerSec of each counter in cur which was in prev too.
+// Counters which were reset, and new ones, have no rate until the next collection
+func CounterRates(prev, cur []Sample) []Sample {
+	old := IndexSamples(prev)
+	rates := make([]Sample, 0)
+	for i := range cur {
+		s := &cur[i]
+		p, ok := old[s.ColumnName()]
+		if !ok || s.Kind != Counter || s.Type == StringValue {
+			continue
+		}
+		rate, ok := Rate(p, s)
+		if !ok {
+			continue
+		}
+		unit := "1/s"
+		if s.Unit != "" {
+			unit = s.Unit + "/s"
+		}
+		help := ""
+		if s.Help != "" {
+			help = strings.TrimSuffix(strings.TrimSpace(s.Help), ".") + ", per second"
+		}
+		rates = append(rates, DerivedSample(s.Name+"PerSec", rate, unit, help, s.Labels...))
+	}
+	return rates
+}
@@ -3,7 +3,6 @@ package disk
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -35,28 +34,34 @@ const (
 )
 
 func loader() ([]byte, error) {
-	return ioutil.ReadFile("/proc/diskstats")
+	return collectors.ReadFile(collectors.HostPath("/proc/diskstats"))
 }
 
-func formatProcDiskstats(data string, drivesToInclude map[string]bool) (string, string, map[string]string, error) {
+func formatProcDiskstats(data string, drivesToInclude map[string]bool) ([]collectors.Sample, error) {
 	diskColumns := []string{"readsIssued", "readsMerged", "sectorsRead", "timeReading", "writesCompleted", "writesMerge", "sectorsWritten", "timeWriting", "ioInProgress", "timeDoingIO", "weightedTimeDoingIO"}
 	metadataProto := map[string]string{
-		"readsIssued":         "int Reads completed successfully",
-		"readsMerged":         "int reads merged",
-		"sectorsRead":         "int sectors read",
-		"timeReading":         "int time spent reading (ms)",
-		"writesCompleted":     "int writes completed",
-		"writesMerge":         "int writes merged",
-		"sectorsWritten":      "int sectors written",
-		"timeWriting":         "int time spent writing (ms)",
-		"ioInProgress":        "int I/Os currently in progress",
-		"timeDoingIO":         "int time spent doing I/Os (ms)",
-		"weightedTimeDoingIO": "int weighted time spent doing I/Os (ms)s",
+		"readsIssued":         "Reads completed successfully",
+		"readsMerged":         "reads merged",
+		"sectorsRead":         "sectors read",
+		"timeReading":         "time spent reading (ms)",
+		"writesCompleted":     "writes completed",
+		"writesMerge":         "writes merged",
+		"sectorsWritten":      "sectors written",
+		"timeWriting":         "time spent writing (ms)",
+		"ioInProgress":        "I/Os currently in progress",
+		"timeDoingIO":         "time spent doing I/Os (ms)",
+		"weightedTimeDoingIO": "weighted time spent doing I/Os (ms)s",
+	}
+	units := map[string]string{
+		"sectorsRead":         "sectors",
+		"timeReading":         "ms",
+		"sectorsWritten":      "sectors",
+		"timeWriting":         "ms",
+		"timeDoingIO":         "ms",
+		"weightedTimeDoingIO": "ms",
 	}
 	lines := strings.Split(data, "\n")
-	headers := make([]string, 0)
-	metrics := make([]string, 0)
-	metadata := make(map[string]string)
+	samples := make([]collectors.Sample, 0)
 
 	driveCount := 0
 	for _, line := range lines {
@@ -76,11 +81,13 @@ func formatProcDiskstats(data string, drivesToInclude map[string]bool) (string,
 					break
 				}
 
-				headers = append(headers, driveName+"."+diskColumns[i])
-				metrics = append(metrics, value)
-				if v, ok := metadataProto[diskColumns[i]]; ok {
-					metadata[driveName+"."+diskColumns[i]] = v
+				s := collectors.NewSample(diskColumns[i], value, collectors.IntValue, collectors.Label{Name: "device", Value: driveName})
+				if diskColumns[i] != "ioInProgress" {
+					s.Kind = collectors.Counter
 				}
+				s.Unit = units[diskColumns[i]]
+				s.Help = metadataProto[diskColumns[i]]
+				samples = append(samples, s)
 				columnCount++
 			}
 
@@ -95,10 +102,10 @@ func formatProcDiskstats(data string, drivesToInclude map[string]bool) (string,
 		for drive := range drivesToInclude {
 			drives = append(drives, drive)
 		}
-		return "", "", metadata, fmt.Errorf("None of the block drives %v were found in /proc/diskstat", drives)
+		return nil, fmt.Errorf("None of the block drives %v were found in /proc/diskstat", drives)
 	}
 
-	return strings.Join(headers, " "), strings.Join(metrics, " "), metadata, nil
+	return samples, nil
 }
 
 func preformatter(data []byte) ([]*collectors.MetricResult, error) {
@@ -119,13 +126,12 @@ func preformatter(data []byte) ([]*collectors.MetricResult, error) {
 		return nil, fmt.Errorf("No block drives found to report on")
 	}
 
-	header, metric, metadata, err := formatProcDiskstats(string(data), drivesToInclude)
+	samples, err := formatProcDiskstats(string(data), drivesToInclude)
 	if err != nil {
 		return nil, err
 	}
 
-	result := collectors.BuildMetricResult(header, metric, "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	return []*collectors.MetricResult{{Samples: samples}}, nil
 }
 
 // getBlockDrives returns a list of the block drives on the machine as a []BlockDrive
@@ -133,7 +139,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 	var ccissRegex = regexp.MustCompile("^cciss[!/]c[0-9]+d[0-9]+(p[0-9]+)?$")
 	drives := make([]types.BlockDrive, 0)
 
-	driveDirs, err := ioutil.ReadDir(blockDrivesDir)
+	driveDirs, err := collectors.ReadDir(collectors.HostPath(blockDrivesDir))
 	if err != nil {
 		return nil, err
 	}
@@ -146,12 +152,12 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 		}
 
 		//find all nested device dirs and create BlockDrive structs for each one
-		driveLink := filepath.Join(blockDrivesDir, fi.Name())
-		driveDir, err := filepath.EvalSymlinks(driveLink) // filepath.Walk needs a real dir
+		driveLink := filepath.Join(collectors.HostPath(blockDrivesDir), fi.Name())
+		driveDir, err := collectors.EvalSymlinks(driveLink) // filepath.Walk needs a real dir
 		if err != nil {
 			log.Errorf("Error evaluating symlinks for %s: %v", driveLink, err)
 		}
-		filepath.Walk(driveDir, func(path string, info os.FileInfo, err error) error {
+		collectors.Walk(driveDir, func(path string, info os.FileInfo, err error) error {
 			//ignore files
 			if !info.IsDir() {
 				return nil
@@ -159,7 +165,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 
 			//first, check if dir is a device dir, by checking for a file named 'dev' inside
 			childPath := filepath.Join(path, "dev")
-			devFileInfo, err := os.Stat(childPath)
+			devFileInfo, err := collectors.Stat(childPath)
 			if os.IsNotExist(err) || devFileInfo.IsDir() {
 				return filepath.SkipDir
 			}
@@ -171,12 +177,12 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			drive := types.BlockDrive{
 				Name:      driveName,
-				SysfsPath: path,
+				SysfsPath: collectors.HostRel(path),
 			}
 
 			//read maj:min
 			//childPath is still <path>/dev
-			fc, err := ioutil.ReadFile(childPath)
+			fc, err := collectors.ReadFile(childPath)
 			if err == nil {
 				drive.MajMin = string(bytes.TrimSpace(fc))
 			} else {
@@ -184,7 +190,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read devtype
 			childPath = filepath.Join(path, "uevent")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				lines := strings.Split(string(fc), "\n")
 				for _, line := range lines {
@@ -198,7 +204,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read size
 			childPath = filepath.Join(path, "size")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				sectors, err := strconv.ParseInt(strings.TrimSpace(string(fc)), 10, 64)
 				if err == nil {
@@ -211,7 +217,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read Removable
 			childPath = filepath.Join(path, "removable")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				removable, err := strconv.ParseBool(strings.TrimSpace(string(fc)))
 				if err == nil {
@@ -224,7 +230,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read ReadOnly
 			childPath = filepath.Join(path, "ro")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				readOnly, err := strconv.ParseBool(strings.TrimSpace(string(fc)))
 				if err == nil {
@@ -237,7 +243,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read Revision
 			childPath = filepath.Join(path, "device", "rev")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Revision = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -245,7 +251,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read Vendor
 			childPath = filepath.Join(path, "device", "vendor")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Vendor = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -253,7 +259,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read Product
 			childPath = filepath.Join(path, "device", "model")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Product = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -261,7 +267,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 			}
 			//read LogicalBlockSize
 			childPath = filepath.Join(path, "queue", "logical_block_size")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.LogicalBlockSize = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -270,7 +276,7 @@ func getBlockDrives() ([]types.BlockDrive, error) {
 
 			//read is hdd or ssd drive type
 			childPath = filepath.Join(path, "queue", "rotational")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				if strings.Contains(string(fc), "1") {
 					drive.StorageType = "HDD"
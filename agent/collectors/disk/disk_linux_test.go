@@ -0,0 +1,94 @@
+package disk
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host    string
+		devices []string // sorted devices reported, removable, ram and loop ones are left out
+		device  string   // device whose reads are checked
+		reads   float64
+	}{
+		{"linux-3.10", []string{"dm-0", "sda", "sda1", "sda2"}, "sda", 1000},
+		{"linux-4.19", []string{"vda", "vda1"}, "vda1", 1001},
+		{"linux-5.15", []string{"nvme0n1", "nvme0n1p1", "nvme0n1p2"}, "nvme0n1", 1001},
+		{"linux-6.1", []string{"sda", "sda1", "zram0"}, "zram0", 1002},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+
+			columns := make(map[string]int)
+			for i := range res[0].Samples {
+				columns[res[0].Samples[i].Label("device")]++
+			}
+			var devices []string
+			for device, n := range columns {
+				devices = append(devices, device)
+				// newer kernels have more columns, only the known ones are reported
+				if n != len(procDiskstatsColumns) {
+					t.Errorf("got %d columns of %s, want %d", n, device, len(procDiskstatsColumns))
+				}
+			}
+			sort.Strings(devices)
+			if strings.Join(devices, ",") != strings.Join(tc.devices, ",") {
+				t.Errorf("got devices %v, want %v", devices, tc.devices)
+			}
+			index := collectors.IndexSamples(res[0].Samples)
+			if s := index[tc.device+".readsIssued"]; s == nil || s.Value != tc.reads || s.Kind != collectors.Counter {
+				t.Errorf("readsIssued of %s is %+v, want counter of %v", tc.device, s, tc.reads)
+			}
+			if s := index[tc.device+".ioInProgress"]; s == nil || s.Kind != collectors.Gauge {
+				t.Errorf("ioInProgress of %s is %+v, want gauge", tc.device, s)
+			}
+		})
+	}
+}
+
+func TestGetBlockDrives(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-3.10"))
+	drives, err := getBlockDrives()
+	if err != nil {
+		t.Fatalf("getBlockDrives() error: %v", err)
+	}
+	byName := make(map[string]int)
+	for i := range drives {
+		byName[drives[i].Name] = i
+	}
+	sda, ok := byName["sda"]
+	if !ok {
+		t.Fatalf("sda not found in %+v", drives)
+	}
+	if d := drives[sda]; d.MajMin != "8:0" || d.Size != 41943040*conventionalSectorSize || d.Type != "disk" || d.StorageType != "HDD" ||
+		d.Vendor != "ATA" || d.Product != "ST2000DM008-2FR1" {
+		t.Errorf("sda is %+v", d)
+	}
+	if i, ok := byName["sda1"]; !ok || drives[i].Type != "partition" {
+		t.Errorf("sda1 is not a partition in %+v", drives)
+	}
+	if i, ok := byName["sr0"]; !ok || !drives[i].Removable {
+		t.Errorf("sr0 is not removable in %+v", drives)
+	}
+	if _, ok := byName["loop0"]; ok {
+		t.Errorf("loop0 is not left out")
+	}
+}
@@ -0,0 +1,66 @@
+package disk
+
+import (
+	"math"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// Rates returns IOPS, throughput, average I/O latency and utilisation of every drive since the
+// previous collection
+func Rates(prev, cur []collectors.Sample) []collectors.Sample {
+	old := collectors.IndexSamples(prev)
+	var drives []string
+	deltas := make(map[string]map[string]float64)
+	intervals := make(map[string]float64)
+	reset := make(map[string]bool)
+	for i := range cur {
+		s := &cur[i]
+		drive := s.Label("device")
+		p, ok := old[s.ColumnName()]
+		if !ok || s.Kind != collectors.Counter {
+			continue
+		}
+		d, ok := collectors.Delta(p, s)
+		if !ok {
+			reset[drive] = true
+			continue
+		}
+		if deltas[drive] == nil {
+			drives = append(drives, drive)
+			deltas[drive] = make(map[string]float64)
+			intervals[drive] = collectors.Interval(p, s)
+		}
+		deltas[drive][s.Name] = d
+	}
+
+	rates := make([]collectors.Sample, 0)
+	for _, drive := range drives {
+		d, dt := deltas[drive], intervals[drive]
+		if reset[drive] || dt <= 0 {
+			continue
+		}
+
+		label := collectors.Label{Name: "device", Value: drive}
+		reads, writes := d["readsIssued"], d["writesCompleted"]
+		rates = append(rates,
+			collectors.DerivedSample("readsPerSec", reads/dt, "1/s", "Reads completed per second", label),
+			collectors.DerivedSample("writesPerSec", writes/dt, "1/s", "Writes completed per second", label),
+			collectors.DerivedSample("iops", (reads+writes)/dt, "1/s", "Reads and writes completed per second", label),
+			collectors.DerivedSample("readBytesPerSec", d["sectorsRead"]*conventionalSectorSize/dt, "bytes/s", "Bytes read per second", label),
+			collectors.DerivedSample("writeBytesPerSec", d["sectorsWritten"]*conventionalSectorSize/dt, "bytes/s", "Bytes written per second", label),
+			collectors.DerivedSample("readLatency", average(d["timeReading"], reads), "ms", "Average time of a read", label),
+			collectors.DerivedSample("writeLatency", average(d["timeWriting"], writes), "ms", "Average time of a write", label),
+			collectors.DerivedSample("latency", average(d["timeReading"]+d["timeWriting"], reads+writes), "ms", "Average time of a read or write", label),
+			collectors.DerivedSample("utilisationPct", math.Min(100, d["timeDoingIO"]/(dt*10)), "%", "Share of time the drive was busy doing I/O", label),
+		)
+	}
+	return rates
+}
+
+func average(sum, count float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
@@ -1,30 +1,29 @@
 package diskusage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
-func loader() ([]byte, error) {
+func loader(ctx context.Context) ([]byte, error) {
 
-	df, err := exec.LookPath("df")
+	df, err := collectors.LookPath(ctx, "df")
 	var usageStats []*types.MountUsageStat
-	if err != nil {
-		usageStats, err = collectUsages()
+	// df shows the mounts of the agent, which are not those of the host under a host root
+	if err != nil || collectors.IsHostRootSet() {
+		usageStats, err = collectUsages(ctx)
 	} else {
-		usageStats, err = collectUsagesDF(df)
+		usageStats, err = collectUsagesDF(ctx, df)
 	}
 
 	if len(usageStats) == 0 {
@@ -42,29 +41,38 @@ func preformatter(data []byte) ([]*collectors.MetricResult, error) {
 		log.Error(err.Error())
 		return nil, err
 	}
-	var metadata = make(map[string]string)
-	var headers = make([]string, 0)
-	var metrics = make([]string, 0)
-	//for each usageStat, add metric headers and values
+	var samples = make([]collectors.Sample, 0)
+	//for each usageStat, add metric samples
 	for _, usageStat := range usageStats {
-		colPrefix := strings.TrimPrefix(usageStat.Mount.DevicePath, "/dev/") //trim '/dev/' if we can
-		headers = append(headers, colPrefix+".BytesTotal", colPrefix+".BytesUsed", colPrefix+".BytesAvailable", colPrefix+".InodesUsed", colPrefix+".InodesFree")
-		metrics = append(metrics, strconv.FormatUint(usageStat.Total, 10), strconv.FormatUint(usageStat.Used, 10), strconv.FormatUint(usageStat.Available, 10), strconv.FormatUint(usageStat.InodesUsed, 10), strconv.FormatUint(usageStat.InodesFree, 10))
-
-		metadata[colPrefix+".BytesTotal"] = "int Total number of bytes."
-		metadata[colPrefix+".BytesUsed"] = "int Number of used bytes."
-		metadata[colPrefix+".BytesAvailable"] = "int Number of available bytes."
-		metadata[colPrefix+".InodesUsed"] = "int Number of used inodes."
-		metadata[colPrefix+".InodesFree"] = "int Number of available inodes."
+		device := collectors.Label{Name: "device", Value: strings.TrimPrefix(usageStat.Mount.DevicePath, "/dev/")} //trim '/dev/' if we can
+		for _, col := range []struct {
+			name, unit, help string
+			value            uint64
+		}{
+			{"BytesTotal", "bytes", "Total number of bytes.", usageStat.Total},
+			{"BytesUsed", "bytes", "Number of used bytes.", usageStat.Used},
+			{"BytesAvailable", "bytes", "Number of available bytes.", usageStat.Available},
+			{"InodesUsed", "", "Number of used inodes.", usageStat.InodesUsed},
+			{"InodesFree", "", "Number of available inodes.", usageStat.InodesFree},
+		} {
+			samples = append(samples, collectors.Sample{
+				Name:   col.name,
+				Labels: []collectors.Label{device},
+				Type:   collectors.IntValue,
+				Kind:   collectors.Gauge,
+				Value:  float64(col.value),
+				Unit:   col.unit,
+				Help:   col.help,
+			})
+		}
 	}
-	result := collectors.BuildMetricResult(strings.Join(headers, " "), strings.Join(metrics, " "), "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	return []*collectors.MetricResult{{Samples: samples}}, nil
 }
 
 //Collect usages with DF
-func collectUsagesDF(df string) ([]*types.MountUsageStat, error) {
+func collectUsagesDF(ctx context.Context, df string) ([]*types.MountUsageStat, error) {
 
-	output, err := exec.Command(df, "--output=size,used,avail,iused,iavail,source", "-BK", "-a").Output()
+	output, err := collectors.Output(ctx, df, "--output=size,used,avail,iused,iavail,source", "-BK", "-a")
 	if output == nil || len(output) == 0 {
 		return nil, fmt.Errorf("cannot run df util: %v", err)
 	}
@@ -72,9 +80,9 @@ func collectUsagesDF(df string) ([]*types.MountUsageStat, error) {
 }
 
 //Collect usages without DF util
-func collectUsages() ([]*types.MountUsageStat, error) {
+func collectUsages(ctx context.Context) ([]*types.MountUsageStat, error) {
 	var usageStats = make([]*types.MountUsageStat, 0)
-	mounts := getMounts()
+	mounts := getMounts(ctx)
 	if len(mounts) == 0 {
 		return nil, fmt.Errorf("No mounted block drives found")
 	}
@@ -170,11 +178,15 @@ func parseDfOutput(output []byte) ([]*types.MountUsageStat, error) {
 }
 
 // getMounts returns a list of mounts(disk, path, etc) using both mount(8) and /proc/mount
-func getMounts() []*types.MountStat {
+func getMounts(ctx context.Context) []*types.MountStat {
 	mounts := make(map[string]*types.MountStat)
 
-	//read mount(8) output
-	out, err := exec.Command("mount").Output()
+	//read mount(8) output, it shows the mounts of the agent which are not those of the host under a host root
+	var out []byte
+	var err error
+	if !collectors.IsHostRootSet() {
+		out, err = collectors.Output(ctx, "mount")
+	}
 	if err != nil {
 		log.Infof("Error running mount program: %v", err)
 	} else {
@@ -204,10 +216,14 @@ func getMounts() []*types.MountStat {
 		}
 	}
 
-	//read /proc/mounts
-	fc, err := ioutil.ReadFile("/proc/mounts")
+	//read /proc/mounts, or the mounts of init under a host root, /proc/mounts being those of the agent
+	mountsFile := "/proc/mounts"
+	if collectors.IsHostRootSet() {
+		mountsFile = "/proc/1/mounts"
+	}
+	fc, err := collectors.ReadFile(collectors.HostPath(mountsFile))
 	if err != nil {
-		log.Errorf("Error reading /proc/mounts: %v", err)
+		log.Errorf("Error reading %s: %v", mountsFile, err)
 	} else {
 		lines := strings.Split(string(fc), "\n")
 		for _, line := range lines {
@@ -216,10 +232,14 @@ func getMounts() []*types.MountStat {
 				continue
 			}
 
-			devPath, err := filepath.EvalSymlinks(fields[0])
+			if !filepath.IsAbs(fields[0]) { // not a device, e.g. proc or tmpfs
+				continue
+			}
+			devPath, err := collectors.EvalSymlinks(collectors.HostPath(fields[0]))
 			if err != nil {
 				continue
 			}
+			devPath = collectors.HostRel(devPath)
 			mountpoint := fields[1]
 			fstype := fields[2]
 			options := fields[3]
@@ -247,8 +267,7 @@ func getMounts() []*types.MountStat {
 
 // getMountUsage returns statstics of mounted device
 func getMountUsage(mount *types.MountStat) (*types.MountUsageStat, error) {
-	stat := syscall.Statfs_t{}
-	err := syscall.Statfs(mount.Mountpoint, &stat)
+	stat, err := collectors.Statfs(collectors.HostPath(mount.Mountpoint))
 	if err != nil {
 		return nil, err
 	}
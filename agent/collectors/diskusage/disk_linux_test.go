@@ -0,0 +1,75 @@
+package diskusage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host   string
+		mounts map[string]string // mountpoint by device, devices are resolved under the host root
+	}{
+		{"linux-3.10", map[string]string{"/dev/dm-0": "/", "/dev/sda1": "/boot"}},
+		{"linux-4.19", map[string]string{"/dev/vda1": "/"}},
+		{"linux-5.15", map[string]string{"/dev/nvme0n1p2": "/", "/dev/nvme0n1p1": "/boot/efi"}},
+		{"linux-6.1", map[string]string{"/dev/sda1": "/"}},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			mounts := getMounts(context.Background())
+			if len(mounts) != len(tc.mounts) {
+				t.Errorf("got %d mounts, want %d", len(mounts), len(tc.mounts))
+			}
+			for _, m := range mounts {
+				if tc.mounts[m.DevicePath] != m.Mountpoint {
+					t.Errorf("got %s mounted at %s, want %q", m.DevicePath, m.Mountpoint, tc.mounts[m.DevicePath])
+				}
+			}
+
+			// mountpoints of the fixtures are directories under the host root, the usage is that of
+			// the file system they are on
+			data, err := loader(context.Background())
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+			if want := 5 * len(tc.mounts); len(res[0].Samples) != want {
+				t.Errorf("got %d samples, want %d", len(res[0].Samples), want)
+			}
+			for i := range res[0].Samples {
+				s := &res[0].Samples[i]
+				if _, ok := tc.mounts["/dev/"+s.Label("device")]; !ok {
+					t.Errorf("unexpected device of sample %+v", s)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDfOutput(t *testing.T) {
+	out := []byte(` 1K-blocks   Used  Avail IUsed IFree Filesystem
+ 20466256K 5234564K 14166732K 123456 1187264 /dev/sda1
+        0K       0K        0K      -       - proc
+`)
+	stats, err := parseDfOutput(out)
+	if err != nil {
+		t.Fatalf("parseDfOutput() error: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d usages, want 2", len(stats))
+	}
+	if s := stats[0]; s.Mount.DevicePath != "/dev/sda1" || s.Total != 20466256*1024 || s.InodesUsed != 123456 {
+		t.Errorf("usage of /dev/sda1 is %+v", s)
+	}
+}
@@ -1,12 +1,14 @@
 package diskusage
 
 import (
+	"context"
+
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 // Run returns disk usage metrics
-func Run() ([]*collectors.MetricResult, error) {
-	data, err := loader()
+func Run(ctx context.Context) ([]*collectors.MetricResult, error) {
+	data, err := loader(ctx)
 	if err != nil {
 		return nil, err
 	}
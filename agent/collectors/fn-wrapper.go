@@ -7,27 +7,43 @@ import (
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
-// BuildMetricResult returns MetricResult from given header, metric etc
-func BuildMetricResult(header, metric, sufix string, metadata map[string]string) *MetricResult {
-	result := &MetricResult{
-		Header:   header,
-		Data:     metric,
-		Metadata: nil,
-		Sufix:    sufix,
+// Legacy serializes the samples into the space separated header and data columns and the
+// "type description" metadata of the legacy line format
+func (m *MetricResult) Legacy() (header, data string, metadata map[string]string) {
+	headers := make([]string, 0, len(m.Samples))
+	values := make([]string, 0, len(m.Samples))
+	for i := range m.Samples {
+		s := &m.Samples[i]
+		column := legacyField(s.ColumnName())
+		headers = append(headers, column)
+		values = append(values, legacyField(s.FormatValue()))
+		if s.Help != "" {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[column] = string(s.Type) + " " + s.Help
+		}
 	}
-	if metadata != nil && len(metadata) > 0 {
-		result.Metadata = metadata
+	return strings.Join(headers, " "), strings.Join(values, " "), metadata
+}
+
+// legacyField makes str a single column: spaces are replaced by '_', an empty string by "none"
+func legacyField(str string) string {
+	str = strings.Join(strings.Fields(str), "_")
+	if str == "" {
+		return "none"
 	}
-	return result
+	return str
 }
 
 // ConvertToString converts MetricResults into string
 func ConvertToString(result []*MetricResult) string {
 	var out = []string{}
 	for _, m := range result {
-		out = append(out, "h:"+m.Sufix+" "+m.Header+"\n"+"v:"+m.Sufix+" "+m.Data)
-		if m.Metadata != nil && len(m.Metadata) > 0 {
-			marshMetadata, err := json.Marshal(m.Metadata)
+		header, data, metadata := m.Legacy()
+		out = append(out, "h:"+m.Sufix+" "+header+"\n"+"v:"+m.Sufix+" "+data)
+		if len(metadata) > 0 {
+			marshMetadata, err := json.Marshal(metadata)
 			if err != nil {
 				log.Errorf("Could not marshal metadata: %v", err)
 				continue
@@ -0,0 +1,56 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// HostRootEnv is the environment variable setting the host root, when it is not set by SetHostRoot
+const HostRootEnv = "HDS_HOST_ROOT"
+
+// hostRoot is the directory procfs, sysfs and other files of the host are read under, a string
+var hostRoot atomic.Value
+
+func init() {
+	SetHostRoot(os.Getenv(HostRootEnv))
+}
+
+// SetHostRoot sets the directory the host's /proc, /sys and other files are read under, e.g. /host
+// when the agent runs in a container with the root of the host mounted there. Empty is /
+func SetHostRoot(root string) {
+	if root == "" {
+		root = "/"
+	}
+	hostRoot.Store(filepath.Clean(root))
+}
+
+// HostRoot returns the directory the files of the host are read under, which is under the root of
+// the file source when files are replayed
+func HostRoot() string {
+	root := hostRoot.Load().(string)
+	if src := files().Root(); src != "/" {
+		return filepath.Join(src, root)
+	}
+	return root
+}
+
+// HostPath returns the path of file p of the host, e.g. /host/proc/stat for /proc/stat
+func HostPath(p string) string {
+	return filepath.Join(HostRoot(), p)
+}
+
+// HostRel returns the path on the host of p, a path under HostRoot, e.g. /proc/stat for /host/proc/stat
+func HostRel(p string) string {
+	rel, err := filepath.Rel(HostRoot(), p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return p
+	}
+	return filepath.Join("/", rel)
+}
+
+// IsHostRootSet reports if the files of the host are read under a directory other than /
+func IsHostRootSet() bool {
+	return hostRoot.Load().(string) != "/"
+}
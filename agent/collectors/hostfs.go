@@ -0,0 +1,120 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// FileSource provides the files of the host which collectors read, so that they can be captured or
+// replayed. Collectors read files with the functions of this file, with paths given by HostPath
+type FileSource interface {
+	// Root is the directory the host root is under, / unless files are replayed from a capture
+	Root() string
+	// ReadFile reads file name like ioutil.ReadFile
+	ReadFile(name string) ([]byte, error)
+	// Statfs returns statistics of the file system of path
+	Statfs(path string) (*FSStat, error)
+	// Visit is called with the paths whose type, links or entries are looked at
+	Visit(name string)
+}
+
+// FSStat is statistics of a file system, see statfs(2)
+type FSStat struct {
+	Bsize  int64  // block size
+	Blocks uint64 // total blocks
+	Bfree  uint64 // free blocks
+	Bavail uint64 // free blocks available to unprivileged users
+	Files  uint64 // total inodes
+	Ffree  uint64 // free inodes
+}
+
+// hostFiles reads the files of the host, it is the default file source
+type hostFiles struct{}
+
+func (hostFiles) Root() string                         { return "/" }
+func (hostFiles) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+func (hostFiles) Statfs(path string) (*FSStat, error)  { return statfs(path) }
+func (hostFiles) Visit(name string)                    {}
+
+// fileSource is the FileSource of the collectors, in a struct as atomic.Value needs a consistent type
+var fileSource atomic.Value
+
+type fileSourceHolder struct{ FileSource }
+
+func init() {
+	SetFileSource(nil)
+}
+
+// SetFileSource sets the source of the files collectors read, nil is the files of the host
+func SetFileSource(s FileSource) {
+	if s == nil {
+		s = hostFiles{}
+	}
+	fileSource.Store(fileSourceHolder{s})
+}
+
+// files returns the source of the files collectors read
+func files() FileSource {
+	return fileSource.Load().(fileSourceHolder).FileSource
+}
+
+// ReadFile reads file name of the host
+func ReadFile(name string) ([]byte, error) {
+	return files().ReadFile(name)
+}
+
+// ReadDir reads directory name of the host like ioutil.ReadDir
+func ReadDir(name string) ([]os.FileInfo, error) {
+	src := files()
+	src.Visit(name)
+	fis, err := ioutil.ReadDir(name)
+	for _, fi := range fis {
+		src.Visit(filepath.Join(name, fi.Name()))
+	}
+	return fis, err
+}
+
+// Readlink returns the destination of symbolic link name of the host
+func Readlink(name string) (string, error) {
+	files().Visit(name)
+	return os.Readlink(name)
+}
+
+// EvalSymlinks returns path of the host after the evaluation of any symbolic links
+func EvalSymlinks(path string) (string, error) {
+	files().Visit(path)
+	return filepath.EvalSymlinks(path)
+}
+
+// Stat returns the FileInfo of file name of the host, following symbolic links
+func Stat(name string) (os.FileInfo, error) {
+	files().Visit(name)
+	return os.Stat(name)
+}
+
+// Glob returns the files of the host matching pattern like filepath.Glob
+func Glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	src := files()
+	for _, m := range matches {
+		src.Visit(m)
+	}
+	return matches, err
+}
+
+// Walk walks the file tree of the host at root like filepath.Walk
+func Walk(root string, fn filepath.WalkFunc) error {
+	src := files()
+	src.Visit(root)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		src.Visit(path)
+		return fn(path, info, err)
+	})
+}
+
+// Statfs returns statistics of the file system of path of the host
+func Statfs(path string) (*FSStat, error) {
+	return files().Statfs(path)
+}
@@ -0,0 +1,19 @@
+package collectors
+
+import "syscall"
+
+// statfs returns statistics of the file system of path
+func statfs(path string) (*FSStat, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &FSStat{
+		Bsize:  int64(st.Bsize),
+		Blocks: uint64(st.Blocks),
+		Bfree:  uint64(st.Bfree),
+		Bavail: uint64(st.Bavail),
+		Files:  uint64(st.Files),
+		Ffree:  uint64(st.Ffree),
+	}, nil
+}
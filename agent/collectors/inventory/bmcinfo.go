@@ -2,26 +2,27 @@ package inventory
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 )
 
 // BmcPrecheck validates the dependency of bmc-info and  dmidecode
-func BmcPrecheck() error {
-	_, err := exec.LookPath("bmc-info")
+func BmcPrecheck(ctx context.Context) error {
+	_, err := collectors.LookPath(ctx, "bmc-info")
 	if err != nil {
 		return err
 	}
-	dmidecode, err := exec.LookPath("dmidecode")
+	dmidecode, err := collectors.LookPath(ctx, "dmidecode")
 	if err != nil {
 		return nil // we can't check is data exists but we can try to collect it.
 	}
 
-	output, err := exec.Command(dmidecode, "--type", "38").Output()
+	output, err := collectors.Output(ctx, dmidecode, "--type", "38")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -56,12 +57,12 @@ func bmcInfoResultFormat(lines []string) *types.GenericInfo {
 }
 
 // BmcInfoRun returns formatted output of bmc-info in []byte
-func BmcInfoRun() ([]byte, error) {
-	bmcInfo, err := exec.LookPath("bmc-info")
+func BmcInfoRun(ctx context.Context) ([]byte, error) {
+	bmcInfo, err := collectors.LookPath(ctx, "bmc-info")
 	if err != nil {
 		return nil, err
 	}
-	output, err := exec.Command(bmcInfo).Output()
+	output, err := collectors.Output(ctx, bmcInfo)
 	if err != nil {
 		out := ""
 		if output != nil {
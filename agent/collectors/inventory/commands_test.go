@@ -0,0 +1,137 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
+)
+
+// commands has bundles of command outputs recorded on hosts, see collectors.Recorder
+const commands = "../testdata/commands"
+
+// replay returns a context running commands with the outputs of the named bundle
+func replay(t *testing.T, name string) context.Context {
+	r, err := collectors.LoadReplayer(filepath.Join(commands, name))
+	if err != nil {
+		t.Fatalf("LoadReplayer(%s) error: %v", name, err)
+	}
+	return collectors.WithRunner(context.Background(), r)
+}
+
+func TestSMBIOSRun(t *testing.T) {
+	data, err := SMBIOSRun(replay(t, "dmidecode"))
+	if err != nil {
+		t.Fatalf("SMBIOSRun() error: %v", err)
+	}
+	var s types.SMBIOS
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("invalid SMBIOS json: %v", err)
+	}
+	if s.Version != "3.2.0" {
+		t.Errorf("got version %q, want 3.2.0", s.Version)
+	}
+	// the OEM-specific entry is left out
+	want := []struct {
+		category string
+		details  int
+		tag      string
+		value    string
+	}{
+		{"BIOS Information", 4, "Version", "2.12.2"},
+		{"System Information", 4, "Serial Number", "4XK8QW2"},
+		{"Processor Information", 4, "Core Count", "16"},
+	}
+	if len(s.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(s.Entries), len(want), s.Entries)
+	}
+	for i, w := range want {
+		e := s.Entries[i]
+		if e.Category != w.category {
+			t.Errorf("entry %d: got category %q, want %q", i, e.Category, w.category)
+		}
+		if len(e.Details) != w.details {
+			t.Errorf("%s: got %d details, want %d", w.category, len(e.Details), w.details)
+		}
+		if value, _ := detail(e, w.tag); value != w.value {
+			t.Errorf("%s: got %s %q, want %q", w.category, w.tag, value, w.value)
+		}
+	}
+}
+
+func TestSMBIOSRunNotRecorded(t *testing.T) {
+	if _, err := SMBIOSRun(replay(t, "ipmitool")); err == nil {
+		t.Error("SMBIOSRun() without dmidecode succeeded")
+	}
+}
+
+func TestGetDisks(t *testing.T) {
+	ctx := replay(t, "smartctl")
+	smartctl, err := collectors.LookPath(ctx, "smartctl")
+	if err != nil {
+		t.Fatalf("LookPath(smartctl) error: %v", err)
+	}
+	disks, err := getDisks(ctx, smartctl)
+	if err != nil {
+		t.Fatalf("getDisks() error: %v", err)
+	}
+	// the device smartctl failed to open is commented out and left out
+	want := []Disk{
+		{Path: "/dev/sda", Name: "sda", Type: "sat"},
+		{Path: "/dev/sdb", Name: "sdb", Type: "scsi"},
+		{Path: "/dev/bus/0", Name: "0", Type: "megaraid,0"},
+		{Path: "/dev/nvme0", Name: "nvme0", Type: "nvme"},
+	}
+	if len(disks) != len(want) {
+		t.Fatalf("got %d disks, want %d: %+v", len(disks), len(want), disks)
+	}
+	for i := range want {
+		if disks[i] != want[i] {
+			t.Errorf("disk %d: got %+v, want %+v", i, disks[i], want[i])
+		}
+	}
+}
+
+func TestIpmiToolRun(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-6.1"))
+	data, err := IpmiToolRun(replay(t, "ipmitool"))
+	if err != nil {
+		t.Fatalf("IpmiToolRun() error: %v", err)
+	}
+	var g types.GenericInfo
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatalf("invalid ipmitool json: %v", err)
+	}
+	if len(g.Entries) != 1 || g.Entries[0].Category != "bmc" {
+		t.Fatalf("got %+v, want one bmc entry", g.Entries)
+	}
+	e := g.Entries[0]
+	// lines without a tag or value, like the continuation of the cipher suites, are left out
+	if len(e.Details) != 17 {
+		t.Errorf("got %d details, want 17: %+v", len(e.Details), e.Details)
+	}
+	for tag, value := range map[string]string{
+		"Firmware Revision": "4.40",
+		"Manufacturer Name": "DELL Inc",
+		"IP Address Source": "Static Address",
+		"IP Address":        "10.20.30.40",
+		"MAC Address":       "d0:94:66:1a:2b:3c",
+		"802.1q VLAN ID":    "Disabled",
+	} {
+		if got, _ := detail(e, tag); got != value {
+			t.Errorf("got %s %q, want %q", tag, got, value)
+		}
+	}
+}
+
+func TestIpmiToolRunWithoutDevice(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-5.15"))
+	if _, err := IpmiToolRun(replay(t, "ipmitool")); err == nil {
+		t.Error("IpmiToolRun() without /dev/ipmi* succeeded")
+	}
+}
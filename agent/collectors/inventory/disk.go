@@ -1,17 +1,18 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 import (
 	"path/filepath"
 
 	"bytes"
-	"io/ioutil"
 	"os"
 	"reflect"
 	"regexp"
@@ -33,7 +34,7 @@ var ccissRegex = regexp.MustCompile("^cciss[!/]c[0-9]+d[0-9]+(p[0-9]+)?$")
 func getBlockDrives() ([]BlockDrive, error) {
 	drives := make([]BlockDrive, 0)
 
-	driveDirs, err := ioutil.ReadDir(blockDrivesDir)
+	driveDirs, err := collectors.ReadDir(collectors.HostPath(blockDrivesDir))
 	if err != nil {
 		return nil, err
 	}
@@ -46,12 +47,12 @@ func getBlockDrives() ([]BlockDrive, error) {
 		}
 
 		//find all nested device dirs and create BlockDrive structs for each one
-		driveLink := filepath.Join(blockDrivesDir, fi.Name())
-		driveDir, err := filepath.EvalSymlinks(driveLink) // filepath.Walk needs a real dir
+		driveLink := filepath.Join(collectors.HostPath(blockDrivesDir), fi.Name())
+		driveDir, err := collectors.EvalSymlinks(driveLink) // filepath.Walk needs a real dir
 		if err != nil {
 			log.Errorf("Error evaluating symlinks for %s: %v", driveLink, err)
 		}
-		filepath.Walk(driveDir, func(path string, info os.FileInfo, err error) error {
+		collectors.Walk(driveDir, func(path string, info os.FileInfo, err error) error {
 			//ignore files
 			if !info.IsDir() {
 				return nil
@@ -59,7 +60,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 
 			//first, check if dir is a device dir, by checking for a file named 'dev' inside
 			childPath := filepath.Join(path, "dev")
-			devFileInfo, err := os.Stat(childPath)
+			devFileInfo, err := collectors.Stat(childPath)
 			if os.IsNotExist(err) || devFileInfo.IsDir() {
 				return filepath.SkipDir
 			}
@@ -71,12 +72,12 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			drive := BlockDrive{
 				Name:      driveName,
-				SysfsPath: path,
+				SysfsPath: collectors.HostRel(path),
 			}
 
 			//read maj:min
 			//childPath is still <path>/dev
-			fc, err := ioutil.ReadFile(childPath)
+			fc, err := collectors.ReadFile(childPath)
 			if err == nil {
 				drive.MajMin = string(bytes.TrimSpace(fc))
 			} else {
@@ -84,7 +85,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read devtype
 			childPath = filepath.Join(path, "uevent")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				lines := strings.Split(string(fc), "\n")
 				for _, line := range lines {
@@ -98,7 +99,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read size
 			childPath = filepath.Join(path, "size")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				sectors, err := strconv.ParseInt(strings.TrimSpace(string(fc)), 10, 64)
 				if err == nil {
@@ -111,7 +112,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read Removable
 			childPath = filepath.Join(path, "removable")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				removable, err := strconv.ParseBool(strings.TrimSpace(string(fc)))
 				if err == nil {
@@ -124,7 +125,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read ReadOnly
 			childPath = filepath.Join(path, "ro")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				readOnly, err := strconv.ParseBool(strings.TrimSpace(string(fc)))
 				if err == nil {
@@ -137,7 +138,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read Revision
 			childPath = filepath.Join(path, "device", "rev")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Revision = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -145,7 +146,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read Vendor
 			childPath = filepath.Join(path, "device", "vendor")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Vendor = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -153,7 +154,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read Product
 			childPath = filepath.Join(path, "device", "model")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.Product = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -161,7 +162,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read LogicalBlockSize
 			childPath = filepath.Join(path, "queue", "logical_block_size")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				drive.LogicalBlockSize = strings.TrimSpace(string(fc))
 			} else if !os.IsNotExist(err) {
@@ -169,7 +170,7 @@ func getBlockDrives() ([]BlockDrive, error) {
 			}
 			//read is hdd or ssd drive type
 			childPath = filepath.Join(path, "queue", "rotational")
-			fc, err = ioutil.ReadFile(childPath)
+			fc, err = collectors.ReadFile(childPath)
 			if err == nil {
 				if strings.Contains(string(fc), "1") {
 					drive.StorageType = "HDD"
@@ -241,9 +242,9 @@ func blockDriveFormat(drive BlockDrive) *types.Entry {
 
 //gets list of disks on the machine using smartctl --scan-open
 //note that this only checks block drives /dev/sd[a-z], /dev/sd[a-c][a-z], /dev/hd[a-z], and /dev/discs/disc*
-func getDisks(smartctlPath string) ([]Disk, error) {
+func getDisks(ctx context.Context, smartctlPath string) ([]Disk, error) {
 	// Get a list of sd disks:
-	dev, devErr := exec.Command(smartctlPath, "--scan-open").Output()
+	dev, devErr := collectors.Output(ctx, smartctlPath, "--scan-open")
 	if devErr != nil {
 		devOut := "command did not generate any output"
 		if dev != nil {
@@ -298,7 +299,7 @@ func getSCSIDeviceInfo(driveName string) (deviceDetails []types.Detail) {
 	deviceDetails = make([]types.Detail, 0)
 
 	//get device attributes from the device directory
-	deviceDir, err := filepath.EvalSymlinks(filepath.Join("/sys/block/", driveName, "/device/"))
+	deviceDir, err := collectors.EvalSymlinks(filepath.Join(collectors.HostPath(blockDrivesDir), driveName, "device"))
 	if err != nil {
 		log.Errorf("error reading device information for drive %s: %v", driveName, err)
 		return deviceDetails
@@ -306,7 +307,7 @@ func getSCSIDeviceInfo(driveName string) (deviceDetails []types.Detail) {
 
 	for filename, label := range scsiEndDeviceAttributesToCollect {
 		path := filepath.Join(deviceDir, filename)
-		fc, err := ioutil.ReadFile(path)
+		fc, err := collectors.ReadFile(path)
 		if os.IsNotExist(err) {
 			continue
 		} else if err != nil {
@@ -318,11 +319,11 @@ func getSCSIDeviceInfo(driveName string) (deviceDetails []types.Detail) {
 	//find the SCSI host by looking up the directory ancestor chain of the block drive dir for one containing "scsi_host"
 	ancestorDir := filepath.Dir(deviceDir)
 	for {
-		if match, _ := filepath.Match("/sys/devices/", ancestorDir); match || ancestorDir == filepath.Dir(ancestorDir) { // we've gone too far
+		if match, _ := filepath.Match(collectors.HostPath("/sys/devices"), ancestorDir); match || ancestorDir == filepath.Dir(ancestorDir) { // we've gone too far
 			break
 		}
 
-		hostGlob, err := filepath.Glob(filepath.Join(ancestorDir, "/scsi_host/*"))
+		hostGlob, err := collectors.Glob(filepath.Join(ancestorDir, "/scsi_host/*"))
 		if err != nil {
 			log.Errorf("Error globbing %s: %v", filepath.Join(ancestorDir, "/scsi_host/*"), err)
 		} else {
@@ -349,7 +350,7 @@ func getSCSIHostsInfo() (hostEntries []types.Entry) {
 	}
 	hostEntries = make([]types.Entry, 0)
 
-	hostDirs, _ := filepath.Glob("/sys/class/scsi_host/*")
+	hostDirs, _ := collectors.Glob(collectors.HostPath("/sys/class/scsi_host/*"))
 	for _, hostDir := range hostDirs {
 		entry := types.Entry{Category: "SCSI Host", Details: make([]types.Detail, 0)}
 
@@ -357,7 +358,7 @@ func getSCSIHostsInfo() (hostEntries []types.Entry) {
 
 		for filename, label := range scsiHostAttributesToCollect {
 			path := filepath.Join(hostDir, filename)
-			fc, err := ioutil.ReadFile(path)
+			fc, err := collectors.ReadFile(path)
 			if os.IsNotExist(err) {
 				continue
 			} else if err != nil {
@@ -366,13 +367,13 @@ func getSCSIHostsInfo() (hostEntries []types.Entry) {
 			entry.Details = append(entry.Details, types.Detail{Tag: label, Value: strings.TrimSpace(string(fc))})
 		}
 
-		realHostDir, err := filepath.EvalSymlinks(hostDir)
+		realHostDir, err := collectors.EvalSymlinks(hostDir)
 		if err != nil {
 			log.Errorf("Error getting direct path to %s: %v", hostDir, err)
 		} else {
-			expanderSASAddressFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(filepath.Dir(realHostDir)), "/port*/expander*/sas_device/expander*/sas_address"))
+			expanderSASAddressFiles, _ := collectors.Glob(filepath.Join(filepath.Dir(filepath.Dir(realHostDir)), "/port*/expander*/sas_device/expander*/sas_address"))
 			for _, path := range expanderSASAddressFiles {
-				fc, err := ioutil.ReadFile(path)
+				fc, err := collectors.ReadFile(path)
 				if err != nil {
 					log.Errorf("Error opening %s: %v", path, err)
 				}
@@ -387,19 +388,19 @@ func getSCSIHostsInfo() (hostEntries []types.Entry) {
 }
 
 // DiskRun returns disk information output
-func DiskRun() ([]byte, error) {
+func DiskRun(ctx context.Context) ([]byte, error) {
 	g := types.GenericInfo{}
 	g.Entries = make([]types.Entry, 0)
 
 	// collect info on disks found by smartctl
 	disks := make([]Disk, 0)
-	smartctlPath, err := exec.LookPath("smartctl")
+	smartctlPath, err := collectors.LookPath(ctx, "smartctl")
 	if err == nil {
-		disks, _ = getDisks(smartctlPath)
+		disks, _ = getDisks(ctx, smartctlPath)
 	}
 	for _, disk := range disks {
 		// Get disk information:
-		output, err := exec.Command(smartctlPath, "-i", disk.Path, "-d", disk.Type).Output()
+		output, err := collectors.Output(ctx, smartctlPath, "-i", disk.Path, "-d", disk.Type)
 		if err != nil && output == nil {
 			// smartctl failed to collect any information, log the error and continue collecting information from other disks:
 			log.Errorf("disk collection on %s failed: %v", disk.Path, err)
@@ -438,7 +439,7 @@ func DiskRun() ([]byte, error) {
 			if smartctlPath != "" && ccissRegex.MatchString(drive.Name) { // deal with the special cciss case
 				for i := 0; i < 16; i++ {
 					devPath := filepath.Join("/dev/", drive.Name)
-					output, err := exec.Command(smartctlPath, "-i", devPath, "-d", fmt.Sprintf("cciss,%d", i)).Output()
+					output, err := collectors.Output(ctx, smartctlPath, "-i", devPath, "-d", fmt.Sprintf("cciss,%d", i))
 					if err != nil {
 						continue
 					}
@@ -0,0 +1,70 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+func TestGetBlockDrives(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host   string
+		drives map[string]BlockDrive // drives with their expected attributes, loop and ram ones are left out
+	}{
+		{"linux-3.10", map[string]BlockDrive{
+			"sda":  {MajMin: "8:0", Type: "disk", Size: 41943040 * 512, Vendor: "ATA", Product: "ST2000DM008-2FR1", Revision: "0001", StorageType: "HDD", LogicalBlockSize: "512"},
+			"sda1": {MajMin: "8:1", Type: "partition", Size: 2097152 * 512, StorageType: "Unknown"},
+			"sda2": {MajMin: "8:2", Type: "partition", Size: 39843840 * 512, StorageType: "Unknown"},
+			"sr0":  {MajMin: "11:0", Type: "disk", Size: 2097151 * 512, Removable: true, Vendor: "QEMU", Product: "QEMU DVD-ROM", StorageType: "HDD", LogicalBlockSize: "512"},
+			"dm-0": {MajMin: "253:0", Type: "disk", Size: 37748736 * 512, StorageType: "HDD", LogicalBlockSize: "512"},
+		}},
+		{"linux-5.15", map[string]BlockDrive{
+			"nvme0n1":   {MajMin: "259:0", Type: "disk", Size: 1000215216 * 512, Product: "Samsung SSD 980 PRO 1TB", StorageType: "SSD", LogicalBlockSize: "512"},
+			"nvme0n1p1": {MajMin: "259:1", Type: "partition", Size: 1048576 * 512, StorageType: "Unknown"},
+			"nvme0n1p2": {MajMin: "259:2", Type: "partition", Size: 999164559 * 512, StorageType: "Unknown"},
+		}},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			drives, err := getBlockDrives()
+			if err != nil {
+				t.Fatalf("getBlockDrives() error: %v", err)
+			}
+			if len(drives) != len(tc.drives) {
+				t.Errorf("got %d drives, want %d", len(drives), len(tc.drives))
+			}
+			for _, d := range drives {
+				want, ok := tc.drives[d.Name]
+				if !ok {
+					t.Errorf("unexpected drive %s", d.Name)
+					continue
+				}
+				want.Name, want.SysfsPath = d.Name, d.SysfsPath
+				if d != want {
+					t.Errorf("got %+v, want %+v", d, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSCSIInfo(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-3.10"))
+	scsiHosts := getSCSIHostsInfo()
+	if len(scsiHosts) != 1 {
+		t.Fatalf("got %d SCSI hosts, want 1", len(scsiHosts))
+	}
+	for tag, value := range map[string]string{"Name": "host0", "Module Name": "ahci", "Unique ID": "1"} {
+		if v, _ := detail(scsiHosts[0], tag); v != value {
+			t.Errorf("got %s %q, want %q", tag, v, value)
+		}
+	}
+
+	details := getSCSIDeviceInfo("sda")
+	if len(details) != 1 || details[0].Tag != "SCSI Host" || details[0].Value != "host0" {
+		t.Errorf("got device details %+v, want SCSI Host host0", details)
+	}
+}
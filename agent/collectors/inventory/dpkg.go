@@ -1,10 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 import (
@@ -39,13 +41,13 @@ func dpkgResultFormat(lines []string) *types.GenericInfo {
 }
 
 // DpkgCollectRun returns formatted output of dpkg-query in []byte
-func DpkgCollectRun() ([]byte, error) {
-	dpkgQuery, err := exec.LookPath("dpkg-query")
+func DpkgCollectRun(ctx context.Context) ([]byte, error) {
+	dpkgQuery, err := collectors.LookPath(ctx, "dpkg-query")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(dpkgQuery, "--show", "--showformat=${Package}\t${Version}\t${Installed-Size}\n").Output()
+	output, err := collectors.Output(ctx, dpkgQuery, "--show", "--showformat=${Package}\t${Version}\t${Installed-Size}\n")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -1,17 +1,16 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
@@ -42,8 +41,8 @@ func (mclog *mcelog) formatToEntity() *types.Entry {
 }
 
 // ECCPrecheck validates ECC dependencies mcelog and dmidecode
-func ECCPrecheck() error {
-	err := isMcelog()
+func ECCPrecheck(ctx context.Context) error {
+	err := isMcelog(ctx)
 	if err == nil {
 		return nil //mcelog is exists
 	}
@@ -56,22 +55,22 @@ func ECCPrecheck() error {
 }
 
 //check is Mcelog support
-func isMcelog() error {
+func isMcelog(ctx context.Context) error {
 
-	if _, err := exec.LookPath("mcelog"); err != nil {
+	if _, err := collectors.LookPath(ctx, "mcelog"); err != nil {
 		return err
 	}
 
-	if len(getMCELOGFilePath(mcelogConfigurationFile)) == 0 {
+	if len(getMCELOGFilePath(ctx, collectors.HostPath(mcelogConfigurationFile))) == 0 {
 		return errors.New("MCELOG logfile place not found")
 	}
 
-	dmi, er := exec.LookPath("dmidecode")
+	dmi, er := collectors.LookPath(ctx, "dmidecode")
 	if er != nil {
 		return er
 	}
 
-	output, _ := exec.Command(dmi, "-t processor").Output()
+	output, _ := collectors.Output(ctx, dmi, "-t processor")
 
 	result := string(output) //MCELOG is not support AMD and not support 32 bit sys
 	if strings.Contains(result, "AMD") || strings.Contains(result, "32-bit") {
@@ -83,7 +82,7 @@ func isMcelog() error {
 
 //check is edac support
 func isEdacLog() error {
-	if _, err := os.Stat(edacHomeMemory + "/mc0"); err != nil {
+	if _, err := collectors.Stat(collectors.HostPath(edacHomeMemory) + "/mc0"); err != nil {
 		return fmt.Errorf("EDAC is not found: %v", err)
 	}
 
@@ -91,13 +90,13 @@ func isEdacLog() error {
 }
 
 // ECCRun returns inventory of ECC in []byte
-func ECCRun() ([]byte, error) {
+func ECCRun(ctx context.Context) ([]byte, error) {
 	var logs []mcelog
 
-	if isMcelog() == nil {
-		logs = parseMcelog(getMCELOGFilePath(mcelogConfigurationFile))
+	if isMcelog(ctx) == nil {
+		logs = parseMcelog(ctx, collectors.HostPath(getMCELOGFilePath(ctx, collectors.HostPath(mcelogConfigurationFile))))
 	} else {
-		logs = parseEdacFolder(edacHomeMemory)
+		logs = parseEdacFolder(collectors.HostPath(edacHomeMemory))
 	}
 
 	result := formatLogMCELOG(logs)
@@ -106,50 +105,51 @@ func ECCRun() ([]byte, error) {
 
 func parseEdacFolder(path string) []mcelog {
 	var eccLogErr = make([]mcelog, 0)
-	memoryControllers, err := filepath.Glob(edacHomeMemory + "/mc*")
+	memoryControllers, err := collectors.Glob(path + "/mc*")
 	if err != nil {
 		return eccLogErr
 	}
 
 	for _, mc := range memoryControllers {
-		processMemoryController(mc, eccLogErr)
+		eccLogErr = processMemoryController(mc, eccLogErr)
 	}
 
 	return eccLogErr
 }
 
 func processMemoryController(mcFolder string, eccLogErr []mcelog) []mcelog {
-	csrows, _ := filepath.Glob(mcFolder + "/csrow*")
-	cpuRaw := regexp.MustCompile("mc(\\d+)").FindStringSubmatch(mcFolder)
+	csrows, _ := collectors.Glob(mcFolder + "/csrow*")
+	cpuRaw := regexp.MustCompile("^mc(\\d+)$").FindStringSubmatch(filepath.Base(mcFolder))
+	if cpuRaw == nil {
+		return eccLogErr
+	}
 
 	cpuNumber := cpuRaw[1]
 	for _, csrow := range csrows {
-		processCsrows(cpuNumber, csrow, eccLogErr)
+		eccLogErr = processCsrows(cpuNumber, csrow, eccLogErr)
 	}
 
 	return eccLogErr
 }
 
-func processCsrows(cpuNumber, csrow string, eccLogErr []mcelog) {
-	ceTotal, _ := ioutil.ReadFile(csrow + "/ce_count") //Corrected error
-	ueTotal, _ := ioutil.ReadFile(csrow + "/ue_count") //Uncorrected error
+func processCsrows(cpuNumber, csrow string, eccLogErr []mcelog) []mcelog {
+	ceTotal := readSysfsValue(csrow + "/ce_count") //Corrected error
+	ueTotal := readSysfsValue(csrow + "/ue_count") //Uncorrected error
 
-	if string(ceTotal) == "0" && string(ueTotal) == "0" {
-		return
+	if ceTotal == "0" && ueTotal == "0" {
+		return eccLogErr
 	}
 	chanCount := 0
 	for true {
 
-		bank, err := ioutil.ReadFile(csrow + fmt.Sprintf("/ch%d_dimm_label", chanCount))
+		bank, err := collectors.ReadFile(csrow + fmt.Sprintf("/ch%d_dimm_label", chanCount))
 		if err != nil {
 			break // no new chan
 		}
 
-		ceRaw, _ := ioutil.ReadFile(csrow + fmt.Sprintf("/ch%d_ce_count", chanCount))
-		ueRaw, _ := ioutil.ReadFile(csrow + fmt.Sprintf("/ch%d_ue_count", chanCount))
-		ce, _ := strconv.Atoi(string(ceRaw))
-		ue, _ := strconv.Atoi(string(ueRaw))
-		logErr := mcelog{bank: string(bank), ce: ce, ue: ue, cpu: cpuNumber}
+		ce, _ := strconv.Atoi(readSysfsValue(csrow + fmt.Sprintf("/ch%d_ce_count", chanCount)))
+		ue, _ := strconv.Atoi(readSysfsValue(csrow + fmt.Sprintf("/ch%d_ue_count", chanCount)))
+		logErr := mcelog{bank: strings.TrimSpace(string(bank)), ce: ce, ue: ue, cpu: cpuNumber}
 
 		if logErr.ue != 0 || logErr.ce != 0 {
 			if exists, pos := containsLog(eccLogErr, logErr); exists {
@@ -162,6 +162,14 @@ func processCsrows(cpuNumber, csrow string, eccLogErr []mcelog) {
 		}
 		chanCount++
 	}
+	return eccLogErr
+}
+
+// readSysfsValue returns the content of a sysfs attribute file without the trailing newline, or
+// empty if it can't be read
+func readSysfsValue(path string) string {
+	fc, _ := collectors.ReadFile(path)
+	return strings.TrimSpace(string(fc))
 }
 
 func formatLogMCELOG(logs []mcelog) *types.GenericInfo {
@@ -178,9 +186,9 @@ func formatLogMCELOG(logs []mcelog) *types.GenericInfo {
 }
 
 //move all data from mcelog log to struct
-func parseMcelog(mcelogFilepath string) []mcelog {
+func parseMcelog(ctx context.Context, mcelogFilepath string) []mcelog {
 
-	output, err := exec.Command("grep", errorMcelogHeader, "-A", "15", mcelogFilepath).Output()
+	output, err := collectors.Output(ctx, "grep", errorMcelogHeader, "-A", "15", mcelogFilepath)
 	if err != nil {
 		return nil
 	}
@@ -234,11 +242,11 @@ func containsLog(logs []mcelog, log mcelog) (bool, int) {
 }
 
 //Get path to mcelog logfile from mcelog config
-func getMCELOGFilePath(confPath string) string {
-	if _, err := os.Stat(confPath); err != nil {
+func getMCELOGFilePath(ctx context.Context, confPath string) string {
+	if _, err := collectors.Stat(confPath); err != nil {
 		return "" //mcelog config file can't be read (miss or no accsess)
 	}
-	output, _ := exec.Command("grep", "^logfile", confPath).Output()
+	output, _ := collectors.Output(ctx, "grep", "^logfile", confPath)
 
 	conf := string(output)
 
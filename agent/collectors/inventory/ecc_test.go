@@ -0,0 +1,32 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+func TestParseEdacFolder(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-3.10"))
+	if err := isEdacLog(); err != nil {
+		t.Fatalf("isEdacLog() error: %v", err)
+	}
+	logs := parseEdacFolder(collectors.HostPath(edacHomeMemory))
+	// channels and csrows without errors are left out
+	want := []mcelog{{cpu: "0", bank: "CPU_SrcID#0_Channel#0_DIMM#0", ce: 3}}
+	if len(logs) != len(want) {
+		t.Fatalf("got %+v, want %+v", logs, want)
+	}
+	for i := range want {
+		if logs[i] != want[i] {
+			t.Errorf("got %+v, want %+v", logs[i], want[i])
+		}
+	}
+
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-6.1"))
+	if err := isEdacLog(); err == nil {
+		t.Error("isEdacLog() without EDAC returned no error")
+	}
+}
@@ -1,12 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 )
 
@@ -34,8 +34,8 @@ func ipmiToolResultFormat(lines []string) *types.GenericInfo {
 	return &g
 }
 
-func ipmiRunCmds() ([]string, error) {
-	fInfoArr, err := ioutil.ReadDir("/dev")
+func ipmiRunCmds(ctx context.Context) ([]string, error) {
+	fInfoArr, err := collectors.ReadDir(collectors.HostPath("/dev"))
 	if err != nil {
 		return nil, fmt.Errorf("cannot read /dev: %v", err)
 	}
@@ -50,12 +50,12 @@ func ipmiRunCmds() ([]string, error) {
 		return nil, fmt.Errorf("cannot run ipmitool: no ipmi devices to process in /dev/")
 	}
 
-	ipmitool, err := exec.LookPath("ipmitool")
+	ipmitool, err := collectors.LookPath(ctx, "ipmitool")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(ipmitool, "mc", "info").Output()
+	output, err := collectors.Output(ctx, ipmitool, "mc", "info")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -65,7 +65,7 @@ func ipmiRunCmds() ([]string, error) {
 	}
 	lines := strings.Split(string(output), "\n")
 
-	output, err = exec.Command(ipmitool, "lan", "print").Output()
+	output, err = collectors.Output(ctx, ipmitool, "lan", "print")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -78,8 +78,8 @@ func ipmiRunCmds() ([]string, error) {
 }
 
 // IpmiToolRun returns inventory of Management Controller and LAN channels
-func IpmiToolRun() ([]byte, error) {
-	lines, err := ipmiRunCmds()
+func IpmiToolRun(ctx context.Context) ([]byte, error) {
+	lines, err := ipmiRunCmds(ctx)
 	if err != nil {
 		return nil, err
 	}
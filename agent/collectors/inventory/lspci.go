@@ -1,11 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
@@ -41,13 +42,13 @@ func lspciResultFormat(lines []string) *types.GenericInfo {
 }
 
 // LsPCIRun returns inventory of all available PCI devices
-func LsPCIRun() ([]byte, error) {
-	lspci, err := exec.LookPath("lspci")
+func LsPCIRun(ctx context.Context) ([]byte, error) {
+	lspci, err := collectors.LookPath(ctx, "lspci")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(lspci).Output()
+	output, err := collectors.Output(ctx, lspci)
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -1,11 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 )
 
@@ -37,13 +38,13 @@ func lsusbResultFormat(sdevs []string) *types.GenericInfo {
 }
 
 // LsUSBRun returns inventory of all available USB devices
-func LsUSBRun() ([]byte, error) {
-	lsusb, err := exec.LookPath("lsusb")
+func LsUSBRun(ctx context.Context) ([]byte, error) {
+	lsusb, err := collectors.LookPath(ctx, "lsusb")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(lsusb).Output()
+	output, err := collectors.Output(ctx, lsusb)
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -1,20 +1,24 @@
+//go:build linux
 // +build linux
 
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
+// sysClassNet is the sysfs directory of network interfaces
+const sysClassNet = "/sys/class/net"
+
 func ethtoolParse(inp string) types.Detail {
 	for _, line := range strings.Split(inp, "\n") {
 		line = strings.TrimSpace(line)
@@ -70,8 +74,8 @@ func parseVLANDetails(adapter, fileContent string) (details []types.Detail) {
 
 // isPhysicalInterface returns true if interface is physical
 func isPhysicalInterface(ifname string) (bool, error) {
-	fn := "/sys/class/net/" + ifname
-	link, err := os.Readlink(fn)
+	fn := path.Join(collectors.HostPath(sysClassNet), ifname)
+	link, err := collectors.Readlink(fn)
 	if err != nil {
 		return false, fmt.Errorf("cannot readlink %s", fn)
 	}
@@ -81,12 +85,12 @@ func isPhysicalInterface(ifname string) (bool, error) {
 	return true, nil
 }
 
-func readAdapter(adapter string) *types.Entry {
+func readAdapter(ctx context.Context, adapter string) *types.Entry {
 	e := types.Entry{}
 	e.Category = adapter
 
 	// Read /sys/class/net/{device}/ifindex and iflink
-	fc, err := ioutil.ReadFile(path.Join("/sys/class/net", adapter, "ifindex"))
+	fc, err := collectors.ReadFile(path.Join(collectors.HostPath(sysClassNet), adapter, "ifindex"))
 	if err != nil {
 		log.Errorf("can't read /sys/class/net/%v/ifindex, %v", adapter, err)
 	}
@@ -94,7 +98,7 @@ func readAdapter(adapter string) *types.Entry {
 	e.Details = append(e.Details, d)
 
 	// Read /sys/class/net/{device}/iflink
-	fc, err = ioutil.ReadFile(path.Join("/sys/class/net", adapter, "iflink"))
+	fc, err = collectors.ReadFile(path.Join(collectors.HostPath(sysClassNet), adapter, "iflink"))
 	if err != nil {
 		log.Errorf("can't read /sys/class/net/%v/iflink, %v", adapter, err)
 	}
@@ -102,7 +106,7 @@ func readAdapter(adapter string) *types.Entry {
 	e.Details = append(e.Details, d)
 
 	// Read /sys/class/net/{device}/address
-	fc, err = ioutil.ReadFile(path.Join("/sys/class/net", adapter, "address"))
+	fc, err = collectors.ReadFile(path.Join(collectors.HostPath(sysClassNet), adapter, "address"))
 	if err != nil {
 		log.Errorf("can't read /sys/class/net/%v/address, %v", adapter, err)
 	}
@@ -110,7 +114,7 @@ func readAdapter(adapter string) *types.Entry {
 	e.Details = append(e.Details, d)
 
 	// Read /sys/class/net/{device}/type
-	fc, err = ioutil.ReadFile(path.Join("/sys/class/net", adapter, "type"))
+	fc, err = collectors.ReadFile(path.Join(collectors.HostPath(sysClassNet), adapter, "type"))
 	if err != nil {
 		log.Errorf("can't read /sys/class/net/%v/address, %v", adapter, err)
 	}
@@ -119,13 +123,13 @@ func readAdapter(adapter string) *types.Entry {
 
 	// Follow symlink
 	// TODO: Improve this
-	fstr, err := os.Readlink(path.Join("/sys/class/net", adapter))
+	fstr, err := collectors.Readlink(path.Join(collectors.HostPath(sysClassNet), adapter))
 	pciBus := strings.TrimPrefix(strings.TrimSpace(fstr), "../../devices/")
 	d = types.Detail{Tag: "pciBus", Value: pciBus}
 	e.Details = append(e.Details, d)
 
 	// get driver from /sys/class/net/{device}/
-	fstr, err = os.Readlink(path.Join("/sys/class/net", adapter, "device/driver"))
+	fstr, err = collectors.Readlink(path.Join(collectors.HostPath(sysClassNet), adapter, "device/driver"))
 	if err == nil {
 		d = types.Detail{Tag: "driver", Value: path.Base(fstr)}
 		e.Details = append(e.Details, d)
@@ -134,7 +138,7 @@ func readAdapter(adapter string) *types.Entry {
 	// Exec ethtool -i {device}
 	//if the interface is virtual, just return a warning
 	physical, _ := isPhysicalInterface(adapter)
-	output, err := exec.Command("ethtool", "-i", string(adapter)).Output()
+	output, err := collectors.Output(ctx, "ethtool", "-i", string(adapter))
 	if err != nil {
 		if physical {
 			log.Errorf(fmt.Sprintf("cannot run ethtool -i for interface %s : %v, recieved output [%s]", adapter, err, string(output)))
@@ -143,7 +147,7 @@ func readAdapter(adapter string) *types.Entry {
 		d := ethtoolParseDashI(string(output))
 		e.Details = append(e.Details, d)
 		// Exec ethtool {device}
-		output, err = exec.Command("ethtool", string(adapter)).Output()
+		output, err = collectors.Output(ctx, "ethtool", string(adapter))
 		if err != nil {
 			if physical {
 				log.Errorf(fmt.Sprintf("cannot run ethtool for interface %s : %v, recieved output [%s]", adapter, err, string(output)))
@@ -155,7 +159,7 @@ func readAdapter(adapter string) *types.Entry {
 	}
 
 	// Exec ip addr show {device}
-	output, err = exec.Command("ip", "addr", "show", string(adapter)).Output()
+	output, err = collectors.Output(ctx, "ip", "addr", "show", string(adapter))
 	if err != nil {
 		log.Errorf(fmt.Sprintf("cannot run ip addr show: %v,[%s]", err, string(output)))
 	}
@@ -163,7 +167,7 @@ func readAdapter(adapter string) *types.Entry {
 	e.Details = append(e.Details, parseIPAddresses(string(output))...)
 
 	//check for vlan info
-	fc, err = ioutil.ReadFile(path.Join("/proc/net/vlan", adapter))
+	fc, err = collectors.ReadFile(path.Join(collectors.HostPath("/proc/net/vlan"), adapter))
 	if err != nil && !os.IsNotExist(err) {
 		log.Errorf("can't read /proc/net/vlan/%v, %v", adapter, err)
 	} else if err == nil {
@@ -172,18 +176,18 @@ func readAdapter(adapter string) *types.Entry {
 	}
 
 	//check for bond master
-	fstr, err = os.Readlink(path.Join("/sys/class/net", adapter, "master"))
+	fstr, err = collectors.Readlink(path.Join(collectors.HostPath(sysClassNet), adapter, "master"))
 	if err == nil {
 		d = types.Detail{Tag: "bondMaster", Value: path.Base(fstr)}
 		e.Details = append(e.Details, d)
 	}
 
 	//check for bond info
-	bondingDir := path.Join("/sys/class/net", adapter, "bonding")
-	if fi, err := os.Stat(bondingDir); err == nil && fi.IsDir() {
+	bondingDir := path.Join(collectors.HostPath(sysClassNet), adapter, "bonding")
+	if fi, err := collectors.Stat(bondingDir); err == nil && fi.IsDir() {
 		//get the bond mode
 		modeFile := path.Join(bondingDir, "mode")
-		fc, err = ioutil.ReadFile(modeFile)
+		fc, err = collectors.ReadFile(modeFile)
 		if err != nil && !os.IsNotExist(err) {
 			log.Errorf("error reading %s: %v", modeFile, err)
 		} else if err == nil {
@@ -193,7 +197,7 @@ func readAdapter(adapter string) *types.Entry {
 
 		//get the bond slaves
 		slavesFile := path.Join(bondingDir, "slaves")
-		fc, err = ioutil.ReadFile(slavesFile)
+		fc, err = collectors.ReadFile(slavesFile)
 		if err != nil && !os.IsNotExist(err) {
 			log.Errorf("error reading %s: %v", slavesFile, err)
 		} else if err == nil {
@@ -208,7 +212,7 @@ func readAdapter(adapter string) *types.Entry {
 
 		//get the primary bond slave
 		primarySlaveFile := path.Join(bondingDir, "primary")
-		fc, err = ioutil.ReadFile(primarySlaveFile)
+		fc, err = collectors.ReadFile(primarySlaveFile)
 		if err != nil && !os.IsNotExist(err) {
 			log.Errorf("error reading %s: %v", primarySlaveFile, err)
 		} else if err == nil {
@@ -224,17 +228,17 @@ func readAdapter(adapter string) *types.Entry {
 	return &e
 }
 
-func readAll() (*types.GenericInfo, error) {
+func readAll(ctx context.Context) (*types.GenericInfo, error) {
 	g := types.GenericInfo{}
 	g.Entries = make([]types.Entry, 0)
-	devices, err := ioutil.ReadDir("/sys/class/net")
+	devices, err := collectors.ReadDir(collectors.HostPath(sysClassNet))
 	if err != nil {
 		log.Errorf("can't read /sys/class/net, %v", err)
 		return nil, err
 	}
 
 	for _, device := range devices {
-		if _, err := ioutil.ReadDir(path.Join(path.Join("/sys/class/net", device.Name()))); err != nil {
+		if _, err := collectors.ReadDir(path.Join(collectors.HostPath(sysClassNet), device.Name())); err != nil {
 			continue
 		}
 
@@ -242,7 +246,7 @@ func readAll() (*types.GenericInfo, error) {
 			continue
 		}
 
-		if e := readAdapter(device.Name()); e != nil {
+		if e := readAdapter(ctx, device.Name()); e != nil {
 			if len(e.Details) == 0 {
 				continue
 			}
@@ -256,8 +260,8 @@ func readAll() (*types.GenericInfo, error) {
 }
 
 // NicRun returns inventory of all network interfaces
-func NicRun() ([]byte, error) {
-	result, err := readAll()
+func NicRun(ctx context.Context) ([]byte, error) {
+	result, err := readAll(ctx)
 	if err != nil {
 		return nil, err
 	}
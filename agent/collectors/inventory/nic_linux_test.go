@@ -0,0 +1,68 @@
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+func TestNicReadAll(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host    string
+		details map[string]map[string]string // details read from sysfs by interface
+	}{
+		{"linux-3.10", map[string]map[string]string{
+			"enp0s3": {"ifindex": "2", "hardwareAddress": "08:00:27:4e:66:a1", "hardwareType": "1", "driver": "e1000", "pciBus": "pci0000:00/0000:00:03.0/net/enp0s3"},
+		}},
+		{"linux-4.19", map[string]map[string]string{
+			"ens3":  {"ifindex": "2", "driver": "virtio_net", "bondMaster": "bond0"},
+			"bond0": {"ifindex": "3", "bondMode": "active-backup 1", "slaves": `["ens3"]`, "primarySlave": "ens3"},
+		}},
+		{"linux-5.15", map[string]map[string]string{
+			"enp1s0":  {"ifindex": "2", "hardwareAddress": "3c:ec:ef:01:02:03", "driver": "igb"},
+			"docker0": {"ifindex": "3", "pciBus": "virtual/net/docker0"},
+		}},
+		{"linux-6.1", map[string]map[string]string{
+			"ens5": {"ifindex": "2", "iflink": "2", "driver": "virtio_net"},
+		}},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			g, err := readAll(context.Background())
+			if err != nil {
+				t.Fatalf("readAll() error: %v", err)
+			}
+			if len(g.Entries) != len(tc.details) {
+				t.Errorf("got %d interfaces, want %d, lo is left out", len(g.Entries), len(tc.details))
+			}
+			for _, e := range g.Entries {
+				want, ok := tc.details[e.Category]
+				if !ok {
+					t.Errorf("unexpected interface %s", e.Category)
+					continue
+				}
+				for tag, value := range want {
+					if v, _ := detail(e, tag); v != value {
+						t.Errorf("got %s of %s %q, want %q", tag, e.Category, v, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIsPhysicalInterface(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	collectors.SetHostRoot(filepath.Join(hosts, "linux-5.15"))
+	for ifname, want := range map[string]bool{"enp1s0": true, "docker0": false, "lo": false} {
+		if physical, err := isPhysicalInterface(ifname); err != nil || physical != want {
+			t.Errorf("isPhysicalInterface(%s) = %v, %v, want %v", ifname, physical, err, want)
+		}
+	}
+	if _, err := isPhysicalInterface("eth9"); err == nil {
+		t.Error("isPhysicalInterface() of missing interface returned no error")
+	}
+}
@@ -1,10 +1,11 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
@@ -26,7 +27,7 @@ func procInfoReadStructured(names []string) (*types.GenericInfo, error) {
 	for _, name := range names {
 		switch name {
 		case "hostname":
-			fc, err := ioutil.ReadFile("/proc/sys/kernel/hostname")
+			fc, err := collectors.ReadFile(collectors.HostPath("/proc/sys/kernel/hostname"))
 			if err != nil {
 				log.Errorf("can't read /proc/sys/kernel/hostname, %v", err)
 				return nil, err
@@ -37,7 +38,7 @@ func procInfoReadStructured(names []string) (*types.GenericInfo, error) {
 			e.Details = append(e.Details, d)
 			g.Entries = append(g.Entries, e)
 		case "partitions":
-			fc, err := ioutil.ReadFile("/proc/partitions")
+			fc, err := collectors.ReadFile(collectors.HostPath("/proc/partitions"))
 			if err != nil {
 				log.Errorf("can't read /proc/partitions, %v", err)
 				return nil, err
@@ -64,7 +65,7 @@ func procInfoReadStructured(names []string) (*types.GenericInfo, error) {
 			}
 			g.Entries = append(g.Entries, e)
 		case "version":
-			fc, err := ioutil.ReadFile("/proc/version")
+			fc, err := collectors.ReadFile(collectors.HostPath("/proc/version"))
 			if err != nil {
 				log.Errorf("can't read /proc/version, %v", err)
 				return nil, err
@@ -78,7 +79,7 @@ func procInfoReadStructured(names []string) (*types.GenericInfo, error) {
 			}
 		case "cpuinfo":
 			e := types.Entry{}
-			fc, err := ioutil.ReadFile("/proc/cpuinfo")
+			fc, err := collectors.ReadFile(collectors.HostPath("/proc/cpuinfo"))
 			if err != nil {
 				log.Errorf("can't read /proc/cpuinfo, %v", err)
 				return nil, err
@@ -126,7 +127,7 @@ func convertToMetric(result *types.GenericInfo) (string, error) {
 }
 
 // ProcInfoRun returns inventory of host version, cpuinfo etc
-func ProcInfoRun() ([]byte, error) {
+func ProcInfoRun(context.Context) ([]byte, error) {
 	which := []string{"version", "partitions", "cpuinfo", "hostname"}
 	result, err := procInfoReadStructured(which)
 	if err != nil {
@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+// detail returns the value of the first detail of e with tag, and if it was found
+func detail(e types.Entry, tag string) (string, bool) {
+	for _, d := range e.Details {
+		if d.Tag == tag {
+			return d.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestProcInfoReadStructured(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host       string
+		hostname   string
+		kernel     string // start of the version detail
+		partitions int
+		cpus       int
+	}{
+		{"linux-3.10", "centos7", "3.10.0-1160.el7.x86_64", 4, 2},
+		{"linux-4.19", "buster", "4.19.0-21-amd64", 2, 4},
+		{"linux-5.15", "jammy", "5.15.0-88-generic", 4, 8},
+		{"linux-6.1", "bookworm", "6.1.0-13-amd64", 3, 2},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			g, err := procInfoReadStructured([]string{"all"})
+			if err != nil {
+				t.Fatalf("procInfoReadStructured() error: %v", err)
+			}
+
+			cpus := 0
+			for _, e := range g.Entries {
+				switch e.Category {
+				case "kernel":
+					if v, _ := detail(e, "hostname"); v != tc.hostname {
+						t.Errorf("got hostname %q, want %q", v, tc.hostname)
+					}
+				case "OSVersion":
+					if v, _ := detail(e, "Linux version"); len(v) < len(tc.kernel) || v[:len(tc.kernel)] != tc.kernel {
+						t.Errorf("got version %q, want %s...", v, tc.kernel)
+					}
+				case "partitions":
+					if len(e.Details) != 2*tc.partitions {
+						t.Errorf("got %d partition details, want %d", len(e.Details), 2*tc.partitions)
+					}
+				case "cpuinfo":
+					cpus++
+					if _, ok := detail(e, "cpu MHz"); ok {
+						t.Errorf("cpu MHz is not left out of %+v", e)
+					}
+					if _, ok := detail(e, "model name"); !ok {
+						t.Errorf("no model name in %+v", e)
+					}
+				}
+			}
+			if cpus != tc.cpus {
+				t.Errorf("got %d cpuinfo entries, want %d", cpus, tc.cpus)
+			}
+		})
+	}
+}
@@ -1,11 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
@@ -36,13 +37,13 @@ func rpmResultFormat(lines []string) *types.GenericInfo {
 }
 
 // RpmCollectRun returns inventory of all installed rpm in []byte
-func RpmCollectRun() ([]byte, error) {
-	rpm, err := exec.LookPath("rpm")
+func RpmCollectRun(ctx context.Context) ([]byte, error) {
+	rpm, err := collectors.LookPath(ctx, "rpm")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(rpm, "-qa", "--qf", `%{NAME}\t%{VERSION}\t%{SIZE}\n`).Output()
+	output, err := collectors.Output(ctx, rpm, "-qa", "--qf", `%{NAME}\t%{VERSION}\t%{SIZE}\n`)
 	if err != nil {
 		out := ""
 		if output != nil {
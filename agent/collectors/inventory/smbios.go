@@ -1,11 +1,12 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
 )
 
@@ -74,13 +75,13 @@ func smbiosResultFormat(lines []string) *types.SMBIOS {
 
 // SMBIOSRun returns inventory of SMBIOS in []byte, which contains hardware
 // components, serial number etc
-func SMBIOSRun() ([]byte, error) {
-	dmidecode, err := exec.LookPath("dmidecode")
+func SMBIOSRun(ctx context.Context) ([]byte, error) {
+	dmidecode, err := collectors.LookPath(ctx, "dmidecode")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(dmidecode).Output()
+	output, err := collectors.Output(ctx, dmidecode)
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -1,11 +1,13 @@
 package load
 
 import (
+	"context"
+
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 // Run returns loadavg metrics
-func Run() ([]*collectors.MetricResult, error) {
+func Run(context.Context) ([]*collectors.MetricResult, error) {
 
 	data, err := loader()
 	if err != nil {
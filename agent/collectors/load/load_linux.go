@@ -1,25 +1,27 @@
 package load
 
 import (
-	"io/ioutil"
+	"fmt"
 	"strings"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 func loader() ([]byte, error) {
-	return ioutil.ReadFile("/proc/loadavg")
+	return collectors.ReadFile(collectors.HostPath("/proc/loadavg"))
 }
 
 func preformatter(data []byte) ([]*collectors.MetricResult, error) {
-
-	metadataProto := "float The load average in regard to both the CPU and IO over time"
-	metadata := map[string]string{
-		"last1min":  metadataProto,
-		"last5min":  metadataProto,
-		"last15min": metadataProto,
-	}
+	help := "The load average in regard to both the CPU and IO over time"
 	parts := strings.Fields(string(data))
-	result := collectors.BuildMetricResult("last1min last5min last15min", parts[0]+" "+parts[1]+" "+parts[2], "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("unexpected content of /proc/loadavg: %q", string(data))
+	}
+	samples := make([]collectors.Sample, 0, 3)
+	for i, name := range []string{"last1min", "last5min", "last15min"} {
+		s := collectors.NewSample(name, parts[i], collectors.FloatValue)
+		s.Help = help
+		samples = append(samples, s)
+	}
+	return []*collectors.MetricResult{{Samples: samples}}, nil
 }
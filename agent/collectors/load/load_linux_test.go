@@ -0,0 +1,51 @@
+package load
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host string
+		want [3]float64
+	}{
+		{"linux-3.10", [3]float64{0.15, 0.10, 0.05}},
+		{"linux-4.19", [3]float64{1.20, 0.80, 0.40}},
+		{"linux-5.15", [3]float64{0.42, 0.51, 0.47}},
+		{"linux-6.1", [3]float64{0.00, 0.01, 0.00}},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+			samples := res[0].Samples
+			if len(samples) != 3 {
+				t.Fatalf("got %d samples, want 3", len(samples))
+			}
+			for i, name := range []string{"last1min", "last5min", "last15min"} {
+				if samples[i].Name != name || samples[i].Value != tc.want[i] {
+					t.Errorf("sample %d is %s=%v, want %s=%v", i, samples[i].Name, samples[i].Value, name, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPreformatterInvalid(t *testing.T) {
+	if _, err := preformatter([]byte("0.15 0.10\n")); err == nil {
+		t.Error("preformatter() of truncated loadavg returned no error")
+	}
+}
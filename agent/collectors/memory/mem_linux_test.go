@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host  string
+		total float64 // kB
+	}{
+		{"linux-3.10", 3881936},
+		{"linux-4.19", 8167840},
+		{"linux-5.15", 65536000},
+		{"linux-6.1", 4026532},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+			index := collectors.IndexSamples(res[0].Samples)
+			if s := index["MemTotal"]; s == nil || s.Value != tc.total || s.Unit != "kB" || s.Help == "" {
+				t.Errorf("MemTotal is %+v, want %v kB with help", s, tc.total)
+			}
+			if s := index["MemAvailable"]; s == nil || s.Value != tc.total/2 {
+				t.Errorf("MemAvailable is %+v, want %v", s, tc.total/2)
+			}
+			if s := index["HugePages_Total"]; s == nil || s.Unit != "" {
+				t.Errorf("HugePages_Total is %+v, want no unit", s)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package memory
 
 import (
+	"context"
+
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 // Run returns memory metrics
-func Run() ([]*collectors.MetricResult, error) {
+func Run(context.Context) ([]*collectors.MetricResult, error) {
 
 	data, err := loader()
 	if err != nil {
@@ -0,0 +1,53 @@
+package net
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host       string
+		interfaces []string
+		iface      string  // interface whose bytes are checked
+		rx, tx     float64 // bytes
+	}{
+		{"linux-3.10", []string{"lo", "enp0s3"}, "enp0s3", 987654321, 123456789},
+		{"linux-4.19", []string{"lo", "ens3", "bond0"}, "ens3", 5555555, 4444444},
+		{"linux-5.15", []string{"lo", "enp1s0", "docker0"}, "enp1s0", 123456789012, 98765432109},
+		{"linux-6.1", []string{"lo", "ens5"}, "ens5", 77777777, 66666666},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+			if want := len(tc.interfaces) * 16; len(res[0].Samples) != want {
+				t.Errorf("got %d samples, want %d", len(res[0].Samples), want)
+			}
+			index := collectors.IndexSamples(res[0].Samples)
+			for _, iface := range tc.interfaces {
+				if index[iface+"."+netPacketsRX] == nil {
+					t.Errorf("no %s of interface %s", netPacketsRX, iface)
+				}
+			}
+			if s := index[tc.iface+"."+netBytesRX]; s == nil || s.Value != tc.rx || s.Unit != "bytes" || s.Kind != collectors.Counter {
+				t.Errorf("%s of %s is %+v, want counter of %v bytes", netBytesRX, tc.iface, s, tc.rx)
+			}
+			if s := index[tc.iface+"."+netBytesTX]; s == nil || s.Value != tc.tx {
+				t.Errorf("%s of %s is %+v, want %v", netBytesTX, tc.iface, s, tc.tx)
+			}
+		})
+	}
+}
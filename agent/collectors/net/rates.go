@@ -0,0 +1,10 @@
+package net
+
+import (
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// Rates returns the per second rates of the counters of every interface since the previous collection
+func Rates(prev, cur []collectors.Sample) []collectors.Sample {
+	return collectors.CounterRates(prev, cur)
+}
@@ -0,0 +1,177 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BundleIndex is the file of a command bundle which lists its commands, their outputs are in files
+// next to it
+const BundleIndex = "commands.json"
+
+// bundle is the index of a command bundle
+type bundle struct {
+	Paths    map[string]string `json:"paths"`    // executables looked up, empty if not found
+	Commands []*bundleCommand  `json:"commands"` // commands in the order they were run
+}
+
+// bundleCommand is a recorded command and its result
+type bundleCommand struct {
+	Args     []string `json:"args"`             // name and arguments
+	Stdout   string   `json:"stdout,omitempty"` // file of stdout in the bundle
+	Stderr   string   `json:"stderr,omitempty"` // file of stderr in the bundle
+	ExitCode int      `json:"exitCode"`
+	Killed   bool     `json:"killed,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	stdout, stderr []byte
+}
+
+// key returns the command line of c, which identifies it on replay
+func (c *bundleCommand) key() string {
+	return strings.Join(c.Args, "\x00")
+}
+
+// Recorder is a runner which runs commands with another runner and records them with their results,
+// so that they can be saved into a bundle and replayed by a Replayer
+type Recorder struct {
+	runner Runner
+	mtx    sync.Mutex
+	bundle bundle
+}
+
+// NewRecorder returns a recorder of the commands run by r
+func NewRecorder(r Runner) *Recorder {
+	return &Recorder{runner: r, bundle: bundle{Paths: make(map[string]string)}}
+}
+
+// LookPath searches for executable file with the recorded runner and records the result
+func (r *Recorder) LookPath(file string) (string, error) {
+	path, err := r.runner.LookPath(file)
+	r.mtx.Lock()
+	r.bundle.Paths[file] = path
+	r.mtx.Unlock()
+	return path, err
+}
+
+// Run runs name with args with the recorded runner and records the result
+func (r *Recorder) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	res, err := r.runner.Run(ctx, opts, name, args...)
+	c := &bundleCommand{Args: append([]string{name}, args...), ExitCode: -1}
+	if res != nil {
+		c.stdout, c.stderr, c.ExitCode, c.Killed = res.Stdout, res.Stderr, res.ExitCode, res.Killed
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	r.mtx.Lock()
+	r.bundle.Commands = append(r.bundle.Commands, c)
+	r.mtx.Unlock()
+	return res, err
+}
+
+// Save writes the recorded commands into bundle directory dir, which is created if needed
+func (r *Recorder) Save(dir string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, c := range r.bundle.Commands {
+		for _, out := range []struct {
+			file *string
+			data []byte
+			ext  string
+		}{{&c.Stdout, c.stdout, "stdout"}, {&c.Stderr, c.stderr, "stderr"}} {
+			if len(out.data) == 0 {
+				continue
+			}
+			*out.file = fmt.Sprintf("%04d-%s.%s", i+1, filepath.Base(c.Args[0]), out.ext)
+			if err := ioutil.WriteFile(filepath.Join(dir, *out.file), out.data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := json.MarshalIndent(r.bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, BundleIndex), append(data, '\n'), 0644)
+}
+
+// Replayer is a runner which serves the results of commands recorded in a bundle. A command run more
+// often than it was recorded gets its last result again
+type Replayer struct {
+	mtx      sync.Mutex
+	paths    map[string]string
+	commands map[string][]*bundleCommand // results by command line
+	next     map[string]int              // index of next result by command line
+}
+
+// LoadReplayer returns a replayer of the commands of bundle directory dir
+func LoadReplayer(dir string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, BundleIndex))
+	if err != nil {
+		return nil, err
+	}
+	var b bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", BundleIndex, err)
+	}
+	r := &Replayer{paths: b.Paths, commands: make(map[string][]*bundleCommand), next: make(map[string]int)}
+	for _, c := range b.Commands {
+		if len(c.Args) == 0 {
+			return nil, fmt.Errorf("invalid %s: command without args", BundleIndex)
+		}
+		for _, out := range []struct {
+			file string
+			data *[]byte
+		}{{c.Stdout, &c.stdout}, {c.Stderr, &c.stderr}} {
+			if out.file == "" {
+				continue
+			}
+			if *out.data, err = ioutil.ReadFile(filepath.Join(dir, out.file)); err != nil {
+				return nil, err
+			}
+		}
+		r.commands[c.key()] = append(r.commands[c.key()], c)
+	}
+	return r, nil
+}
+
+// LookPath returns the recorded path of executable file
+func (r *Replayer) LookPath(file string) (string, error) {
+	if path := r.paths[file]; path != "" {
+		return path, nil
+	}
+	return "", &os.PathError{Op: "lookpath", Path: file, Err: errors.New("executable file not found in recording")}
+}
+
+// Run returns the recorded result of name with args
+func (r *Replayer) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	key := (&bundleCommand{Args: append([]string{name}, args...)}).key()
+	r.mtx.Lock()
+	results := r.commands[key]
+	i := r.next[key]
+	if i < len(results)-1 {
+		r.next[key]++
+	}
+	r.mtx.Unlock()
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s: command not recorded: %s", name, strings.Join(append([]string{name}, args...), " "))
+	}
+
+	c := results[i]
+	res := &Result{Stdout: c.stdout, Stderr: c.stderr, ExitCode: c.ExitCode, Killed: c.Killed}
+	if c.Error != "" {
+		return res, errors.New(c.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,84 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// fakeRunner runs no command, it returns a result numbered by the calls
+type fakeRunner struct {
+	calls int
+}
+
+func (f *fakeRunner) LookPath(file string) (string, error) {
+	if file == "missing" {
+		return "", errors.New("not found")
+	}
+	return "/usr/bin/" + file, nil
+}
+
+func (f *fakeRunner) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	f.calls++
+	if name == "/usr/bin/fail" {
+		return &Result{Stderr: []byte("failed\n"), ExitCode: 2}, errors.New("exit status 2")
+	}
+	return &Result{Stdout: []byte(fmt.Sprintf("call %d\n", f.calls))}, nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	rec := NewRecorder(&fakeRunner{})
+	ctx := WithRunner(context.Background(), rec)
+	for _, file := range []string{"tool", "fail", "missing"} {
+		LookPath(ctx, file)
+	}
+	Output(ctx, "/usr/bin/tool", "-a")
+	Output(ctx, "/usr/bin/tool", "-a")
+	Output(ctx, "/usr/bin/tool", "-b")
+	Run(ctx, "/usr/bin/fail")
+
+	dir := t.TempDir()
+	if err := rec.Save(dir); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	r, err := LoadReplayer(dir)
+	if err != nil {
+		t.Fatalf("LoadReplayer() error: %v", err)
+	}
+	ctx = WithRunner(context.Background(), r)
+
+	if path, err := LookPath(ctx, "tool"); err != nil || path != "/usr/bin/tool" {
+		t.Errorf("LookPath(tool) = %q, %v", path, err)
+	}
+	var pathErr *os.PathError
+	if _, err := LookPath(ctx, "missing"); !errors.As(err, &pathErr) {
+		t.Errorf("LookPath(missing) error = %v, want a path error", err)
+	}
+	// the last result of a command is repeated
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-a"}, "call 1\n"},
+		{[]string{"-b"}, "call 3\n"},
+		{[]string{"-a"}, "call 2\n"},
+		{[]string{"-a"}, "call 2\n"},
+	} {
+		out, err := Output(ctx, "/usr/bin/tool", tc.args...)
+		if err != nil || string(out) != tc.want {
+			t.Errorf("tool %v = %q, %v, want %q", tc.args, out, err, tc.want)
+		}
+	}
+	res, err := Run(ctx, "/usr/bin/fail")
+	if err == nil || err.Error() != "exit status 2" {
+		t.Errorf("fail error = %v, want exit status 2", err)
+	}
+	if res == nil || res.ExitCode != 2 || string(res.Stderr) != "failed\n" {
+		t.Errorf("fail result = %+v", res)
+	}
+	if _, err := Output(ctx, "/usr/bin/tool", "-c"); err == nil {
+		t.Error("command which was not recorded succeeded")
+	}
+}
@@ -0,0 +1,115 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOutputLimit is returned when a command wrote more output than RunOptions allow
+var ErrOutputLimit = errors.New("output limit exceeded")
+
+// killWaitDelay is how long Run waits for the output of a killed command
+const killWaitDelay = time.Second
+
+// Result is the outcome of a command run by Run
+type Result struct {
+	Stdout, Stderr []byte
+	ExitCode       int  // exit code, -1 if the command did not exit by itself
+	Killed         bool // command was killed because its context was done
+}
+
+// RunOptions are optional settings of a command run by RunWith
+type RunOptions struct {
+	Dir       string                    // working directory, the agent's if empty
+	Env       []string                  // environment, the agent's if nil
+	MaxOutput uint64                    // max bytes of stdout and of stderr, 0 is unlimited
+	Setup     func(cmd *exec.Cmd) error // called before the command starts, e.g. to set its credentials
+}
+
+type killCountKey struct{}
+
+// WithKillCount returns a context which counts the commands killed by Run because it was done,
+// and a function returning that count
+func WithKillCount(ctx context.Context) (context.Context, func() int) {
+	n := new(int32)
+	return context.WithValue(ctx, killCountKey{}, n), func() int { return int(atomic.LoadInt32(n)) }
+}
+
+// Output runs name with args and returns its stdout, like exec.Cmd.Output
+func Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	res, err := RunWith(ctx, RunOptions{}, name, args...)
+	if res == nil {
+		return nil, err
+	}
+	return res.Stdout, err
+}
+
+// Run runs name with args, see RunWith
+func Run(ctx context.Context, name string, args ...string) (*Result, error) {
+	return RunWith(ctx, RunOptions{}, name, args...)
+}
+
+// RunWith runs name with args by the runner of ctx, see ExecRunner
+func RunWith(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	res, err := RunnerFrom(ctx).Run(ctx, opts, name, args...)
+	if res != nil && res.Killed {
+		if n, ok := ctx.Value(killCountKey{}).(*int32); ok {
+			atomic.AddInt32(n, 1)
+		}
+	}
+	return res, err
+}
+
+// execRun runs name with args on the host, see ExecRunner
+func execRun(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	stdout := &limitedBuffer{max: opts.MaxOutput}
+	stderr := &limitedBuffer{max: opts.MaxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = killWaitDelay
+	if opts.Setup != nil {
+		if err := opts.Setup(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	var killed int32
+	setProcessGroup(cmd, func() { atomic.StoreInt32(&killed, 1) })
+
+	err := cmd.Run()
+	res := &Result{Stdout: stdout.buf.Bytes(), Stderr: stderr.buf.Bytes(), ExitCode: -1}
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	switch {
+	case atomic.LoadInt32(&killed) == 1:
+		res.Killed = true
+		err = fmt.Errorf("%s killed: %w", name, ctx.Err())
+	case stdout.exceeded || stderr.exceeded:
+		err = fmt.Errorf("%s: %w, max is %d bytes", name, ErrOutputLimit, opts.MaxOutput)
+	}
+	return res, err
+}
+
+// limitedBuffer is a buffer which fails writes beyond max bytes, 0 is unlimited
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      uint64
+	exceeded bool // a write was cut short
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && uint64(b.buf.Len()+len(p)) > b.max {
+		n, _ := b.buf.Write(p[:b.max-uint64(b.buf.Len())])
+		b.exceeded = true
+		return n, ErrOutputLimit
+	}
+	return b.buf.Write(p)
+}
@@ -0,0 +1,20 @@
+package collectors
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a new process group, which is killed when cmd is cancelled or the
+// agent dies. killed is called when the group is killed
+func setProcessGroup(cmd *exec.Cmd, killed func()) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	cmd.Cancel = func() error {
+		killed()
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
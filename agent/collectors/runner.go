@@ -0,0 +1,51 @@
+package collectors
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Runner runs the external commands of collectors. Collectors take it from their context, so that
+// commands can be recorded or replayed instead of run on the host
+type Runner interface {
+	// LookPath searches for executable file like exec.LookPath
+	LookPath(file string) (string, error)
+	// Run runs name with args like RunWith
+	Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error)
+}
+
+// ExecRunner runs commands on the host, it is the runner of contexts without one
+type ExecRunner struct{}
+
+// LookPath searches for executable file in PATH
+func (ExecRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+// Run runs name with args in a new process group. When ctx is done, e.g. its deadline passed, the
+// process group is killed, so that no process of the command is left behind. err is set when the
+// command could not start, exited with a non-zero code, which is an *exec.ExitError, was killed or
+// exceeded the output limit
+func (ExecRunner) Run(ctx context.Context, opts RunOptions, name string, args ...string) (*Result, error) {
+	return execRun(ctx, opts, name, args...)
+}
+
+type runnerKey struct{}
+
+// WithRunner returns a context whose commands are run by r
+func WithRunner(ctx context.Context, r Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, r)
+}
+
+// RunnerFrom returns the runner of ctx, ExecRunner if it has none
+func RunnerFrom(ctx context.Context) Runner {
+	if r, ok := ctx.Value(runnerKey{}).(Runner); ok {
+		return r
+	}
+	return ExecRunner{}
+}
+
+// LookPath searches for executable file with the runner of ctx
+func LookPath(ctx context.Context, file string) (string, error) {
+	return RunnerFrom(ctx).LookPath(file)
+}
@@ -0,0 +1,129 @@
+package collectors
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueType is the type of a sample value
+type ValueType string
+
+// Types of sample values
+const (
+	IntValue    ValueType = "int"
+	FloatValue  ValueType = "float"
+	StringValue ValueType = "string"
+)
+
+// Kind tells how a numeric sample behaves over time
+type Kind string
+
+// Kinds of samples
+const (
+	Gauge   Kind = "gauge"   // value may go up and down
+	Counter Kind = "counter" // value accumulates since boot, it only goes up until it is reset
+)
+
+// Label is a name/value pair identifying the instance a sample belongs to, e.g. device=sda
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single typed value collected by a metric collector
+type Sample struct {
+	Name      string    // name of the value, e.g. readsIssued
+	Labels    []Label   // instance of the value, in the order of the legacy column name
+	Type      ValueType // type of the value
+	Kind      Kind      // gauge or counter, for int and float samples
+	Value     float64   // value of int and float samples, for derived values and Prometheus
+	Text      string    // value as collected, sent unchanged in the legacy line format
+	Unit      string    // unit of the value, e.g. bytes, empty if dimensionless
+	Help      string    // description of the value
+	Column    string    // name of the legacy column, when it is not the label values and name joined by "."
+	Timestamp time.Time // when the value was read, zero means at collection time
+}
+
+// NewSample returns a sample of given type parsed from value. A value which does not parse
+// as typ is kept as a string sample. The text of value is kept as it is
+func NewSample(name, value string, typ ValueType, labels ...Label) Sample {
+	s := Sample{Name: name, Labels: labels, Type: typ, Kind: Gauge, Text: value}
+	var err error
+	switch typ {
+	case IntValue:
+		var v int64
+		if v, err = strconv.ParseInt(value, 10, 64); err == nil {
+			s.Value = float64(v)
+		} else {
+			var u uint64
+			if u, err = strconv.ParseUint(value, 10, 64); err == nil {
+				s.Value = float64(u)
+			}
+		}
+	case FloatValue:
+		s.Value, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		s.Type = StringValue
+	}
+	if s.Type == StringValue {
+		s.Kind = ""
+	}
+	return s
+}
+
+// InferSample returns a sample of the narrowest type value parses as
+func InferSample(name, value string, labels ...Label) Sample {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return NewSample(name, value, IntValue, labels...)
+	}
+	if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return NewSample(name, value, IntValue, labels...)
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return NewSample(name, value, FloatValue, labels...)
+	}
+	return NewSample(name, value, StringValue, labels...)
+}
+
+// Label returns the value of the named label
+func (s *Sample) Label(name string) string {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// ColumnName returns the name of the sample in the legacy header line
+func (s *Sample) ColumnName() string {
+	if s.Column != "" {
+		return s.Column
+	}
+	parts := make([]string, 0, len(s.Labels)+1)
+	for _, l := range s.Labels {
+		parts = append(parts, l.Value)
+	}
+	return strings.Join(append(parts, s.Name), ".")
+}
+
+// FormatValue returns the value of the sample as text, as it was collected if it was parsed from text
+func (s *Sample) FormatValue() string {
+	if s.Text != "" || s.Type == StringValue {
+		return s.Text
+	}
+	return s.FormatFloat()
+}
+
+// FormatFloat returns the float64 value of an int or float sample as text
+func (s *Sample) FormatFloat() string {
+	switch s.Type {
+	case IntValue:
+		return strconv.FormatFloat(s.Value, 'f', 0, 64)
+	case FloatValue:
+		return strconv.FormatFloat(s.Value, 'f', -1, 64)
+	}
+	return s.Text
+}
@@ -0,0 +1,67 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleKeepsText(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		typ   ValueType
+	}{
+		{"18446744073709551615", IntValue},
+		{"9007199254740993", IntValue},
+		{"-9007199254740993", IntValue},
+		{"007", IntValue},
+		{"0.10", FloatValue},
+		{"350735.40", FloatValue},
+		{"1e3", FloatValue},
+		{"n/a", StringValue},
+	} {
+		s := InferSample("value", tc.value)
+		if s.Type != tc.typ {
+			t.Errorf("InferSample(%q) type = %s, want %s", tc.value, s.Type, tc.typ)
+		}
+		if got := s.FormatValue(); got != tc.value {
+			t.Errorf("InferSample(%q).FormatValue() = %q, want it unchanged", tc.value, got)
+		}
+		s = NewSample("value", tc.value, tc.typ)
+		if got := s.FormatValue(); got != tc.value {
+			t.Errorf("NewSample(%q, %s).FormatValue() = %q, want it unchanged", tc.value, tc.typ, got)
+		}
+	}
+
+	s := NewSample("value", "abc", IntValue)
+	if s.Type != StringValue || s.FormatValue() != "abc" {
+		t.Errorf("NewSample(abc, int) = %s %q, want string abc", s.Type, s.FormatValue())
+	}
+	s = Sample{Name: "value", Type: FloatValue, Value: 0.25}
+	if got := s.FormatValue(); got != "0.25" {
+		t.Errorf("FormatValue() of a sample without text = %q, want 0.25", got)
+	}
+	s = NewSample("value", "1e3", FloatValue)
+	if got := s.FormatFloat(); got != "1000" {
+		t.Errorf("FormatFloat() = %q, want 1000", got)
+	}
+}
+
+func TestDeltaOfLargeCounters(t *testing.T) {
+	now := time.Now()
+	prev := NewSample("bytes", "18446744073709551000", IntValue)
+	cur := NewSample("bytes", "18446744073709551615", IntValue)
+	prev.Timestamp, cur.Timestamp = now, now.Add(time.Second)
+	if delta, ok := Delta(&prev, &cur); !ok || delta != 615 {
+		t.Errorf("Delta() = %v, %v, want 615", delta, ok)
+	}
+	if _, ok := Delta(&cur, &prev); ok {
+		t.Errorf("Delta() of a counter which went down is ok")
+	}
+
+	prev = NewSample("bytes", "9007199254740992", IntValue)
+	cur = NewSample("bytes", "9007199254740993", IntValue)
+	prev.Timestamp, cur.Timestamp = now, now.Add(time.Second)
+	if rate, ok := Rate(&prev, &cur); !ok || rate != 1 {
+		t.Errorf("Rate() = %v, %v, want 1", rate, ok)
+	}
+}
@@ -2,9 +2,9 @@ package sensor
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
@@ -12,18 +12,18 @@ import (
 )
 
 // IpmiSensorPrecheck validates for presence of dependency ipmitool and dmidecode
-func IpmiSensorPrecheck() error {
-	_, err := exec.LookPath("ipmitool")
+func IpmiSensorPrecheck(ctx context.Context) error {
+	_, err := collectors.LookPath(ctx, "ipmitool")
 	if err != nil {
 		return err
 	}
 
-	dmidecode, err := exec.LookPath("dmidecode")
+	dmidecode, err := collectors.LookPath(ctx, "dmidecode")
 	if err != nil {
 		return err
 	}
 
-	output, err := exec.Command(dmidecode, "--type", "38").Output()
+	output, err := collectors.Output(ctx, dmidecode, "--type", "38")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -39,12 +39,10 @@ func IpmiSensorPrecheck() error {
 	return nil
 }
 
-func formatIpmiSensor(data string) (string, string, map[string]string, error) {
+func formatIpmiSensor(data string) ([]collectors.Sample, error) {
 	sensorColumns := []string{"name", "id", "status", "entityId", "value"}
 	lines := strings.Split(data, "\n")
-	headers := make([]string, 0)
-	metrics := make([]string, 0)
-	metadata := make(map[string]string)
+	samples := make([]collectors.Sample, 0)
 	for _, line := range lines {
 		columns := strings.Split(line, "|")
 
@@ -55,21 +53,18 @@ func formatIpmiSensor(data string) (string, string, map[string]string, error) {
 			continue //error line
 		}
 
+		id := strings.TrimSpace(columns[1])
+		entity := strings.TrimSpace(columns[3])
 		columnCount := 0
 		for i, value := range columns {
 			if i >= len(sensorColumns) {
 				continue //skip extra fields
 			}
-			header := strings.TrimSpace(columns[1]) + "." + sensorColumns[i] + "." + strings.TrimSpace(columns[3])
-			headers = append(headers, header)
-			metadata[header] = "string " + sensorColumns[i] + "of " + columns[0] + " sensor"
-
-			strings.TrimSpace(value)
-			val := strings.Replace(strings.TrimSpace(value), " ", "_", -1) //replace all ' ' to _ for correct formating output
-			if len(val) == 0 {
-				val = "none"
-			}
-			metrics = append(metrics, val)
+			s := collectors.NewSample(sensorColumns[i], strings.TrimSpace(value), collectors.StringValue,
+				collectors.Label{Name: "sensor", Value: id}, collectors.Label{Name: "entity", Value: entity})
+			s.Column = id + "." + sensorColumns[i] + "." + entity
+			s.Help = sensorColumns[i] + " of " + strings.TrimSpace(columns[0]) + " sensor"
+			samples = append(samples, s)
 			columnCount++
 		}
 
@@ -78,22 +73,22 @@ func formatIpmiSensor(data string) (string, string, map[string]string, error) {
 		}
 	}
 
-	if len(headers) < 5 {
-		return "", "", metadata, errors.New("Could not format ipmitool data")
+	if len(samples) < 5 {
+		return nil, errors.New("Could not format ipmitool data")
 	}
 
-	return strings.Join(headers, " "), strings.Join(metrics, " "), metadata, nil
+	return samples, nil
 }
 
 // IpmiSensorRun returns sensor Metric results
-func IpmiSensorRun() ([]*collectors.MetricResult, error) {
+func IpmiSensorRun(ctx context.Context) ([]*collectors.MetricResult, error) {
 
-	ipmitool, err := exec.LookPath("ipmitool")
+	ipmitool, err := collectors.LookPath(ctx, "ipmitool")
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := exec.Command(ipmitool, "sdr", "elist").Output()
+	output, err := collectors.Output(ctx, ipmitool, "sdr", "elist")
 	if err != nil {
 		out := ""
 		if output != nil {
@@ -103,11 +98,10 @@ func IpmiSensorRun() ([]*collectors.MetricResult, error) {
 	}
 	rawData := string(output)
 
-	header, metric, metadata, err := formatIpmiSensor(rawData)
+	samples, err := formatIpmiSensor(rawData)
 	if err != nil {
 		return nil, err
 	}
 
-	result := collectors.BuildMetricResult(header, metric, "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	return []*collectors.MetricResult{{Samples: samples}}, nil
 }
@@ -1,16 +1,18 @@
 package smart
 
 import (
+	"context"
+
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
 // Run returns smart metrics
-func Run() ([]*collectors.MetricResult, error) {
+func Run(ctx context.Context) ([]*collectors.MetricResult, error) {
 
 	data, err := loader()
 	if err != nil {
 		return nil, err
 	}
 
-	return preformatter(data)
+	return preformatter(ctx, data)
 }
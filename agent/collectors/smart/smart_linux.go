@@ -1,14 +1,15 @@
 package smart
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/types"
@@ -22,20 +23,18 @@ func loader() ([]byte, error) {
 }
 
 // Precheck validates for presence of dependency smartctl
-func Precheck() error {
-	if _, err := exec.LookPath("smartctl"); err != nil {
+func Precheck(ctx context.Context) error {
+	if _, err := collectors.LookPath(ctx, "smartctl"); err != nil {
 		return err
 	}
 	//all errors returned by SMARTRun are fatal for the precheck. So rather than duplicate code here, just run it once and check
-	_, err := preformatter(nil)
+	_, err := preformatter(ctx, nil)
 	return err
 }
 
 //Process only sata type drive
-func formatSmartSATA(data string, disk types.Disk) ([]string, []string, map[string]string) {
-	var headers = make([]string, 0)
-	var metrics = make([]string, 0)
-	var metadata = make(map[string]string)
+func formatSmartSATA(data string, disk types.Disk) []collectors.Sample {
+	var samples = make([]collectors.Sample, 0)
 	//parse the smartctl output, get the raw and normalized values of all smart stats
 	smartLines := strings.Split(data, "\n")
 	metricsMap := make(map[string]smartStat)
@@ -45,7 +44,7 @@ func formatSmartSATA(data string, disk types.Disk) ([]string, []string, map[stri
 		if !inSMARTDataSection {
 			if strings.HasPrefix(smartLine, "SMART Disabled") {
 				log.Infof("Smart disabled for disk %s [%s]", disk.Path, disk.Type)
-				return nil, nil, metadata
+				return nil
 			}
 			if smartLine == smartDataBegin {
 				inSMARTDataSection = true
@@ -64,7 +63,7 @@ func formatSmartSATA(data string, disk types.Disk) ([]string, []string, map[stri
 			break
 		}
 		if isDataTable && len(parts) >= 10 {
-			metricsMap[parts[0]] = smartStat{normalized: parts[3], name: parts[1], raw: strings.Join(parts[9:], "_")}
+			metricsMap[parts[0]] = smartStat{normalized: parts[3], name: parts[1], raw: strings.Join(parts[9:], " ")}
 		}
 	}
 	var diskID = gentrateDiskID(disk)
@@ -72,7 +71,7 @@ func formatSmartSATA(data string, disk types.Disk) ([]string, []string, map[stri
 	//if no smart stats were available for this drive, skip it
 	if len(metricsMap) == 0 {
 		log.Infof("No SMART stats available for drive: %s [%s]", disk.Path, disk.Type)
-		return nil, nil, metadata
+		return nil
 	}
 
 	//order columns by metric number
@@ -90,15 +89,18 @@ func formatSmartSATA(data string, disk types.Disk) ([]string, []string, map[stri
 	sort.Ints(smartKeys)
 	for _, smartKey := range smartKeys {
 		var smartKeyStr = strconv.Itoa(smartKey)
-		headers = append(headers, diskID+".smart"+smartKeyStr+"normalized")
-		headers = append(headers, diskID+".smart"+smartKeyStr+"raw")
-		metrics = append(metrics, metricsMap[smartKeyStr].normalized)
-		metrics = append(metrics, metricsMap[smartKeyStr].raw)
-		metadata[diskID+".smart"+smartKeyStr+"raw"] = "string " + metricsMap[smartKeyStr].name
-		metadata[diskID+".smart"+smartKeyStr+"normalized"] = "int " + metricsMap[smartKeyStr].name
-	}
-
-	return headers, metrics, metadata
+		labels := []collectors.Label{{Name: "disk", Value: diskID}, {Name: "attribute", Value: smartKeyStr}}
+		normalized := collectors.NewSample("normalized", metricsMap[smartKeyStr].normalized, collectors.IntValue, labels...)
+		normalized.Column = diskID + ".smart" + smartKeyStr + "normalized"
+		normalized.Help = metricsMap[smartKeyStr].name
+		// raw values are vendor specific and may carry text, e.g. "35 (Min/Max 20/45)"
+		raw := collectors.NewSample("raw", metricsMap[smartKeyStr].raw, collectors.StringValue, labels...)
+		raw.Column = diskID + ".smart" + smartKeyStr + "raw"
+		raw.Help = metricsMap[smartKeyStr].name
+		samples = append(samples, normalized, raw)
+	}
+
+	return samples
 }
 
 func gentrateDiskID(disk types.Disk) string {
@@ -113,34 +115,32 @@ func gentrateDiskID(disk types.Disk) string {
 }
 
 //Process only sas type drive
-func formatSmartSAS(data string, disk types.Disk) ([]string, []string, map[string]string) {
-	headerMap := map[string]string{
-		"PowerUpHrs":         "float Accumulated power on time",
-		"Temperature":        "string Current Drive Temp",
-		"StartStopCycles":    "int Accumulated start-stop cycles",
-		"LoadUnloadCycles":   "int Accumulated load-unload cycles",
-		"ReadCEFast":         "int Read: Errors corrected by ECC, fast",
-		"ReadCEDelayed":      "int Read: Errors corrected by ECC, delayed",
-		"ReadCERereads":      "int Read: Errors corrected by rereads",
-		"ReadTotalCE":        "int Read: Total Errors corrected",
-		"ReadCAInvocations":  "int Read: Correction algorithm invocations",
-		"ReadProcessedGb":    "int Read: Gigabytes processed [10^9 bytes]",
-		"ReadTotalUE":        "int Read: Total Uncorrected Errors",
-		"WriteCEFast":        "int Write: Errors corrected by ECC, fast",
-		"WriteCEDelayed":     "int Write: Errors corrected by ECC, delayed",
-		"WriteCERereads":     "int Write: Errors corrected by rereads",
-		"WriteTotalCE":       "int Write: Total Errors corrected",
-		"WriteCAInvocations": "int Write: Correction algorithm invocations",
-		"WriteProcessedGb":   "int Write: Gigabytes processed [10^9 bytes]",
-		"WriteTotalUE":       "int Write: Total Uncorrected Errors",
-	}
-	var headers = make([]string, 0)
-	var metrics = make([]string, 0)
-	var metadata = make(map[string]string)
+func formatSmartSAS(data string, disk types.Disk) []collectors.Sample {
+	headerMap := map[string]sasAttribute{
+		"PowerUpHrs":         {collectors.FloatValue, "hours", "Accumulated power on time"},
+		"Temperature":        {collectors.StringValue, "", "Current Drive Temp"},
+		"StartStopCycles":    {collectors.IntValue, "", "Accumulated start-stop cycles"},
+		"LoadUnloadCycles":   {collectors.IntValue, "", "Accumulated load-unload cycles"},
+		"ReadCEFast":         {collectors.IntValue, "", "Read: Errors corrected by ECC, fast"},
+		"ReadCEDelayed":      {collectors.IntValue, "", "Read: Errors corrected by ECC, delayed"},
+		"ReadCERereads":      {collectors.IntValue, "", "Read: Errors corrected by rereads"},
+		"ReadTotalCE":        {collectors.IntValue, "", "Read: Total Errors corrected"},
+		"ReadCAInvocations":  {collectors.IntValue, "", "Read: Correction algorithm invocations"},
+		"ReadProcessedGb":    {collectors.IntValue, "GB", "Read: Gigabytes processed [10^9 bytes]"},
+		"ReadTotalUE":        {collectors.IntValue, "", "Read: Total Uncorrected Errors"},
+		"WriteCEFast":        {collectors.IntValue, "", "Write: Errors corrected by ECC, fast"},
+		"WriteCEDelayed":     {collectors.IntValue, "", "Write: Errors corrected by ECC, delayed"},
+		"WriteCERereads":     {collectors.IntValue, "", "Write: Errors corrected by rereads"},
+		"WriteTotalCE":       {collectors.IntValue, "", "Write: Total Errors corrected"},
+		"WriteCAInvocations": {collectors.IntValue, "", "Write: Correction algorithm invocations"},
+		"WriteProcessedGb":   {collectors.IntValue, "GB", "Write: Gigabytes processed [10^9 bytes]"},
+		"WriteTotalUE":       {collectors.IntValue, "", "Write: Total Uncorrected Errors"},
+	}
+	var samples = make([]collectors.Sample, 0)
 	metricsMap := make(map[string]string)
 	dataSection := strings.Split(data, smartDataBegin)
 	if len(dataSection) < 2 {
-		return headers, metrics, metadata
+		return samples
 	}
 	section := strings.Split(dataSection[1], "Error counter log")
 
@@ -156,13 +156,19 @@ func formatSmartSAS(data string, disk types.Disk) ([]string, []string, map[strin
 	}
 	sort.Strings(smartKeys)
 	for _, smartKey := range smartKeys {
-		headers = append(headers, diskID+"."+smartKey)
-		metrics = append(metrics, metricsMap[smartKey])
-		if meta, ok := headerMap[smartKey]; ok {
-			metadata[diskID+"."+smartKey] = meta
+		attr, ok := headerMap[smartKey]
+		if !ok {
+			attr.typ = collectors.StringValue
+		}
+		s := collectors.NewSample(smartKey, metricsMap[smartKey], attr.typ, collectors.Label{Name: "disk", Value: diskID})
+		if s.Type != collectors.StringValue {
+			s.Kind = collectors.Counter
 		}
+		s.Unit = attr.unit
+		s.Help = attr.help
+		samples = append(samples, s)
 	}
-	return headers, metrics, metadata
+	return samples
 }
 
 func processSASmartData(data string, metricsMap map[string]string) {
@@ -260,34 +266,29 @@ func isSAS(data string) bool {
 	return false
 }
 
-func preformatter(d []byte) ([]*collectors.MetricResult, error) {
+func preformatter(ctx context.Context, d []byte) ([]*collectors.MetricResult, error) {
 
 	unknowCount := 0
-	sasResult := &collectors.MetricResult{
-		Sufix:    "-sas",
-		Metadata: map[string]string{},
-	}
-	ataResult := &collectors.MetricResult{
-		Sufix:    "-ata",
-		Metadata: map[string]string{},
-	}
-	smartctlPath, err := exec.LookPath("smartctl")
+	sasResult := &collectors.MetricResult{Sufix: "-sas"}
+	ataResult := &collectors.MetricResult{Sufix: "-ata"}
+	smartctlPath, err := collectors.LookPath(ctx, "smartctl")
 	if err != nil {
 		return nil, err
 	}
 
-	disks, err := getDisks(smartctlPath)
+	disks, err := getDisks(ctx, smartctlPath)
 	if err != nil {
 		return nil, err
 	}
 
-	HPSmartArray := isHPSmartArray()
+	HPSmartArray := isHPSmartArray(ctx)
 
 	processHPSmartArray := func(disk types.Disk) {
 		for i := 0; true; i++ {
 			out := ""
 			insideDisk := types.Disk{Path: disk.Path, Name: disk.Name, Type: "cciss," + strconv.Itoa(i)}
-			sdata, _ := exec.Command(smartctlPath, "-d", insideDisk.Type, "-Aa", insideDisk.Path).Output()
+			sdata, _ := collectors.Output(ctx, smartctlPath, "-d", insideDisk.Type, "-Aa", insideDisk.Path)
+			readAt := collectors.Now(ctx)
 
 			if sdata == nil {
 				break
@@ -301,23 +302,15 @@ func preformatter(d []byte) ([]*collectors.MetricResult, error) {
 			_, unknowCount = getDiskSerial(string(sdata), unknowCount)
 
 			if isSAS(out) {
-				diskHeaders, diskMetrics, diskMetadata := formatSmartSAS(out, insideDisk)
-				if len(diskHeaders) > 0 && len(diskMetrics) > 0 {
-					sasResult.Header += " " + strings.Join(diskHeaders, " ")
-					sasResult.Data += " " + strings.Join(diskMetrics, " ")
-					for k, v := range diskMetadata {
-						sasResult.Metadata[k] = v
-					}
+				diskSamples := formatSmartSAS(out, insideDisk)
+				if len(diskSamples) > 0 {
+					sasResult.Samples = append(sasResult.Samples, stamp(diskSamples, readAt)...)
 					continue
 				}
 			} else {
-				diskHeaders, diskMetrics, diskMetadata := formatSmartSATA(out, insideDisk)
-				if len(diskHeaders) > 0 && len(diskMetrics) > 0 {
-					ataResult.Header += " " + strings.Join(diskHeaders, " ")
-					ataResult.Data += " " + strings.Join(diskMetrics, " ")
-					for k, v := range diskMetadata {
-						ataResult.Metadata[k] = v
-					}
+				diskSamples := formatSmartSATA(out, insideDisk)
+				if len(diskSamples) > 0 {
+					ataResult.Samples = append(ataResult.Samples, stamp(diskSamples, readAt)...)
 					continue
 				}
 			}
@@ -328,7 +321,8 @@ func preformatter(d []byte) ([]*collectors.MetricResult, error) {
 	}
 	// For each sd disk, collect its information:
 	for _, disk := range disks {
-		smartData, err := exec.Command(smartctlPath, "-d", disk.Type, "-Aa", disk.Path).Output()
+		smartData, err := collectors.Output(ctx, smartctlPath, "-d", disk.Type, "-Aa", disk.Path)
+		readAt := collectors.Now(ctx)
 		if err != nil {
 			out := ""
 			if smartData != nil {
@@ -346,31 +340,23 @@ func preformatter(d []byte) ([]*collectors.MetricResult, error) {
 
 		_, unknowCount = getDiskSerial(data, unknowCount)
 		if isSAS(data) {
-			diskHeaders, diskMetrics, diskMetadata := formatSmartSAS(data, disk)
-			if len(diskHeaders) > 0 && len(diskMetrics) > 0 {
-				sasResult.Header += " " + strings.Join(diskHeaders, " ")
-				sasResult.Data += " " + strings.Join(diskMetrics, " ")
-				for k, v := range diskMetadata {
-					sasResult.Metadata[k] = v
-				}
+			diskSamples := formatSmartSAS(data, disk)
+			if len(diskSamples) > 0 {
+				sasResult.Samples = append(sasResult.Samples, stamp(diskSamples, readAt)...)
 			}
 		} else {
-			diskHeaders, diskMetrics, diskMetadata := formatSmartSATA(data, disk)
-			if len(diskHeaders) > 0 && len(diskMetrics) > 0 {
-				ataResult.Header += " " + strings.Join(diskHeaders, " ")
-				ataResult.Data += " " + strings.Join(diskMetrics, " ")
-				for k, v := range diskMetadata {
-					ataResult.Metadata[k] = v
-				}
+			diskSamples := formatSmartSATA(data, disk)
+			if len(diskSamples) > 0 {
+				ataResult.Samples = append(ataResult.Samples, stamp(diskSamples, readAt)...)
 			}
 		}
 	}
 
 	metricResult := []*collectors.MetricResult{}
-	if len(sasResult.Header) > 1 {
+	if len(sasResult.Samples) > 0 {
 		metricResult = append(metricResult, sasResult)
 	}
-	if len(ataResult.Header) > 1 {
+	if len(ataResult.Samples) > 0 {
 		metricResult = append(metricResult, ataResult)
 	}
 
@@ -380,14 +366,22 @@ func preformatter(d []byte) ([]*collectors.MetricResult, error) {
 	return metricResult, nil
 }
 
+// stamp sets the time the samples of a disk were read
+func stamp(samples []collectors.Sample, t time.Time) []collectors.Sample {
+	for i := range samples {
+		samples[i].Timestamp = t
+	}
+	return samples
+}
+
 //Check is HP Smart array
-func isHPSmartArray() bool {
-	lspci, err := exec.LookPath("lspci")
+func isHPSmartArray(ctx context.Context) bool {
+	lspci, err := collectors.LookPath(ctx, "lspci")
 	if err != nil {
 		return false
 	}
 
-	lspciData, _ := exec.Command(lspci).Output()
+	lspciData, _ := collectors.Output(ctx, lspci)
 	if lspciData != nil {
 		out := string(lspciData)
 		return strings.Contains(out, "Hewlett-Packard Company Smart Array")
@@ -433,9 +427,9 @@ func getSecondPart(line, key, delimeter string) string {
 
 // getDisks returns list of disks on the machine using smartctl --scan-open
 // note that this only checks block drives /dev/sd[a-z], /dev/sd[a-c][a-z], /dev/hd[a-z], and /dev/discs/disc*
-func getDisks(smartctlPath string) ([]types.Disk, error) {
+func getDisks(ctx context.Context, smartctlPath string) ([]types.Disk, error) {
 	// Get a list of sd disks:
-	dev, devErr := exec.Command(smartctlPath, "--scan-open").Output()
+	dev, devErr := collectors.Output(ctx, smartctlPath, "--scan-open")
 	if devErr != nil {
 		devOut := "command did not generate any output"
 		if dev != nil {
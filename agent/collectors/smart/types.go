@@ -1,7 +1,15 @@
 package smart
 
+import "github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+
 type smartStat struct {
 	normalized string
 	raw        string
 	name       string
 }
+
+type sasAttribute struct {
+	typ  collectors.ValueType
+	unit string
+	help string
+}
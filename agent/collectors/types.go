@@ -1,13 +1,18 @@
 package collectors
 
-// CollectorRunner returns inventory by calling run function of invetory collector
-type CollectorRunner func() ([]byte, error)
+import "context"
 
-// MetricRunner returns metric by calling run function of metric collector
-type MetricRunner func() ([]*MetricResult, error)
+// CollectorRunner returns inventory by calling run function of invetory collector. Commands it runs
+// are killed when ctx is done
+type CollectorRunner func(ctx context.Context) ([]byte, error)
 
-// CollectorPrecheck is precheck function for metric or inventory collectors
-type CollectorPrecheck func() error
+// MetricRunner returns metric by calling run function of metric collector. Commands it runs are
+// killed when ctx is done
+type MetricRunner func(ctx context.Context) ([]*MetricResult, error)
+
+// CollectorPrecheck is precheck function for metric or inventory collectors. Commands it runs are
+// killed when ctx is done
+type CollectorPrecheck func(ctx context.Context) error
 
 // CollectorFnWrapper is wrapper struct for inventory collectors
 type CollectorFnWrapper struct {
@@ -21,14 +26,13 @@ type CollectorFnWrapper struct {
 type MetricFnWrapper struct {
 	RunFn        MetricRunner
 	PrecheckFn   CollectorPrecheck
+	DeriveFn     MetricDeriver
 	Dependencies []string
 	Type         string
 }
 
-// MetricResult represents a metric (example cpu)
+// MetricResult represents a group of samples of a metric (example cpu)
 type MetricResult struct {
-	Header   string
-	Data     string
-	Sufix    string
-	Metadata map[string]string
+	Sufix   string
+	Samples []Sample
 }
@@ -1,7 +1,7 @@
 package uptime
 
 import (
-	"io/ioutil"
+	"fmt"
 	"strings"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
@@ -13,15 +13,21 @@ const (
 )
 
 func loader() ([]byte, error) {
-	return ioutil.ReadFile("/proc/uptime")
+	return collectors.ReadFile(collectors.HostPath("/proc/uptime"))
 }
 
 func preformatter(data []byte) ([]*collectors.MetricResult, error) {
-	metadata := map[string]string{
-		uptime: "float The total number of seconds the system has been up",
-		idle:   "float  Column is how much of that time the machine has spent idle, in seconds.",
+	parts := strings.Fields(string(data))
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unexpected content of /proc/uptime: %q", string(data))
 	}
-
-	result := collectors.BuildMetricResult(uptime+" "+idle, strings.TrimSpace(string(data)), "", metadata)
-	return []*collectors.MetricResult{result}, nil
+	up := collectors.NewSample(uptime, parts[0], collectors.FloatValue)
+	up.Kind = collectors.Counter
+	up.Unit = "seconds"
+	up.Help = "The total number of seconds the system has been up"
+	idl := collectors.NewSample(idle, parts[1], collectors.FloatValue)
+	idl.Kind = collectors.Counter
+	idl.Unit = "seconds"
+	idl.Help = "Column is how much of that time the machine has spent idle, in seconds."
+	return []*collectors.MetricResult{{Samples: []collectors.Sample{up, idl}}}, nil
 }
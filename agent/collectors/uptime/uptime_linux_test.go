@@ -0,0 +1,43 @@
+package uptime
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// hosts has /proc and /sys trees captured on hosts with different kernels
+const hosts = "../testdata/hosts"
+
+func TestLoaderPreformatter(t *testing.T) {
+	defer collectors.SetHostRoot("")
+	for _, tc := range []struct {
+		host     string
+		up, idle float64
+	}{
+		{"linux-3.10", 12345.67, 23456.78},
+		{"linux-4.19", 864000.12, 3300000.50},
+		{"linux-5.15", 3600, 27000},
+		{"linux-6.1", 98765.43, 190000},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			collectors.SetHostRoot(filepath.Join(hosts, tc.host))
+			data, err := loader()
+			if err != nil {
+				t.Fatalf("loader() error: %v", err)
+			}
+			res, err := preformatter(data)
+			if err != nil {
+				t.Fatalf("preformatter() error: %v", err)
+			}
+			index := collectors.IndexSamples(res[0].Samples)
+			if s := index[uptime]; s == nil || s.Value != tc.up || s.Unit != "seconds" {
+				t.Errorf("%s is %+v, want %v seconds", uptime, s, tc.up)
+			}
+			if s := index[idle]; s == nil || s.Value != tc.idle {
+				t.Errorf("%s is %+v, want %v", idle, s, tc.idle)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package agent
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -9,19 +9,18 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
 const (
-	syslogSeverityAlert  = 1
-	syslogSeverityNotice = 5
-	syslogFacilityUser   = 1
+	syslogSeverityAlert   = 1
+	syslogSeverityWarning = 4
+	syslogSeverityNotice  = 5
+	syslogFacilityUser    = 1
 )
 
 //processCommands returns true if all commands vere successfully executed
@@ -55,8 +54,15 @@ func (a *Agent) processCommands(in []byte) (allSuccess bool) {
 			// send syslog that command has been recieved
 			a.sendCmdStatusSyslog(cmd, "received")
 
-			// execute the command
+			// execute the command, shutdown waits for it and it is killed when the agent stops
+			if !a.startCommand() {
+				log.Errorf("agent is stopping, command not executed: %v", cmd)
+				a.sendCmdStatusSyslog(cmd, "skipped")
+				allSuccess = false
+				continue
+			}
 			go func(cmd command) {
+				defer a.commands.Done()
 				if cmdOut, err := a.execCommand(cmd); err != nil {
 					log.Errorf("error during execution of [%+v]: %v", cmd, err)
 					allSuccess = false
@@ -81,6 +87,12 @@ func (a *Agent) processCommands(in []byte) (allSuccess bool) {
 			}(cmd)
 
 		default:
+			if handler, ok := controlCommands[cmd.Name]; ok {
+				if !a.runControlCommand(cmd, handler) {
+					allSuccess = false
+				}
+				continue
+			}
 			errStr := fmt.Sprintf("unrecognized command [%s]", cmd.Name)
 			log.Error(errStr)
 			allSuccess = false
@@ -92,6 +104,17 @@ func (a *Agent) processCommands(in []byte) (allSuccess bool) {
 	return
 }
 
+// startCommand adds a command to those Stop waits for, it returns false if the agent is stopping
+func (a *Agent) startCommand() bool {
+	a.commandsMtx.Lock()
+	defer a.commandsMtx.Unlock()
+	if a.ctx.Err() != nil {
+		return false
+	}
+	a.commands.Add(1)
+	return true
+}
+
 func (a *Agent) sendCmdStatusSyslog(cmd command, status string) {
 	severity := syslogSeverityNotice
 	if status == "error" {
@@ -107,11 +130,11 @@ func (a *Agent) sendCmdStatusSyslog(cmd command, status string) {
 		Message:   a.cmdResponse(cmd.Name, cmd.CmdID, status),
 	}
 
-	a.NonBlockingSend(s.formatBytes())
+	a.NonBlockingSend(classSyslog, s.formatBytes())
 }
 
 func (a *Agent) sendCmdOutputBlob(cmdOut commandOutput) {
-	cmdBlob := Blob{Type: "execCommand", NodeID: a.Config.NodeID}
+	cmdBlob := Blob{Type: "execCommand", NodeID: a.config().NodeID}
 	jsonOut, err := json.Marshal(cmdOut)
 	if err != nil {
 		log.Errorf("Error marshalling JSON object: %v", cmdOut)
@@ -122,23 +145,21 @@ func (a *Agent) sendCmdOutputBlob(cmdOut commandOutput) {
 	cmdBlob.Timestamp = fmt.Sprintf("%d", time.Now().Unix())
 	cmdBlob.Digest = fmt.Sprintf("%x", h.Sum(nil))
 	cmdBlob.Content = jsonOut
-	a.NonBlockingSend(cmdBlob.Format())
+	a.NonBlockingSend(classCommand, cmdBlob.Format())
 }
 
 func (a *Agent) cmdResponse(cmdName, cmdID, status string) string {
-	return fmt.Sprintf("%s %s %s %s", cmdName, a.Config.NodeID, cmdID, status)
+	return fmt.Sprintf("%s %s %s %s", cmdName, a.config().NodeID, cmdID, status)
 }
 
 func (a *Agent) execCommand(cmd command) (commandOutput, error) {
 	cmdOut := commandOutput{
-		NodeID:  a.Config.NodeID,
+		NodeID:  a.config().NodeID,
 		CmdID:   cmd.CmdID,
 		FileURL: cmd.FileURL,
 		RunCmd:  cmd.RunCmd,
 		RunArgs: cmd.RunArgs,
 	}
-	cmdStdout := &bytes.Buffer{}
-	cmdStderr := &bytes.Buffer{}
 	var status string
 
 	// Download file
@@ -155,6 +176,7 @@ func (a *Agent) execCommand(cmd command) (commandOutput, error) {
 	if err != nil {
 		return cmdOut, fmt.Errorf("error generating temporary directory: %s", err)
 	}
+	defer os.RemoveAll(tmpDir)
 	filename = filepath.Join(tmpDir, filename)
 
 	file, err := os.Create(filename)
@@ -162,10 +184,20 @@ func (a *Agent) execCommand(cmd command) (commandOutput, error) {
 		return cmdOut, fmt.Errorf("error creating local file: %s", err)
 	}
 
-	if err := httpGetFile(cmd.FileURL, file); err != nil {
+	if err := httpGetFile(a.ctx, cmd.FileURL, file); err != nil {
 		return cmdOut, fmt.Errorf("error downloading remote file: %s", err)
 	}
 
+	// Nothing is extracted or run unless the file is signed by a trusted key
+	if err := verifyPayload(a.getTrustedKeys(), a.config().NodeID, file, cmd); err != nil {
+		file.Close()
+		return cmdOut, fmt.Errorf("refusing to run unverified file: %s", err)
+	}
+	if !a.claimCmdID(cmd.CmdID) {
+		file.Close()
+		return cmdOut, fmt.Errorf("refusing to run command %s again", cmd.CmdID)
+	}
+
 	// Execute command
 	status = "executing"
 	a.sendCmdStatusSyslog(cmd, status)
@@ -194,19 +226,24 @@ func (a *Agent) execCommand(cmd command) (commandOutput, error) {
 	// Close before running:
 	file.Close()
 
-	execCmd := exec.Command(runFile, cmd.RunArgs...)
-	execCmd.Stdout = cmdStdout
-	execCmd.Stderr = cmdStderr
-	err = execCmd.Run()
-	cmdOut.Stderr = cmdStderr.String()
-	cmdOut.Stdout = cmdStdout.String()
+	// The command runs as the user of the execution policy, which must be able to read its files
+	if err := a.getExecPolicy().chown(tmpDir); err != nil {
+		return cmdOut, fmt.Errorf("error changing owner of files: %s", err)
+	}
+	ctx := a.ctx
+	if timeout := a.config().ExecTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	res, err := a.runSandboxed(ctx, tmpDir, runFile, cmd.RunArgs...)
+	if res != nil {
+		cmdOut.Stderr = string(res.Stderr)
+		cmdOut.Stdout = string(res.Stdout)
+	}
 	if err != nil {
-		// Check if error was caused by exit code
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			// Try to grab the exit code
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				return cmdOut, fmt.Errorf("error executing command: %s, exit code: %d", err, status)
-			}
+		if res != nil && res.ExitCode > 0 {
+			return cmdOut, fmt.Errorf("error executing command: %s, exit code: %d", err, res.ExitCode)
 		}
 		return cmdOut, fmt.Errorf("error executing command: %s", err)
 	}
@@ -216,20 +253,27 @@ func (a *Agent) execCommand(cmd command) (commandOutput, error) {
 }
 
 // httpGetFile grabs a file from a url and writes it to a provided io.Writer
-func httpGetFile(url string, file io.Writer) error {
-	resp, err := http.Get(url)
+func httpGetFile(ctx context.Context, url string, file io.Writer) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("error fetching file: %s", resp.Status)
 	}
-	defer resp.Body.Close()
 
-	n, err := io.Copy(file, resp.Body)
+	n, err := io.Copy(file, io.LimitReader(resp.Body, execMaxFileSize+1))
 	if err != nil {
 		return err
 	}
+	if n > execMaxFileSize {
+		return fmt.Errorf("file is larger than %d bytes", execMaxFileSize)
+	}
 
 	log.Infof("received %d bytes from %s", n, url)
 	return nil
@@ -1,42 +1,103 @@
 package agent
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+	"gopkg.in/yaml.v2"
 )
 
 // NewDefaultConfig sets the default flags for the Agent so we can support passing no flags from the command line
 func NewDefaultConfig() *Config {
 	return &Config{
-		Chdir:            ".",
-		CollectorTimeout: collectorTimeout,
-		WaitTime:         10,
+		AdminSocket:       adminSocket,
+		Chdir:             ".",
+		CollectorTimeout:  collectorTimeout,
+		CounterMode:       counterModeRaw,
+		ExecTimeout:       execTimeout,
+		FileMaxAge:        fileMaxAge,
+		FileMaxSize:       fileMaxSize,
+		HTTPBatchSize:     httpBatchSize,
+		HTTPFlushInterval: httpFlushInterval,
+		HTTPRetries:       httpRetries,
+		SpoolSize:         spoolSize,
+		WaitTime:          10,
 	}
 }
 
 // InitFlags binds set of flags to variables in Agent config
 func InitFlags(c *Config) {
-	flag.BoolVar(&c.Stdout, "stdout", c.Stdout, "send to STDOUT")
-	flag.StringVar(&c.Chdir, "chdir", c.Chdir, "change the working directory")
-	flag.StringVar(&c.SkipStr, "skip", c.SkipStr, "disable preset collectors. i.e: \"-skip=cpu,disk\"")
-	flag.IntVar(&c.Freq, "frequency", c.Freq, "collection frequency in seconds. set to >0 to repeat")
-	flag.IntVar(&c.CollectorTimeout, "collection-timeout", c.CollectorTimeout, "specify collection timeout in seconds")
-	flag.StringVar(&c.Destination, "destination", c.Destination, "send data to server. i.e: \"-destination=tcp:localhost:12345\"")
-	flag.BoolVar(&c.DryRun, "dry-run", c.DryRun, "validate environment setting to run collections")
-	flag.IntVar(&c.WaitTime, "retrywait", c.WaitTime, "wait time in seconds before reconnect to destination")
-	flag.IntVar(&c.Duration, "duration", c.Duration, "number of seconds to run the agent for. 0 for non-stop")
+	bindFlags(flag.CommandLine, c)
+	flag.Usage = printUsage
+}
+
+// bindFlags binds set of flags in fs to variables in config c
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "read settings from JSON or YAML file, flags override its values. file is re-read on SIGHUP")
+	fs.BoolVar(&c.Stdout, "stdout", c.Stdout, "send to STDOUT")
+	fs.StringVar(&c.Chdir, "chdir", c.Chdir, "change the working directory")
+	fs.StringVar(&c.AdminSocket, "admin-socket", c.AdminSocket, "unix socket, relative to -chdir, the admin API is served at. empty disables it")
+	fs.StringVar(&c.HostRoot, "host-root", c.HostRoot, "directory the host's /proc, /sys and other files are read under, e.g. when running in a container with the host's root mounted there. default is $"+collectors.HostRootEnv+" or /")
+	fs.StringVar(&c.SkipStr, "skip", c.SkipStr, "disable preset collectors. i.e: \"-skip=cpu,disk\"")
+	fs.IntVar(&c.Freq, "frequency", c.Freq, "collection frequency in seconds. set to >0 to repeat")
+	fs.IntVar(&c.CollectorTimeout, "collection-timeout", c.CollectorTimeout, "specify collection timeout in seconds")
+	fs.StringVar(&c.CollectorFreqStr, "collector-frequency", c.CollectorFreqStr, "collection frequency in seconds of single collectors, names may be glob patterns. i.e: \"-collector-frequency=cpu=5,smart=3600,sysinfo.package.*=86400\"")
+	fs.StringVar(&c.CollectorTimeoutStr, "collector-timeout", c.CollectorTimeoutStr, "collection timeout in seconds of single collectors, names may be glob patterns. i.e: \"-collector-timeout=smart=120\"")
+	fs.StringVar(&c.CounterMode, "counter-mode", c.CounterMode, "send cumulative counters of cpu, net and disk as raw values, as per interval rates and percentages derived from them, or both. i.e: \"-counter-mode=raw|rate|both\"")
+	fs.StringVar(&c.Destination, "destination", c.Destination, "send data to servers or files, comma separated, each optionally followed by ;class+class to send only inventory, metric, syslog or command data. i.e: \"-destination=tcp:localhost:12345\", \"-destination=tls:localhost:12345\", \"-destination=https://localhost/ingest,tcp:dr:9090;metric+inventory\" or \"-destination=file:/var/lib/hds/data.log\"")
+	fs.StringVar(&c.ExecUser, "exec-user", c.ExecUser, "run ExecCommand files and user scripts as user[:group], names or ids. default is the agent's user")
+	fs.IntVar(&c.ExecTimeout, "exec-timeout", c.ExecTimeout, "number of seconds before a command run by ExecCommand is killed. 0 for no timeout")
+	fs.IntVar(&c.ExecCPULimit, "exec-cpu-limit", c.ExecCPULimit, "max seconds of CPU time of ExecCommand files and user scripts. 0 for no limit")
+	fs.IntVar(&c.ExecMemoryLimit, "exec-memory-limit", c.ExecMemoryLimit, "max megabytes of address space of ExecCommand files and user scripts. 0 for no limit")
+	fs.IntVar(&c.ExecNofileLimit, "exec-nofile-limit", c.ExecNofileLimit, "max number of open files of ExecCommand files and user scripts. 0 for no limit")
+	fs.IntVar(&c.ExecOutputLimit, "exec-output-limit", c.ExecOutputLimit, "max megabytes of output, and of each file written, of ExecCommand files and user scripts. 0 for no limit")
+	fs.StringVar(&c.ExecCgroup, "exec-cgroup", c.ExecCgroup, "cgroup v2 directory ExecCommand files and user scripts are started in. i.e: \"-exec-cgroup=/sys/fs/cgroup/hds-agent\"")
+	fs.IntVar(&c.FileMaxSize, "file-max-size", c.FileMaxSize, "size in megabytes at which the file of file destination is rotated. 0 for no limit")
+	fs.IntVar(&c.FileMaxAge, "file-max-age", c.FileMaxAge, "number of seconds after which the file of file destination is rotated. 0 for no limit")
+	fs.BoolVar(&c.FileCompress, "file-compress", c.FileCompress, "compress rotated files of file destination with gzip")
+	fs.IntVar(&c.FileKeep, "file-keep", c.FileKeep, "number of rotated files of file destination kept, the oldest are removed. 0 keeps all")
+	fs.IntVar(&c.HTTPBatchSize, "http-batch-size", c.HTTPBatchSize, "max number of messages sent in one POST to http destination")
+	fs.IntVar(&c.HTTPFlushInterval, "http-flush-interval", c.HTTPFlushInterval, "max number of seconds data waits before it is sent to http destination")
+	fs.IntVar(&c.HTTPRetries, "http-retries", c.HTTPRetries, "number of retries with backoff of a POST failed with 5xx or 429")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "serve latest metrics at /metrics in Prometheus text or OpenMetrics format. i.e: \"-listen=:9100\"")
+	fs.StringVar(&c.TLSCA, "tls-ca", c.TLSCA, "CA bundle to verify tls destination, system roots are used if not set")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "client certificate for mutual TLS with tls destination")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "private key of the client certificate")
+	fs.StringVar(&c.TLSServerName, "tls-server-name", c.TLSServerName, "server name to verify tls destination certificate against. default is destination host")
+	fs.StringVar(&c.TLSPin, "tls-pin", c.TLSPin, "comma-separated hex SHA-256 fingerprints of pinned server public keys")
+	fs.StringVar(&c.TrustedKeys, "trusted-keys", c.TrustedKeys, "comma-separated PEM files of public keys trusted to sign files run by ExecCommand. commands are refused if not set")
+	fs.IntVar(&c.SpoolSize, "spool-size", c.SpoolSize, "max size in megabytes of on-disk spool under -chdir for data not delivered to destination. 0 disables spool")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "run every collector once, print a JSON report of results and projected data volume, and exit. exit status is 1 if a collector failed, 2 if config is invalid, 3 if collectors are unavailable")
+	fs.StringVar(&c.Record, "record", c.Record, "record the inputs of the collectors, files read and command outputs, with timestamps into directory, to run the agent on them with -replay")
+	fs.StringVar(&c.Replay, "replay", c.Replay, "run the agent on the inputs recorded with -record in directory instead of on the host, send the data like the recorded agent did to -stdout or -destination, and exit")
+	fs.IntVar(&c.WaitTime, "retrywait", c.WaitTime, "wait time in seconds before reconnect to destination")
+	fs.IntVar(&c.Duration, "duration", c.Duration, "number of seconds to run the agent for. 0 for non-stop")
 }
 
 // CheckErrs validates values of Config fields
 func (c *Config) CheckErrs() error {
+	if err := c.checkSettings(); err != nil {
+		return err
+	}
+	if c.Stdout == false && c.Destination == "" && c.Listen == "" {
+		return fmt.Errorf("provide at least one valid output flag -stdout, -destination or -listen")
+	}
+	return nil
+}
+
+// checkSettings validates values of Config fields other than the outputs
+func (c *Config) checkSettings() error {
 	if c.Chdir == "" {
 		return fmt.Errorf("invalid command line arguments to -chdir")
 	}
@@ -49,6 +110,26 @@ func (c *Config) CheckErrs() error {
 		return fmt.Errorf("invalid value passed to flag -collection-timeout. Value must be > 0, but given %v", c.CollectorTimeout)
 	}
 
+	if _, err := parseCollectorSettings(c.CollectorFreqStr); err != nil {
+		return fmt.Errorf("invalid value passed to flag -collector-frequency. %v", err)
+	}
+
+	switch c.CounterMode {
+	case counterModeRaw, counterModeRate, counterModeBoth:
+	default:
+		return fmt.Errorf("invalid value passed to flag -counter-mode. Value must be %s, %s or %s, but given %q", counterModeRaw, counterModeRate, counterModeBoth, c.CounterMode)
+	}
+
+	timeouts, err := parseCollectorSettings(c.CollectorTimeoutStr)
+	if err != nil {
+		return fmt.Errorf("invalid value passed to flag -collector-timeout. %v", err)
+	}
+	for _, s := range timeouts {
+		if s.value <= 0 {
+			return fmt.Errorf("invalid value passed to flag -collector-timeout. Timeout of %s must be > 0", s.pattern)
+		}
+	}
+
 	if c.WaitTime <= 0 || ((time.Duration(c.WaitTime) * time.Second) <= 0) {
 		return fmt.Errorf("invalid value passed to flag -retrywait. Value must be > 0, but given %v", c.WaitTime)
 	}
@@ -57,10 +138,79 @@ func (c *Config) CheckErrs() error {
 		return fmt.Errorf("invalid value passed to flag -duration. Value must be >= 0, but given %v", c.Duration)
 	}
 
-	if c.Stdout == false && c.Destination == "" {
-		return fmt.Errorf("provide at least one valid output flag -stdout or -destination")
+	if c.ExecTimeout < 0 || ((time.Duration(c.ExecTimeout) * time.Second) < 0) {
+		return fmt.Errorf("invalid value passed to flag -exec-timeout. Value must be >= 0, but given %v", c.ExecTimeout)
+	}
+
+	for name, limit := range map[string]int{"exec-cpu-limit": c.ExecCPULimit, "exec-memory-limit": c.ExecMemoryLimit,
+		"exec-nofile-limit": c.ExecNofileLimit, "exec-output-limit": c.ExecOutputLimit} {
+		if limit < 0 {
+			return fmt.Errorf("invalid value passed to flag -%s. Value must be >= 0, but given %v", name, limit)
+		}
+	}
+
+	if c.HostRoot != "" {
+		if fi, err := os.Stat(c.HostRoot); err != nil || !fi.IsDir() {
+			return fmt.Errorf("invalid value passed to flag -host-root. %s is not a directory", c.HostRoot)
+		}
+	}
+
+	if c.Record != "" && c.Replay != "" {
+		return fmt.Errorf("flags -record and -replay can't be given together")
+	}
+
+	if c.DryRun && (c.Record != "" || c.Replay != "") {
+		return fmt.Errorf("flag -dry-run can't be given with -record or -replay")
+	}
+
+	if c.SpoolSize < 0 {
+		return fmt.Errorf("invalid value passed to flag -spool-size. Value must be >= 0, but given %v", c.SpoolSize)
+	}
+
+	if c.HTTPBatchSize <= 0 {
+		return fmt.Errorf("invalid value passed to flag -http-batch-size. Value must be > 0, but given %v", c.HTTPBatchSize)
+	}
+
+	if c.HTTPFlushInterval <= 0 || ((time.Duration(c.HTTPFlushInterval) * time.Second) <= 0) {
+		return fmt.Errorf("invalid value passed to flag -http-flush-interval. Value must be > 0, but given %v", c.HTTPFlushInterval)
+	}
+
+	if c.HTTPRetries < 0 {
+		return fmt.Errorf("invalid value passed to flag -http-retries. Value must be >= 0, but given %v", c.HTTPRetries)
 	}
 
+	for name, value := range map[string]int{"file-max-size": c.FileMaxSize, "file-max-age": c.FileMaxAge, "file-keep": c.FileKeep} {
+		if value < 0 {
+			return fmt.Errorf("invalid value passed to flag -%s. Value must be >= 0, but given %v", name, value)
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("flags -tls-cert and -tls-key must be given together")
+	}
+
+	return nil
+}
+
+// ReadFile loads settings from a JSON or YAML file over the current values, YAML is expected
+// for .yaml and .yml files
+func (c *Config) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	}
+	if err != nil {
+		return fmt.Errorf("can't parse %s: %v", path, err)
+	}
 	return nil
 }
 
@@ -93,7 +243,7 @@ func (c *Config) ReadNodeID() (string, error) {
 
 // WriteNodeID writes the node.id file
 func (c *Config) WriteNodeID() error {
-	nodeIDFile := "node.id"
+	nodeIDFile := filepath.Join(c.Chdir, "node.id")
 	err := ioutil.WriteFile(nodeIDFile, []byte(c.NodeID), 0666)
 	if err != nil {
 		return fmt.Errorf("cannot write file [%s]: %v", nodeIDFile, err)
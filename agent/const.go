@@ -9,9 +9,50 @@ const (
 	collectorTimeout = 30
 	timeoutConnSend  = time.Second
 	dialTimeout      = time.Second
+	shutdownTimeout  = 10 * time.Second
 	failureLimit     = 5
+	killGrace        = 2 * time.Second // time a timed out collector has to return after its commands were killed
+	retryBackoff     = time.Minute     // first wait before a quarantined collector is retried
+	maxRetryBackoff  = time.Hour       // max wait before a quarantined collector is retried
 
 	intrptChSize = 10
 
-	protoTCP = "tcp"
+	protoTCP   = "tcp"
+	protoTLS   = "tls"
+	protoHTTP  = "http"
+	protoHTTPS = "https"
+	protoSink  = "sink"
+	protoFile  = "file"
+
+	classInventory = "inventory"
+	classMetric    = "metric"
+	classSyslog    = "syslog"
+	classCommand   = "command"
+
+	httpBatchSize       = 100
+	httpFlushInterval   = 10
+	httpRetries         = 5
+	httpTimeout         = 30 * time.Second
+	httpRetryBackoff    = time.Second
+	httpMaxRetryBackoff = time.Minute
+	httpMaxResponseSize = 1024 * 1024
+
+	execMaxFileSize = 1024 * 1024 * 1024 // max size of the file downloaded by ExecCommand
+
+	metricsPath = "/metrics"
+	adminSocket = "hds-agent.sock"
+	osRelease   = "/etc/os-release"
+
+	counterModeRaw  = "raw"
+	counterModeRate = "rate"
+	counterModeBoth = "both"
+
+	execTimeout = 3600 // seconds
+
+	spoolDir         = "spool"
+	spoolSize        = 100 // megabytes
+	spoolReplayBatch = 100
+
+	fileMaxSize = 100   // megabytes
+	fileMaxAge  = 86400 // seconds
 )
@@ -0,0 +1,332 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// controlCommand handles a runtime control command, it returns the output sent back in the command output blob
+type controlCommand func(a *Agent, args []string) (string, error)
+
+// controlCommands are the built-in commands, their arguments are given in RunArgs
+var controlCommands = map[string]controlCommand{
+	"RunCollector":     (*Agent).cmdRunCollector,
+	"RefreshInventory": (*Agent).cmdRefreshInventory,
+	"EnableCollector":  (*Agent).cmdEnableCollector,
+	"DisableCollector": (*Agent).cmdDisableCollector,
+	"SetFrequency":     (*Agent).cmdSetFrequency,
+	"SetMetadata":      (*Agent).cmdSetMetadata,
+	"Status":           (*Agent).cmdStatus,
+}
+
+// collectorStatus is the state of a collector reported by the Status command
+type collectorStatus struct {
+	Name       string  `json:"name"`
+	Kind       string  `json:"kind"` // metric or inventory
+	Type       string  `json:"type"`
+	State      string  `json:"state"`
+	Health     string  `json:"health"`
+	Frequency  float64 `json:"frequency"` // seconds
+	Timeout    float64 `json:"timeout"`   // seconds
+	NumErrs    int     `json:"numErrs"`
+	NumTimeout int     `json:"numTimeout"`
+	RetryAt    int64   `json:"retryAt,omitempty"` // unix time of the next retry of a quarantined collector
+	LastRun    int64   `json:"lastRun,omitempty"` // unix time of the start of the last collection
+}
+
+// destinationStatus is the state of a destination reported by the Status command
+type destinationStatus struct {
+	Destination string `json:"destination"`
+	Connected   bool   `json:"connected"`    // last connection or POST succeeded
+	Queued      int    `json:"queued"`       // messages waiting in the queue
+	Spooled     int64  `json:"spooledBytes"` // bytes waiting in the spool
+}
+
+// agentStatus is the output of the Status command
+type agentStatus struct {
+	NodeID       string              `json:"nodeID"`
+	Hostname     string              `json:"hostname"`
+	Frequency    float64             `json:"frequency"` // seconds
+	Destinations []destinationStatus `json:"destinations"`
+	Collectors   []collectorStatus   `json:"collectors"`
+}
+
+// runControlCommand runs a built-in command and acknowledges it with status syslogs and an output blob
+func (a *Agent) runControlCommand(cmd command, handler controlCommand) bool {
+	log.Infof("executing control command: %v", cmd)
+	a.sendCmdStatusSyslog(cmd, "received")
+
+	cmdOut := commandOutput{NodeID: a.config().NodeID, CmdID: cmd.CmdID, RunCmd: cmd.Name, RunArgs: cmd.RunArgs}
+	a.controlMtx.Lock()
+	out, err := handler(a, cmd.RunArgs)
+	a.controlMtx.Unlock()
+	cmdOut.Stdout = out
+	if err != nil {
+		log.Errorf("error during execution of [%+v]: %v", cmd, err)
+		a.sendCmdStatusSyslog(cmd, "error")
+		cmdOut.Status = "error"
+		cmdOut.Stderr = err.Error()
+		a.sendCmdOutputBlob(cmdOut)
+		return false
+	}
+	a.sendCmdStatusSyslog(cmd, "success")
+	cmdOut.Status = "success"
+	a.sendCmdOutputBlob(cmdOut)
+	return true
+}
+
+// cmdRunCollector runs the named metric and inventory collectors now, also stopped ones.
+// Inventory blobs are sent even if they did not change
+func (a *Agent) cmdRunCollector(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("no collector given")
+	}
+	metrics, invs, err := a.lookupCollectors(args)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range metrics {
+		go a.runMetricCollector(c)
+	}
+	if len(invs) > 0 {
+		go a.runInvCollectors(nil, invs, true)
+	}
+	return fmt.Sprintf("started %d collectors", len(metrics)+len(invs)), nil
+}
+
+// cmdRefreshInventory collects and sends the inventory blobs of given types, e.g. inventory.all, or all of them
+func (a *Agent) cmdRefreshInventory(args []string) (string, error) {
+	var names []string
+	types := make(map[string]bool)
+	a.inventoryCollectors.RLock()
+	for _, t := range args {
+		types[t] = false
+	}
+	for name, c := range a.inventoryCollectors.List {
+		if _, ok := types[c.blobType()]; len(args) > 0 && !ok {
+			continue
+		}
+		types[c.blobType()] = true
+		if c.state == runningState {
+			names = append(names, name)
+		}
+	}
+	a.inventoryCollectors.RUnlock()
+
+	for t, found := range types {
+		if !found {
+			return "", fmt.Errorf("unknown inventory type %s", t)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no running inventory collectors")
+	}
+	go a.runInvCollectors(nil, names, false)
+	return fmt.Sprintf("refreshing %d inventory collectors", len(names)), nil
+}
+
+// cmdEnableCollector removes the named collectors from the skip list and starts them. The skip list
+// of the config applies again when it is reloaded
+func (a *Agent) cmdEnableCollector(args []string) (string, error) {
+	if _, _, err := a.lookupCollectors(args); err != nil {
+		return "", err
+	}
+	a.settingsMtx.Lock()
+	for _, name := range args {
+		delete(a.Skipmap, name)
+	}
+	a.settingsMtx.Unlock()
+	a.applyCollectors()
+	a.signalInventorySchedule()
+
+	var stopped []string
+	for _, name := range args {
+		if a.collectorState(name) != runningState {
+			stopped = append(stopped, name)
+		}
+	}
+	if len(stopped) > 0 {
+		return "", fmt.Errorf("collectors %v did not start, see precheck in the agent's log", stopped)
+	}
+	return fmt.Sprintf("enabled %v", args), nil
+}
+
+// cmdDisableCollector adds the named collectors to the skip list and stops them
+func (a *Agent) cmdDisableCollector(args []string) (string, error) {
+	if _, _, err := a.lookupCollectors(args); err != nil {
+		return "", err
+	}
+	a.settingsMtx.Lock()
+	for _, name := range args {
+		a.Skipmap[name] = struct{}{}
+	}
+	a.settingsMtx.Unlock()
+	a.applyCollectors()
+	a.signalInventorySchedule()
+	return fmt.Sprintf("disabled %v", args), nil
+}
+
+// cmdSetFrequency sets the frequency of metric collection, args are seconds, or a collector name
+// and seconds to set the frequency of a single collector
+func (a *Agent) cmdSetFrequency(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", fmt.Errorf("expected arguments [name] seconds")
+	}
+	secs, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || secs < 0 {
+		return "", fmt.Errorf("invalid frequency %q, must be seconds >= 0", args[len(args)-1])
+	}
+	freq := time.Duration(secs) * time.Second
+
+	if len(args) == 2 {
+		if _, _, err := a.lookupCollectors(args[:1]); err != nil {
+			return "", err
+		}
+	}
+
+	a.settingsMtx.Lock()
+	if len(args) == 1 {
+		// the config is replaced, not changed, as it is read without lock
+		c := *a.Config
+		c.Freq = secs
+		a.Config = &c
+		a.MetricFrequency = freq
+		a.InvFrequency = invFrequency
+		if a.MetricFrequency == 0 {
+			a.InvFrequency = 0
+		}
+	} else {
+		name := args[0]
+		settings := []collectorSetting{{pattern: name, value: freq}}
+		for _, s := range a.collectorFreqs {
+			if s.pattern != name {
+				settings = append(settings, s)
+			}
+		}
+		a.collectorFreqs = settings
+	}
+	a.settingsMtx.Unlock()
+	a.applyCollectors()
+	a.signalInventorySchedule()
+	return fmt.Sprintf("frequency set to %d seconds", secs), nil
+}
+
+// cmdSetMetadata sets a metadata value of a metric, or of ":all" metrics, args are metric, name and value
+func (a *Agent) cmdSetMetadata(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", fmt.Errorf("expected arguments metric name value")
+	}
+	changed, err := a.setMetadata(args[0], args[1], args[2], true)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		return "metadata not changed", nil
+	}
+	return "metadata changed", nil
+}
+
+// cmdStatus returns the state of the agent, its destinations and collectors as JSON
+func (a *Agent) cmdStatus(args []string) (string, error) {
+	out, err := json.Marshal(a.status())
+	return string(out), err
+}
+
+// status returns the state of the agent, its destinations and collectors
+func (a *Agent) status() agentStatus {
+	a.settingsMtx.RLock()
+	status := agentStatus{
+		NodeID:    a.Config.NodeID,
+		Hostname:  a.hostname,
+		Frequency: a.MetricFrequency.Seconds(),
+	}
+	a.settingsMtx.RUnlock()
+
+	a.destinationsMtx.RLock()
+	for _, d := range a.Destinations {
+		ds := destinationStatus{Destination: d.spec, Connected: atomic.LoadInt32(&d.connected) == 1, Queued: len(d.sendCh)}
+		if d.spool != nil {
+			ds.Spooled = d.spool.Len()
+		}
+		status.Destinations = append(status.Destinations, ds)
+	}
+	a.destinationsMtx.RUnlock()
+
+	a.metricCollectors.RLock()
+	for _, c := range a.metricCollectors.List {
+		status.Collectors = append(status.Collectors, collectorStatus{
+			Name: c.name, Kind: classMetric, Type: c.collectorType, State: c.state,
+			Frequency: c.frequency.Seconds(), Timeout: c.timeout.Seconds(),
+		}.withRunState(&c.BaseCollector))
+	}
+	a.metricCollectors.RUnlock()
+
+	a.inventoryCollectors.RLock()
+	for _, c := range a.inventoryCollectors.List {
+		status.Collectors = append(status.Collectors, collectorStatus{
+			Name: c.name, Kind: classInventory, Type: c.collectorType, State: c.state,
+			Frequency: c.frequency.Seconds(), Timeout: c.timeout.Seconds(),
+		}.withRunState(&c.BaseCollector))
+	}
+	a.inventoryCollectors.RUnlock()
+	sort.Slice(status.Collectors, func(i, j int) bool { return status.Collectors[i].Name < status.Collectors[j].Name })
+	return status
+}
+
+// withRunState returns the status with the health, failure counts and the last run of collector u
+func (s collectorStatus) withRunState(u *BaseCollector) collectorStatus {
+	u.healthMtx.Lock()
+	defer u.healthMtx.Unlock()
+	s.NumErrs, s.NumTimeout = u.numErrs, u.numTimeout
+	s.Health = u.health
+	if s.Health == "" {
+		s.Health = healthy
+	}
+	if u.health == quarantined {
+		s.RetryAt = u.retryAt.Unix()
+	}
+	if !u.lastRun.IsZero() {
+		s.LastRun = u.lastRun.Unix()
+	}
+	return s
+}
+
+// lookupCollectors returns the metric collectors and the names of the inventory collectors of given names
+func (a *Agent) lookupCollectors(names []string) (metrics []*MetricCollector, invs []string, err error) {
+	a.metricCollectors.RLock()
+	defer a.metricCollectors.RUnlock()
+	a.inventoryCollectors.RLock()
+	defer a.inventoryCollectors.RUnlock()
+	for _, name := range names {
+		if c, ok := a.metricCollectors.List[name]; ok {
+			metrics = append(metrics, c)
+		} else if _, ok := a.inventoryCollectors.List[name]; ok {
+			invs = append(invs, name)
+		} else {
+			return nil, nil, fmt.Errorf("unknown collector %s", name)
+		}
+	}
+	return metrics, invs, nil
+}
+
+// collectorState returns the state of the named collector
+func (a *Agent) collectorState(name string) string {
+	a.metricCollectors.RLock()
+	defer a.metricCollectors.RUnlock()
+	a.inventoryCollectors.RLock()
+	defer a.inventoryCollectors.RUnlock()
+	if c, ok := a.metricCollectors.List[name]; ok {
+		return c.state
+	}
+	if c, ok := a.inventoryCollectors.List[name]; ok {
+		return c.state
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+)
+
+// distro families, whose package names and package manager differ
+const (
+	distroDebian = "debian"
+	distroRHEL   = "rhel"
+	distroSUSE   = "suse"
+	distroAlpine = "alpine"
+	distroArch   = "arch"
+)
+
+// distroIDs maps ID and ID_LIKE values of os-release to the distro family
+var distroIDs = map[string]string{
+	"debian":              distroDebian,
+	"ubuntu":              distroDebian,
+	"raspbian":            distroDebian,
+	"linuxmint":           distroDebian,
+	"rhel":                distroRHEL,
+	"centos":              distroRHEL,
+	"fedora":              distroRHEL,
+	"rocky":               distroRHEL,
+	"almalinux":           distroRHEL,
+	"ol":                  distroRHEL,
+	"amzn":                distroRHEL,
+	"suse":                distroSUSE,
+	"sles":                distroSUSE,
+	"opensuse":            distroSUSE,
+	"opensuse-leap":       distroSUSE,
+	"opensuse-tumbleweed": distroSUSE,
+	"alpine":              distroAlpine,
+	"arch":                distroArch,
+	"manjaro":             distroArch,
+}
+
+// installCommands is the command installing packages by distro family
+var installCommands = map[string]string{
+	distroDebian: "apt-get install",
+	distroRHEL:   "dnf install",
+	distroSUSE:   "zypper install",
+	distroAlpine: "apk add",
+	distroArch:   "pacman -S",
+}
+
+// dependencyPackages maps collector dependencies to the package providing them by distro family. The
+// package for an unknown family is under ""
+var dependencyPackages = map[string]map[string]string{
+	"smartctl":   {"": "smartmontools"},
+	"ipmitool":   {"": "ipmitool"},
+	"bmc-info":   {"": "freeipmi", distroDebian: "freeipmi-tools"},
+	"dmidecode":  {"": "dmidecode"},
+	"lspci":      {"": "pciutils"},
+	"lsusb":      {"": "usbutils"},
+	"ethtool":    {"": "ethtool"},
+	"rpm":        {"": "rpm", distroArch: "rpm-tools"},
+	"dpkg-query": {"": "dpkg"},
+}
+
+// distro is the linux distribution the agent runs on
+type distro struct {
+	Name   string `json:"name"`   // PRETTY_NAME of os-release
+	Family string `json:"family"` // empty if unknown
+}
+
+// detectDistro reads the distribution from the os-release file. The family is empty if the file
+// can't be read or the distribution is unknown
+func detectDistro(path string) distro {
+	var d distro
+	f, err := os.Open(path)
+	if err != nil {
+		return d
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(parts[1], `"'`)
+		switch parts[0] {
+		case "PRETTY_NAME":
+			d.Name = value
+		case "ID":
+			// ID is looked up before ID_LIKE
+			ids = append([]string{value}, ids...)
+		case "ID_LIKE":
+			ids = append(ids, strings.Fields(value)...)
+		}
+	}
+	for _, id := range ids {
+		if family, ok := distroIDs[strings.ToLower(id)]; ok {
+			d.Family = family
+			break
+		}
+	}
+	return d
+}
+
+// packages returns the sorted packages providing deps on the distro
+func (d distro) packages(deps []string) []string {
+	set := make(map[string]struct{})
+	for _, dep := range deps {
+		pkgs, ok := dependencyPackages[dep]
+		if !ok {
+			// not in the catalogue, the package is often named after the tool
+			set[dep] = struct{}{}
+			continue
+		}
+		if pkg, ok := pkgs[d.Family]; ok {
+			set[pkg] = struct{}{}
+		} else {
+			set[pkgs[""]] = struct{}{}
+		}
+	}
+	packages := make([]string, 0, len(set))
+	for pkg := range set {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+// installCommand returns the command installing packages on the distro, or just the packages if the
+// package manager of the distro is unknown
+func (d distro) installCommand(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	cmd, ok := installCommands[d.Family]
+	if !ok {
+		return "install " + strings.Join(packages, " ")
+	}
+	return cmd + " " + strings.Join(packages, " ")
+}
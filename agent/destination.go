@@ -2,42 +2,167 @@ package agent
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/inventory"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
-// parseDest returns struct Destination from given destination string after validation
+// newDestinations returns destinations given in the config with their TLS and http settings
+func newDestinations(config *Config) ([]*Destination, error) {
+	dsts, err := parseDests(config.Destination)
+	if err != nil {
+		return nil, err
+	}
+	for _, dst := range dsts {
+		if dst.proto == protoTLS || dst.proto == protoHTTPS {
+			if dst.tlsConfig, err = newTLSConfig(config, dst.host()); err != nil {
+				return nil, fmt.Errorf("invalid TLS settings for %s, %v", dst.dst, err)
+			}
+		}
+		if dst.proto == protoHTTP || dst.proto == protoHTTPS {
+			dst.batchSize = config.HTTPBatchSize
+			dst.flushInterval = time.Duration(config.HTTPFlushInterval) * time.Second
+			dst.retries = config.HTTPRetries
+		}
+		if dst.proto == protoFile {
+			// relative paths are under the working directory
+			if !filepath.IsAbs(dst.dst) {
+				dst.dst = filepath.Join(config.Chdir, dst.dst)
+			}
+			dst.sink = newFileSink(dst.dst, config)
+		}
+	}
+	return dsts, nil
+}
+
+// open creates the queue of the destination and opens its spool
+func (d *Destination) open(config *Config) error {
+	if config.SpoolSize > 0 {
+		var err error
+		spoolPath := filepath.Join(config.Chdir, spoolDir, d.spoolName())
+		if d.spool, err = openSpool(spoolPath, int64(config.SpoolSize)*1024*1024); err != nil {
+			log.Errorf("can't open spool in %s: %v", spoolPath, err)
+			return err
+		}
+	}
+	registry.RLock()
+	d.sendCh = make(chan []byte, len(inventory.Collectors))
+	registry.RUnlock()
+	d.quit = make(chan struct{})
+	d.done = make(chan struct{})
+	return nil
+}
+
+// closeDestination moves data not delivered to a stopped destination to its spool and closes the spool
+func (a *Agent) closeDestination(ds *Destination, pending [][]byte) {
+	defer close(ds.done)
+	log.Infof("stopped sending to %s", ds.dst)
+	pending = append(pending, drainQueue(ds.sendCh)...)
+	if ds.spool == nil {
+		if len(pending) > 0 {
+			log.Errorf("dropping %d messages not delivered to %s", len(pending), ds.dst)
+		}
+		return
+	}
+	for _, data := range pending {
+		a.spoolData(ds, data)
+	}
+	if err := ds.spool.Close(); err != nil {
+		log.Errorf("can't close spool of %s: %v", ds.dst, err)
+	}
+}
+
+// drainQueue returns data waiting in the queue without blocking
+func drainQueue(queue <-chan []byte) (data [][]byte) {
+	for {
+		select {
+		case d := <-queue:
+			data = append(data, d)
+		default:
+			return data
+		}
+	}
+}
+
+// parseDests returns destinations from given comma separated list of destination strings
+func parseDests(dsts string) ([]*Destination, error) {
+	var ds []*Destination
+	seen := make(map[string]struct{})
+	for _, dst := range strings.Split(dsts, ",") {
+		if strings.TrimSpace(dst) == "" {
+			continue
+		}
+		d, err := parseDest(dst)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[d.spoolName()]; ok {
+			return nil, fmt.Errorf("destination given more than once: %s", d.dst)
+		}
+		seen[d.spoolName()] = struct{}{}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// parseDest returns struct Destination from given destination string after validation.
+// The destination may be followed by ";" and a "+" separated list of data classes sent to it
 func parseDest(dst string) (*Destination, error) {
 	d := Destination{}
 
-	d.dst = strings.ToLower(strings.TrimSpace(dst))
+	dst = strings.TrimSpace(dst)
+	d.spec = dst
+	d.dst = strings.ToLower(dst)
 	// safe set
 	d.proto = ""
 
-	//don't allow more than one destination in the dst parameter (assuming they are comma separated)
-	if len(strings.Split(dst, ",")) > 1 {
-		return nil, fmt.Errorf("invalid address: %s", dst)
-	}
-
 	// if set to "", just ignore
 	if dst == "" {
 		return &d, nil
 	}
 
+	if i := strings.LastIndex(dst, ";"); i >= 0 {
+		classes, err := parseClasses(dst[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter of destination %s: %v", dst, err)
+		}
+		d.classes = classes
+		dst = dst[:i]
+	}
+
 	switch {
-	case strings.HasPrefix(dst, protoTCP+":"):
-		d.proto = protoTCP
-		dst = strings.TrimPrefix(dst, protoTCP+":")
+	case strings.HasPrefix(dst, protoTCP+":"), strings.HasPrefix(dst, protoTLS+":"):
+		d.proto = dst[:strings.Index(dst, ":")]
+		dst = strings.TrimPrefix(dst, d.proto+":")
 		err := isValidAddress(dst)
 		if err != nil {
 			return nil, fmt.Errorf("given an invalid remote address (requires valid host and port): %v, %v", dst, err)
 		}
+	case strings.HasPrefix(dst, protoHTTP+"://"), strings.HasPrefix(dst, protoHTTPS+"://"):
+		u, err := url.Parse(dst)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("given an invalid URL: %v, %v", dst, err)
+		}
+		d.proto = u.Scheme
+	case strings.HasPrefix(dst, protoFile+":"):
+		d.proto = protoFile
+		dst = filepath.Clean(strings.TrimPrefix(dst, protoFile+":"))
+		if dst == "." || strings.HasSuffix(dst, string(filepath.Separator)) {
+			return nil, fmt.Errorf("given an invalid file path: %v", dst)
+		}
 	default:
 		return nil, fmt.Errorf("invalid destination provided: %s", dst)
 	}
@@ -46,6 +171,45 @@ func parseDest(dst string) (*Destination, error) {
 	return &d, nil
 }
 
+// parseClasses parses a "+" separated list of data classes
+func parseClasses(classStr string) (map[string]bool, error) {
+	classes := make(map[string]bool)
+	for _, class := range strings.Split(classStr, "+") {
+		class = strings.ToLower(strings.TrimSpace(class))
+		switch class {
+		case classInventory, classMetric, classSyslog, classCommand:
+			classes[class] = true
+		default:
+			return nil, fmt.Errorf("unknown data class %q, valid are %s, %s, %s and %s", class, classInventory, classMetric, classSyslog, classCommand)
+		}
+	}
+	return classes, nil
+}
+
+// accepts reports if data of given class is sent to the destination, without a filter it takes everything
+func (d *Destination) accepts(class string) bool {
+	return d.classes == nil || d.classes[class]
+}
+
+// spoolName returns name of the spool folder of the destination
+func (d *Destination) spoolName() string {
+	return unsafeNameChars.ReplaceAllString(d.proto+"_"+strings.TrimPrefix(d.dst, d.proto+"://"), "_")
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// host returns the host name of the destination server
+func (d *Destination) host() string {
+	if d.proto == protoHTTP || d.proto == protoHTTPS {
+		if u, err := url.Parse(d.dst); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	host, _, _ := net.SplitHostPort(d.dst)
+	return host
+}
+
 // Agent is running without any flags
 func getHostPort(addr string) (string, int, error) {
 	host, portStr, err := net.SplitHostPort(addr)
@@ -60,37 +224,49 @@ func getHostPort(addr string) (string, int, error) {
 }
 
 func (a *Agent) connect() {
-	destination := a.Destination
-
-	log.Infof("agent connectToDestination, dest %s, protocol %s", destination.dst, destination.proto)
-	if destination.proto == protoTCP {
-		go a.connectTCP(a.SendCh)
-	}
-
-	if a.Config.DryRun == true {
-		log.Info("destination set to null")
-		go a.connectDryRun(a.SendCh)
+	a.destinationsMtx.RLock()
+	for _, d := range a.Destinations {
+		a.startDestination(d)
 	}
+	a.destinationsMtx.RUnlock()
 }
 
-func (a *Agent) connectDryRun(source <-chan []byte) {
-	log.Infof("attempt sending to null")
-
-	a.sendInventory()
-	for data := range source {
-		log.Infof("suppressed output: %s", data)
+// startDestination starts sending data queued for the destination
+func (a *Agent) startDestination(d *Destination) {
+	log.Infof("agent connectToDestination, dest %s, protocol %s", d.dst, d.proto)
+	switch d.proto {
+	case protoTCP, protoTLS:
+		go a.connectTCP(d)
+	case protoHTTP, protoHTTPS:
+		go a.connectHTTP(d)
+	case protoSink, protoFile:
+		go a.connectSink(d)
 	}
 }
 
-func (a *Agent) connectTCP(source <-chan []byte) {
+// readyCh is always ready to receive, it enables a select case which has work to do
+var readyCh = func() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// connectTCP sends data queued for a tcp or tls destination, reconnecting when the connection is lost
+func (a *Agent) connectTCP(ds *Destination) {
 	var (
 		conn    net.Conn
 		errc    chan error
 		msgc    <-chan []byte
+		replayc <-chan struct{}
 		pending []byte
 	)
 
-	ds := a.Destination
+	source := ds.sendCh
+	sp := ds.spool
+	if sp != nil {
+		// with spool data is read all the time, it goes to the spool while disconnected
+		msgc = source
+	}
 	reconnectTimer := time.After(0)
 	sendToServer := func(msg []byte) error {
 		log.Infof("attempt sending to servaddr '%s'", ds.dst)
@@ -99,75 +275,167 @@ func (a *Agent) connectTCP(source <-chan []byte) {
 			return err
 		}
 		log.Infof("sent %d bytes", len(msg))
+		a.countSent(ds, msg)
 		return nil
 	}
 
+	// fail closes the connection after an error and connects again after WaitTime. errc is read only
+	// for the error of the listener of the connection, a failed write could fill it
+	fail := func(err error) {
+		if conn != nil {
+			log.Errorf("connection error with server, %s", err)
+			atomic.StoreInt32(&ds.connected, 0)
+			log.Infof("closing connection to %s", ds.dst)
+			if err := conn.Close(); err != nil {
+				log.Errorf("error closing connection: %v", err)
+			}
+			conn = nil
+		} else {
+			log.Errorf("connection attempt to %s failed: %s", ds.dst, err)
+		}
+		errc = nil
+		replayc = nil
+		if sp == nil {
+			msgc = nil
+		}
+		wait := a.waitTime()
+		log.Errorf("attempting to reconnect in %0.f seconds", wait.Seconds())
+		a.countDestination(ds, statReconnects, 1)
+		reconnectTimer = time.After(wait)
+	}
+
+	// replay sends a batch of spooled data, it reports if there is more to send
+	replay := func() (bool, error) {
+		records, err := sp.Peek(spoolReplayBatch)
+		if err != nil {
+			log.Errorf("can't replay spooled data: %v", err)
+			return false, nil
+		}
+		if len(records) == 0 {
+			log.Infof("spooled data replayed to %s", ds.dst)
+			return false, nil
+		}
+		// the spool is updated once per batch, with the records sent before an error
+		sent := 0
+		for _, data := range records {
+			if err = sendToServer(append(data, '\n')); err != nil {
+				break
+			}
+			sent++
+		}
+		if cerr := sp.Commit(sent); cerr != nil {
+			log.Errorf("can't update spool: %v", cerr)
+		}
+		return err == nil, err
+	}
+
 	connectToServer := func() {
 		log.Infof("attempt connecting to servaddr %s", ds.dst)
 		reconnectTimer = nil
-		errc = make(chan error, 2)
 		var err error
 		conn, err = a.dialServer(ds)
 		if err != nil {
-			errc <- err
+			fail(err)
 			return
 		}
 		log.Infof("successfully connected to %s", ds.dst)
+		atomic.StoreInt32(&ds.connected, 1)
 
 		//send !nodeID and headers message
-		nodeIDAndHeaders := a.initialSendData()
+		nodeIDAndHeaders := a.initialSendData(ds)
+		a.destinationConnected(ds)
+		errc = make(chan error, 1)
 		attachListener(conn, a.processCommands, errc)
 		if err := sendToServer(nodeIDAndHeaders); err != nil {
-			errc <- err
+			fail(err)
 			return
 		}
 		// Send the inventory
-		a.sendInventory()
+		a.sendInventory(ds)
 
 		// Send pending message
 		if len(pending) != 0 {
 			if err := sendToServer(pending); err != nil {
-				errc <- err
+				fail(err)
 				return
 			}
 			pending = []byte{}
 		}
 
+		// Replay spooled data before new data
+		if sp != nil && !sp.Empty() {
+			log.Infof("replaying %d bytes of spooled data to %s", sp.Len(), ds.dst)
+			replayc = readyCh
+		}
+
 		msgc = source
 	}
 
 	for {
 		select {
-		case <-reconnectTimer:
-			connectToServer()
-
-		case err := <-errc:
+		case <-ds.quit:
+			// deliver what is still queued within the deadline, the rest goes to the spool
+			queued := drainQueue(ds.sendCh)
+			if len(pending) != 0 {
+				queued = append([][]byte{bytes.TrimSuffix(pending, []byte{'\n'})}, queued...)
+			}
 			if conn != nil {
-				log.Errorf("connection error with server, %s", err)
-				log.Infof("closing connection to %s", ds.dst)
+				conn.SetWriteDeadline(time.Now().Add(shutdownTimeout))
+				if sp != nil && !sp.Empty() {
+					// keep order, queued data goes after what is already spooled
+					for _, data := range queued {
+						a.spoolData(ds, data)
+					}
+					queued = nil
+					for more, _ := replay(); more; more, _ = replay() {
+					}
+				}
+				for len(queued) > 0 && sendToServer(append(queued[0], '\n')) == nil {
+					queued = queued[1:]
+				}
 				if err := conn.Close(); err != nil {
 					log.Errorf("error closing connection: %v", err)
 				}
-				errc = nil
-				msgc = nil
-				conn = nil
-			} else {
-				log.Errorf("connection attempt to %s failed: %s", ds.dst, err)
 			}
-			log.Errorf("attempting to reconnect in %0.f seconds", a.WaitTime.Seconds())
-			reconnectTimer = time.After(a.WaitTime)
+			a.closeDestination(ds, queued)
+			return
+
+		case <-reconnectTimer:
+			connectToServer()
+
+		case err := <-errc:
+			fail(err)
+
+		case <-replayc:
+			if more, err := replay(); err != nil {
+				fail(err)
+			} else if !more {
+				replayc = nil
+			}
 
 		case data := <-msgc:
+			if sp != nil && (conn == nil || !sp.Empty()) {
+				// keep order, new data goes after what is already spooled
+				a.spoolData(ds, data)
+				if conn != nil {
+					replayc = readyCh
+				}
+				continue
+			}
 			if err := sendToServer(append(data, '\n')); err != nil {
-				pending = append(data, '\n')
-				msgc = nil //here we ensure, that next case of this select will not be on <-msgc
-				errc <- err
+				if sp != nil {
+					a.spoolData(ds, data)
+				} else {
+					pending = append(data, '\n')
+				}
+				fail(err)
 			}
 		}
 	}
 }
 
-func (a *Agent) initialSendData() []byte {
+// initialSendData returns !nodeID message, followed by metric headers and metadata if the destination takes metrics
+func (a *Agent) initialSendData(ds *Destination) []byte {
 	log.Info("Sending initial data")
 	var initialData string
 
@@ -176,22 +444,33 @@ func (a *Agent) initialSendData() []byte {
 	}
 	metadataBytes, err := json.Marshal(metadata)
 	if err == nil {
-		initialData = fmt.Sprintf("!nodeID %s\n!metadata %s\n", a.Config.NodeID, string(metadataBytes))
+		initialData = fmt.Sprintf("!nodeID %s\n!metadata %s\n", a.config().NodeID, string(metadataBytes))
 	} else {
-		initialData = fmt.Sprintf("!nodeID %s\n", a.Config.NodeID)
+		initialData = fmt.Sprintf("!nodeID %s\n", a.config().NodeID)
 		log.Errorf("Couldn't marshal !metadata message, %s", err)
 	}
-	//add metric headers
+	if !ds.accepts(classMetric) {
+		return []byte(initialData)
+	}
+
+	// headers and metadata are added in the order of the metrics, so that a replay sends the same bytes
 	a.metricHeaders.RLock()
-	for _, header := range a.metricHeaders.Map {
-		initialData += header + "\n"
+	for _, metric := range sortedKeys(a.metricHeaders.Map) {
+		initialData += a.metricHeaders.Map[metric] + "\n"
 	}
 	a.metricHeaders.RUnlock()
 
 	//add metadata lines
 	a.metricMetadata.RLock()
-	for metric, metadata := range a.metricMetadata.Map {
-		for name, val := range metadata {
+	metrics := make([]string, 0, len(a.metricMetadata.Map))
+	for metric := range a.metricMetadata.Map {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		metadata := a.metricMetadata.Map[metric]
+		for _, name := range sortedKeys(metadata) {
+			val := metadata[name]
 			data, err := a.metadataString(metric, name, val)
 			if err != nil {
 				log.Error(err.Error())
@@ -238,6 +517,12 @@ func (a *Agent) dialServer(ds *Destination) (net.Conn, error) {
 		return nil, fmt.Errorf("can't determine server's address:port from: %s, %s", ds.dst, err)
 	}
 
+	if ds.proto == protoTLS {
+		dialer := &net.Dialer{Timeout: a.dialTimeout}
+		conn, er = tls.DialWithDialer(dialer, protoTCP, ds.dst, ds.tlsConfig)
+		return conn, er
+	}
+
 	conn, er = net.DialTimeout(protoTCP, ds.dst, a.dialTimeout)
 	return conn, er
 }
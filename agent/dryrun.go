@@ -1,161 +1,183 @@
 package agent
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
-	"io"
 	"os"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 )
 
-func prepareCaptureStdout() (r, w, old *os.File) {
-	old = os.Stdout // keep backup of the real stdout
-	r, w, _ = os.Pipe()
-	os.Stdout = w
-	return r, w, old
-}
-
-func captureStdout(r, w, old *os.File) string {
-	outC := make(chan string)
-	// copy the output in a separate goroutine so printing can't block indefinitely
-	go func() {
-		var buf bytes.Buffer
-		io.Copy(&buf, r)
-		outC <- buf.String()
-	}()
+// dryRunSampleSize is the max number of bytes of collector output shown in the dry-run report
+const dryRunSampleSize = 512
 
-	// back to normal state
-	w.Close()
-	os.Stdout = old // restoring the real stdout
-	return <-outC
-}
+// exit codes of dry-run
+const (
+	dryRunOK          = 0 // all collectors which are not skipped ran
+	dryRunFailed      = 1 // a collector failed or timed out
+	dryRunInvalid     = 2 // invalid flags or config
+	dryRunUnavailable = 3 // no collector failed, but some miss dependencies or failed their precheck
+)
 
-func prepareCaptureStderr() (r, w, old *os.File) {
-	old = os.Stderr // keep backup of the real stderr
-	r, w, _ = os.Pipe()
-	os.Stderr = w
-	return r, w, old
+// dryRunCollector is the result of a collector in the dry-run report
+type dryRunCollector struct {
+	Name                string   `json:"name"`
+	Kind                string   `json:"kind"` // metric or inventory
+	Type                string   `json:"type"`
+	Status              string   `json:"status"` // ok, skipped, unavailable, failed or timeout
+	MissingDependencies []string `json:"missingDependencies,omitempty"`
+	Packages            []string `json:"packages,omitempty"` // packages providing the missing dependencies
+	Precheck            string   `json:"precheck,omitempty"` // why the collector can't run
+	Error               string   `json:"error,omitempty"`    // error of the collection
+	Duration            float64  `json:"duration"`           // seconds the collection took
+	Bytes               int      `json:"bytes"`              // bytes of output of one collection
+	Items               int      `json:"items"`              // messages sent for one collection
+	Sample              string   `json:"sample,omitempty"`   // beginning of the output
 }
 
-func captureStderr(r, w, old *os.File) string {
-	outC := make(chan string)
-	// copy the output in a separate goroutine so printing can't block indefinitely
-	go func() {
-		var buf bytes.Buffer
-		io.Copy(&buf, r)
-		outC <- buf.String()
-	}()
-
-	// back to normal state
-	w.Close()
-	os.Stderr = old // restoring the real stderr
-	return <-outC
+// dryRunReport is the output of dry-run
+type dryRunReport struct {
+	NodeID             string            `json:"nodeID"`
+	Distro             distro            `json:"distro"`
+	Frequency          int               `json:"frequency"` // seconds
+	Collectors         []dryRunCollector `json:"collectors"`
+	BytesPerIteration  int               `json:"bytesPerIteration"` // metric and inventory output of one collection
+	ItemsPerIteration  int               `json:"itemsPerIteration"`
+	EstimatedBytesHour int               `json:"estimatedBytesPerHour"` // metrics at -frequency, inventory once
+	EstimatedItemsHour int               `json:"estimatedItemsPerHour"`
+	Install            string            `json:"install,omitempty"` // command installing the missing dependencies
+	ExitCode           int               `json:"exitCode"`
 }
 
-func findErrors(stderr string) (errorLines []string) {
-	lines := strings.Split(stderr, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "E") || strings.Contains(line, " error") {
-			errorLines = append(errorLines, line)
-		}
+// dryRun runs every collector once without sending data, writes a JSON report to stdout and returns
+// the exit code
+func (a *Agent) dryRun() int {
+	config := a.Config
+	// output flags are not needed, nothing is sent
+	config.Stdout = true
+	if err := config.CheckErrs(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return dryRunInvalid
+	}
+	a.standalone = true
+	if err := a.setupCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return dryRunInvalid
+	}
+	if nodeID, err := config.ReadNodeID(); err == nil {
+		config.NodeID = nodeID
+	} else {
+		// a new node ID is not written by dry-run, a placeholder of the same size is used
+		config.NodeID = strings.Repeat("0", 32)
 	}
-	return errorLines
-}
-
-func dryRun(context *Agent, initialFreq int) {
-	const dryRunSeconds = 10
-	log.Consolef("running in 'dry run' mode, all flags will be ignored. It will take %d seconds...", dryRunSeconds)
-
-	r, w, old := prepareCaptureStdout()
-	r2, w2, old2 := prepareCaptureStderr()
-
-	go func() {
-		<-time.After(dryRunSeconds * time.Second)
-
-		stdout := captureStdout(r, w, old)
-		stderr := captureStderr(r2, w2, old2)
 
-		// sleep a little to let agent last logs be printed before printing dry run results
-		time.Sleep(100 * time.Millisecond)
-		err := dryRunResults(stdout, stderr, initialFreq)
-		if err != nil {
-			log.Errorf("Exit because of error: %s", err.Error())
-			os.Exit(1)
+	names := a.allCollectorNames()
+	d := detectDistro(collectors.HostPath(osRelease))
+	report := dryRunReport{NodeID: config.NodeID, Distro: d, Frequency: config.Freq, Collectors: make([]dryRunCollector, len(names))}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			report.Collectors[i] = a.dryRunCollector(name, d)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var metricBytes, metricItems, invBytes, invItems int
+	var missing []string
+	for _, c := range report.Collectors {
+		missing = append(missing, c.MissingDependencies...)
+		switch {
+		case c.Status == "failed" || c.Status == "timeout":
+			report.ExitCode = dryRunFailed
+		case c.Status == "unavailable" && report.ExitCode == dryRunOK:
+			report.ExitCode = dryRunUnavailable
 		}
-		context.WaitGroup.Done()
-		os.Exit(1)
-	}()
-}
-func dryRunResults(stdout, stderr string, frequency int) (err error) {
-	log.Consolef("-------- dry run results --------\n")
-	fmt.Println("metric header columns and values that were collected")
-	displayMetrics(stdout)
-	fmt.Println("------------")
-	errorLines := findErrors(stderr)
-
-	log.Consolef("dry-run has %d errors\n", len(errorLines))
-	if len(errorLines) > 0 {
-		for _, line := range errorLines {
-			fmt.Println(line)
+		if c.Kind == classMetric {
+			metricBytes += c.Bytes
+			metricItems += c.Items
+		} else {
+			invBytes += c.Bytes
+			invItems += c.Items
 		}
-		fmt.Println()
-		fmt.Println()
-	}
-	pCollectors := passedCollectors(log.ReadLogFile())
-	log.Consolef("Collectors report:")
-	fmt.Printf("%d successfully running collectors: %s\n", len(pCollectors), strings.Join(pCollectors, ", "))
-	fCollectors := failedCollectors(stderr)
-	fmt.Printf("%d failed collectors: %s\n", len(fCollectors), strings.Join(fCollectors, ", "))
-	bytesSum, itemsSum, err := sentDataStats(log.ReadLogFile())
-	if err != nil {
-		log.Error(err.Error())
-		return err
 	}
-	fmt.Printf("%d items (%d bytes) will be sent during one iteration\n",
-		itemsSum, bytesSum)
-
-	estimatedBytes, estimatedItems := projectDataSize(bytesSum, itemsSum, frequency)
-	fmt.Printf("%d items (%d bytes) will be sent over one hour since we have -frequency %d seconds\n",
-		estimatedItems, estimatedBytes, frequency)
-	return nil
+	report.BytesPerIteration = metricBytes + invBytes
+	report.ItemsPerIteration = metricItems + invItems
+	report.EstimatedBytesHour, report.EstimatedItemsHour = projectDataSize(metricBytes, metricItems, config.Freq)
+	report.EstimatedBytesHour += invBytes
+	report.EstimatedItemsHour += invItems
+	report.Install = d.installCommand(d.packages(missing))
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return report.ExitCode
 }
 
-func passedCollectors(output string) (pCollectors []string) {
-	//Done processing metric: cpu
-	var regex = regexp.MustCompile("Done processing metric: (.+)")
-
-	matches := regex.FindAllStringSubmatch(output, -1)
-	for _, match := range matches {
-		pCollectors = append(pCollectors, match[1])
+// dryRunCollector checks and runs the named collector, unless it is skipped. Packages of missing
+// dependencies are looked up for distro d
+func (a *Agent) dryRunCollector(name string, d distro) dryRunCollector {
+	kind, u := a.collectorByName(name)
+	res := dryRunCollector{Name: name, Kind: kind, Type: u.collectorType, Status: "ok"}
+	_, skip := a.Skipmap[name]
+	if _, skipAll := a.Skipmap["all"]; skip || skipAll {
+		res.Status = "skipped"
+		return res
 	}
-	return pCollectors
-}
-
-func failedCollectors(output string) (fCollectors []string) {
-	//Collector sysinfo.bmc.bmc-info will not run because
-	var regex = regexp.MustCompile("Collector (.+) will not run because")
-
-	matches := regex.FindAllStringSubmatch(output, -1)
-	for _, match := range matches {
-		fCollectors = append(fCollectors, match[1])
+	res.MissingDependencies = u.missingDependencies(a.ctx)
+	if len(res.MissingDependencies) > 0 {
+		res.Packages = d.packages(res.MissingDependencies)
+	}
+	if err := u.check(a); err != nil {
+		res.Status = "unavailable"
+		res.Precheck = err.Error()
+		return res
 	}
-	return fCollectors
-}
 
-func sentDataStats(output string) (bytesSum, itemsSum int, err error) {
-	var sentDataRegex = regexp.MustCompile("suppressed output:(.+)")
-	matches := sentDataRegex.FindAllStringSubmatch(output, -1)
-	for _, match := range matches {
-		bytesSum += len(match[1])
-		itemsSum++
+	var output string
+	start := time.Now()
+	if kind == classInventory {
+		inv := handleInventoryCollection(a.ctx, a.inventoryCollectors.List[name])
+		res.Duration = time.Since(start).Seconds()
+		switch {
+		case inv.Timeout:
+			res.Status = "timeout"
+		case inv.err != nil:
+			res.Status = "failed"
+			res.Error = inv.err.Error()
+		default:
+			output = string(inv.Data)
+			res.Items = 1
+		}
+	} else {
+		c := a.metricCollectors.List[name]
+		m := handleMetricCollection(a.ctx, c, c.timeout, c.frequency, a.Config.CounterMode)
+		res.Duration = time.Since(start).Seconds()
+		switch {
+		case m.Timeout:
+			res.Status = "timeout"
+		case m.Err != nil:
+			res.Status = "failed"
+			res.Error = m.Err.Error()
+		default:
+			m.NodeID = a.Config.NodeID
+			m.Frequency = a.MetricFrequency
+			output = m.Format()
+			res.Items = 1
+		}
 	}
-	return bytesSum, itemsSum, err
+	res.Bytes = len(output)
+	res.Sample = output
+	if len(output) > dryRunSampleSize {
+		res.Sample = output[:dryRunSampleSize]
+	}
+	return res
 }
 
+// projectDataSize returns the data sent in one hour, if it is sent every frequency seconds
 func projectDataSize(bytesPerIteration, itemsPerIteration, frequency int) (estimatedBytes, estimatedIterations int) {
 	if frequency <= 0 {
 		return bytesPerIteration, itemsPerIteration
@@ -164,34 +186,3 @@ func projectDataSize(bytesPerIteration, itemsPerIteration, frequency int) (estim
 	timesSent := 3600 / frequency
 	return timesSent * bytesPerIteration, timesSent * itemsPerIteration
 }
-
-func collectorData(stdout, collectorName string) (headerLine, valuesLine string) {
-	lines := strings.Split(stdout, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, ":=:header "+collectorName+" ") {
-			headerLine = line
-			continue
-		}
-		if strings.HasPrefix(line, ":=:"+collectorName+" ") {
-			valuesLine = line
-			continue
-		}
-	}
-	return headerLine, valuesLine
-}
-
-func displayMetrics(stdout string) {
-	var metricHeaders = regexp.MustCompile(`:=:header (\w+) `)
-
-	matches := metricHeaders.FindAllStringSubmatch(stdout, -1)
-	for _, match := range matches {
-		mhv := metricsCollector{
-			Collector: match[1],
-		}
-		mhv.Header, mhv.Values = collectorData(stdout, mhv.Collector)
-		fmt.Println("--------" + mhv.Collector)
-		fmt.Println(mhv.Header)
-		fmt.Println("----")
-		fmt.Println(mhv.Values)
-	}
-}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors/inventory"
+)
+
+// registry protects the collectors of metricCollectors and inventory.Collectors, which are the built-in
+// ones and those registered with RegisterMetricCollector and RegisterInventoryCollector
+var registry sync.RWMutex
+
+// CollectorOptions are the optional settings of a registered collector
+type CollectorOptions struct {
+	Precheck     collectors.CollectorPrecheck // the collector is stopped when it fails
+	Dependencies []string                     // commands looked up in PATH, the collector is stopped when one is missing
+	Derive       collectors.MetricDeriver     // rates of the counters of a metric collector, see -counter-mode
+	Type         string                       // type of the blob of an inventory collector, default inventory.all
+}
+
+// RegisterMetricCollector adds a metric collector, which agents created afterwards run like the built-in
+// ones. It is usually called from an init function
+func RegisterMetricCollector(name string, run collectors.MetricRunner, opts CollectorOptions) error {
+	if run == nil {
+		return fmt.Errorf("metric collector %s has no run function", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err := checkCollectorName(name); err != nil {
+		return err
+	}
+	metricCollectors[name] = &collectors.MetricFnWrapper{RunFn: run, PrecheckFn: opts.Precheck,
+		DeriveFn: opts.Derive, Dependencies: opts.Dependencies}
+	return nil
+}
+
+// RegisterInventoryCollector adds an inventory collector, which agents created afterwards run like the
+// built-in ones. It is usually called from an init function
+func RegisterInventoryCollector(name string, run collectors.CollectorRunner, opts CollectorOptions) error {
+	if run == nil {
+		return fmt.Errorf("inventory collector %s has no run function", name)
+	}
+	blobType := opts.Type
+	if blobType == "" {
+		blobType = "inventory.all"
+	}
+	if !strings.HasPrefix(blobType, "inventory.") || blobType == "inventory.user" {
+		return fmt.Errorf("invalid blob type %q of inventory collector %s", blobType, name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err := checkCollectorName(name); err != nil {
+		return err
+	}
+	inventory.Collectors[name] = &collectors.CollectorFnWrapper{RunFn: run, PrecheckFn: opts.Precheck,
+		Dependencies: opts.Dependencies, Type: blobType}
+	return nil
+}
+
+// checkCollectorName returns an error if name can't be registered, registry must be locked
+func checkCollectorName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n,;=/") {
+		return fmt.Errorf("invalid collector name %q", name)
+	}
+	_, isInventory := inventory.Collectors[name]
+	_, isMetric := metricCollectors[name]
+	if isInventory || isMetric || name == "all" || name == telemetryCollector {
+		return fmt.Errorf("collector %s already exists", name)
+	}
+	return nil
+}
+
+// Option is a setting of an agent created with New
+type Option func(a *Agent) error
+
+// WithConfig sets the config of the agent, NewDefaultConfig by default. The config file and the
+// -dry-run and -replay modes of the command line are not used
+func WithConfig(c *Config) Option {
+	return func(a *Agent) error {
+		if c == nil {
+			return errors.New("no config")
+		}
+		a.Config = c
+		return nil
+	}
+}
+
+// WithSink adds a destination sending data of the given classes, all without classes, to sink s.
+// Classes are inventory, metric, syslog and command. It has a queue and a spool like the destinations
+// of the config, its name identifies it in the spool, logs and the agent metrics
+func WithSink(name string, s Sink, classes ...string) Option {
+	return func(a *Agent) error {
+		d, err := newSinkDestination(name, s, classes...)
+		if err != nil {
+			return err
+		}
+		for _, other := range a.sinks {
+			if other.spec == d.spec {
+				return fmt.Errorf("sink given more than once: %s", name)
+			}
+		}
+		a.sinks = append(a.sinks, d)
+		return nil
+	}
+}
+
+// New returns an agent for embedding in another program, set up with the options and ready to Run.
+// Unlike the command line agent, it does not change the working directory of the process nor handle
+// signals. The collectors registered so far are set up with the built-in ones and the user scripts
+func New(opts ...Option) (*Agent, error) {
+	a := &Agent{Config: NewDefaultConfig()}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.Config.DryRun || a.Config.Replay != "" {
+		return nil, errors.New("dry-run and replay are only supported on the command line")
+	}
+	if err := a.Config.checkSettings(); err != nil {
+		return nil, err
+	}
+	if c := a.Config; !c.Stdout && c.Destination == "" && c.Listen == "" && len(a.sinks) == 0 {
+		return nil, errors.New("no output, give a sink, or -stdout, -destination or -listen in the config")
+	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	if err := a.configure(); err != nil {
+		a.cancel()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Run starts the collections and destinations of an agent created with New and returns when it has
+// stopped: when ctx is done, Stop is called or -duration has passed. Data still queued is delivered, or
+// spooled, before it returns. An agent runs once
+func (a *Agent) Run(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&a.ran, 0, 1) {
+		return errors.New("agent already run")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.Stop()
+		case <-stopped:
+		}
+	}()
+	Start(a)
+	close(stopped)
+	return ctx.Err()
+}
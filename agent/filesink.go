@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// rotateTimeFormat is the time of the rotation appended to the name of a rotated file
+const rotateTimeFormat = "20060102T150405Z"
+
+// rotatedSuffix matches what is appended to the name of a rotated file: the time of the rotation and
+// the sequence number of files rotated in the same second
+var rotatedSuffix = regexp.MustCompile(`^\.([0-9]{8}T[0-9]{6}Z)(?:-([0-9]+))?(?:\.gz)?$`)
+
+// fileSink writes the data of a file destination into a file, which is rotated when it reaches its
+// max size or age. Every file starts with the !nodeID message and the metric headers and metadata, so
+// that it can be sent to a server on its own. Rotated files get the time of the rotation appended to
+// their name and are optionally compressed with gzip, the oldest are removed beyond the retention count
+type fileSink struct {
+	path     string
+	maxSize  int64         // size in bytes a file is rotated at, 0 for no limit
+	maxAge   time.Duration // age a file is rotated at, 0 for no limit
+	compress bool          // rotated files are compressed with gzip
+	keep     int           // number of rotated files kept, 0 keeps all
+	hello    func() []byte // returns the first lines of every file
+	f        *os.File      // file written to, nil while closed
+	size     int64         // bytes written to f
+	lines    int           // messages written to f after the first lines
+	created  time.Time     // when f was created
+}
+
+// newFileSink returns a sink writing to file path with the rotation settings of config
+func newFileSink(path string, config *Config) *fileSink {
+	return &fileSink{
+		path:     path,
+		maxSize:  int64(config.FileMaxSize) * 1024 * 1024,
+		maxAge:   time.Duration(config.FileMaxAge) * time.Second,
+		compress: config.FileCompress,
+		keep:     config.FileKeep,
+	}
+}
+
+// Open starts a new file, a file left by an earlier run is rotated first
+func (s *fileSink) Open(hello func() []byte) error {
+	s.hello = hello
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil && fi.Size() > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	return s.create()
+}
+
+// Send writes a message as a line, after rotating the file when it is full or too old
+func (s *fileSink) Send(data []byte) error {
+	if s.f == nil {
+		return fmt.Errorf("file %s is closed", s.path)
+	}
+	full := s.maxSize > 0 && s.size+int64(len(data))+1 > s.maxSize
+	old := s.maxAge > 0 && time.Since(s.created) >= s.maxAge
+	if s.lines > 0 && (full || old) {
+		if err := s.Close(); err != nil {
+			return err
+		}
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		if err := s.create(); err != nil {
+			return err
+		}
+	}
+	line := make([]byte, len(data)+1)
+	copy(line, data)
+	line[len(data)] = '\n'
+	if err := s.write(line); err != nil {
+		return err
+	}
+	s.lines++
+	return nil
+}
+
+// Close flushes the file to disk and closes it
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f = nil
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// create creates the file and writes its first lines
+func (s *fileSink) create() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.f, s.size, s.lines, s.created = f, 0, 0, time.Now()
+	if err := s.write(s.hello()); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// write appends data to the file
+func (s *fileSink) write(data []byte) error {
+	n, err := s.f.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames the file with the time of the rotation, compresses it and removes the oldest rotated
+// files beyond the retention count
+func (s *fileSink) rotate() error {
+	at := time.Now().UTC().Format(rotateTimeFormat)
+	name := s.path + "." + at
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%s-%d", s.path, at, i)
+	}
+	if err := os.Rename(s.path, name); err != nil {
+		return err
+	}
+	log.Infof("rotated %s to %s", s.path, name)
+	if s.compress {
+		// a file which can't be compressed is kept as it is
+		if err := gzipFile(name); err != nil {
+			log.Errorf("can't compress %s: %v", name, err)
+		}
+	}
+	s.prune()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond the retention count
+func (s *fileSink) prune() {
+	if s.keep <= 0 {
+		return
+	}
+	rotated, err := listRotated(s.path)
+	if err != nil {
+		log.Errorf("can't list rotated files of %s: %v", s.path, err)
+		return
+	}
+	for len(rotated) > s.keep {
+		name := filepath.Join(filepath.Dir(s.path), rotated[0].name)
+		if err := os.Remove(name); err != nil {
+			log.Errorf("can't remove rotated file %s: %v", name, err)
+		} else {
+			log.Infof("removed rotated file %s", name)
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// rotatedFile is a rotated file of a file destination
+type rotatedFile struct {
+	name string
+	at   time.Time // time of the rotation
+	seq  int       // sequence number among the files rotated in the same second, 0 for the first
+}
+
+// listRotated returns the files rotated from file path, oldest first
+func listRotated(path string) ([]rotatedFile, error) {
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	var rotated []rotatedFile
+	for _, fi := range entries {
+		if f, ok := parseRotated(filepath.Base(path), fi.Name()); ok {
+			rotated = append(rotated, f)
+		}
+	}
+	// names don't sort by age, file-10 comes before file-2
+	sort.Slice(rotated, func(i, j int) bool {
+		if !rotated[i].at.Equal(rotated[j].at) {
+			return rotated[i].at.Before(rotated[j].at)
+		}
+		return rotated[i].seq < rotated[j].seq
+	})
+	return rotated, nil
+}
+
+// parseRotated parses the name of a file rotated from file base
+func parseRotated(base, name string) (rotatedFile, bool) {
+	if !strings.HasPrefix(name, base) {
+		return rotatedFile{}, false
+	}
+	m := rotatedSuffix.FindStringSubmatch(name[len(base):])
+	if m == nil {
+		return rotatedFile{}, false
+	}
+	at, err := time.Parse(rotateTimeFormat, m[1])
+	if err != nil {
+		return rotatedFile{}, false
+	}
+	f := rotatedFile{name: name, at: at}
+	if m[2] != "" {
+		if f.seq, err = strconv.Atoi(m[2]); err != nil {
+			return rotatedFile{}, false
+		}
+	}
+	return f, true
+}
+
+// gzipFile compresses file name into name.gz and removes it
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if serr := dst.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// exists reports if file name exists
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readRotated returns the rotated files of sink s from oldest to newest followed by its current file,
+// with their contents uncompressed
+func readRotated(t *testing.T, s *fileSink) (names, contents []string) {
+	t.Helper()
+	rotated, err := listRotated(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range append(rotated, rotatedFile{name: filepath.Base(s.path)}) {
+		data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(s.path), f.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(f.name, ".gz") {
+			gz, err := gzip.NewReader(strings.NewReader(string(data)))
+			if err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+			if data, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+		}
+		names = append(names, f.name)
+		contents = append(contents, string(data))
+	}
+	return names, contents
+}
+
+// sendAll sends the messages to the sink
+func sendAll(t *testing.T, s *fileSink, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := s.Send([]byte(msg)); err != nil {
+			t.Fatalf("Send(%q) error: %v", msg, err)
+		}
+	}
+}
+
+func hello() []byte { return []byte("!node\n") }
+
+func TestFileSinkRotatesOnSize(t *testing.T) {
+	s := &fileSink{path: filepath.Join(t.TempDir(), "data"), maxSize: 32}
+	if err := s.Open(hello); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	sendAll(t, s, "metric 1", "metric 2", "metric 3", "metric 4", "metric 5")
+	s.Close()
+
+	names, contents := readRotated(t, s)
+	want := []string{"!node\nmetric 1\nmetric 2\n", "!node\nmetric 3\nmetric 4\n", "!node\nmetric 5\n"}
+	if fmt.Sprint(contents) != fmt.Sprint(want) {
+		t.Errorf("files %v = %q, want %q", names, contents, want)
+	}
+	for _, c := range contents {
+		if len(c) > 32 {
+			t.Errorf("file %q is larger than the max size", c)
+		}
+	}
+}
+
+func TestFileSinkRotatesOnAge(t *testing.T) {
+	s := &fileSink{path: filepath.Join(t.TempDir(), "data"), maxAge: 50 * time.Millisecond}
+	if err := s.Open(hello); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	sendAll(t, s, "metric 1", "metric 2")
+	time.Sleep(60 * time.Millisecond)
+	sendAll(t, s, "metric 3")
+	s.Close()
+
+	names, contents := readRotated(t, s)
+	want := []string{"!node\nmetric 1\nmetric 2\n", "!node\nmetric 3\n"}
+	if fmt.Sprint(contents) != fmt.Sprint(want) {
+		t.Errorf("files %v = %q, want %q", names, contents, want)
+	}
+}
+
+func TestFileSinkCompresses(t *testing.T) {
+	s := &fileSink{path: filepath.Join(t.TempDir(), "data"), maxSize: 20, compress: true}
+	if err := s.Open(hello); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	sendAll(t, s, "metric 1", "metric 2", "metric 3")
+	s.Close()
+
+	names, contents := readRotated(t, s)
+	want := []string{"!node\nmetric 1\n", "!node\nmetric 2\n", "!node\nmetric 3\n"}
+	if fmt.Sprint(contents) != fmt.Sprint(want) {
+		t.Errorf("files %v = %q, want %q", names, contents, want)
+	}
+	for _, name := range names[:len(names)-1] {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("rotated file %s is not compressed", name)
+		}
+	}
+
+	// a file left by an earlier run is rotated when opened again
+	if err := s.Open(hello); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	s.Close()
+	if names, _ := readRotated(t, s); len(names) != 4 {
+		t.Errorf("files after reopen = %v, want 4", names)
+	}
+}
+
+func TestFileSinkKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	s := &fileSink{path: filepath.Join(dir, "data"), keep: 3}
+	// same second rotations sort by sequence number, not by name
+	for _, name := range []string{
+		"data.20240101T000000Z-10.gz",
+		"data.20240101T000000Z",
+		"data.20240101T000000Z-2.gz",
+		"data.20240101T000000Z-1",
+		"data.20231231T235959Z-11",
+		"data.20240101T000001Z",
+		"data.old",
+		"other.20200101T000000Z",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.prune()
+
+	var left []string
+	entries, _ := ioutil.ReadDir(dir)
+	for _, fi := range entries {
+		left = append(left, fi.Name())
+	}
+	want := []string{"data.20240101T000000Z-10.gz", "data.20240101T000000Z-2.gz", "data.20240101T000001Z",
+		"data.old", "other.20200101T000000Z"}
+	if fmt.Sprint(left) != fmt.Sprint(want) {
+		t.Errorf("files after prune = %v, want %v", left, want)
+	}
+}
+
+func TestFileSinkRotationWithDerivedMetrics(t *testing.T) {
+	a := newTestAgent()
+	c := a.metricCollectors.List["cpu"]
+	now := time.Now()
+	c.deriveCounters(counterResult("100", now), counterModeRate)
+	derived := c.deriveCounters(counterResult("300", now.Add(2*time.Second)), counterModeRate)
+	a.setOneMetadata("cpu"+derived[0].Sufix, "unit", "1/s", false)
+
+	s := &fileSink{path: filepath.Join(t.TempDir(), "data"), maxSize: 128, keep: 2}
+	first := string(a.initialSendData(&Destination{}))
+	if !strings.Contains(first, ":=:metadata cpu-rate node 10 unit 1/s\n") {
+		t.Fatalf("initialSendData() = %q, want the metadata of cpu-rate", first)
+	}
+	if err := s.Open(func() []byte { return a.initialSendData(&Destination{}) }); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		sendAll(t, s, fmt.Sprintf("cpu-rate %d", i))
+	}
+	s.Close()
+
+	names, contents := readRotated(t, s)
+	if len(names) != 3 {
+		t.Errorf("files = %v, want 2 rotated and the current one", names)
+	}
+	for i, c := range contents {
+		if !strings.HasPrefix(c, first) || !strings.HasPrefix(c[len(first):], "cpu-rate ") {
+			t.Errorf("file %s = %q, want it to start with %q followed by data", names[i], c, first)
+		}
+	}
+}
+
+func TestParseRotated(t *testing.T) {
+	for name, want := range map[string]bool{
+		"data.20240101T000000Z":        true,
+		"data.20240101T000000Z-3":      true,
+		"data.20240101T000000Z-3.gz":   true,
+		"data.20240101T000000Z.gz":     true,
+		"data.20241301T000000Z":        false,
+		"data.20240101T000000Z.gz.tmp": false,
+		"data":                         false,
+		"data2.20240101T000000Z":       false,
+	} {
+		if _, ok := parseRotated("data", name); ok != want {
+			t.Errorf("parseRotated(%q) = %v, want %v", name, ok, want)
+		}
+	}
+	if f, _ := parseRotated("data", "data.20240101T000000Z-12.gz"); f.seq != 12 {
+		t.Errorf("parseRotated() sequence = %d, want 12", f.seq)
+	}
+}
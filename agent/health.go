@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// health of a collector. A collector is degraded after a failed collection, and quarantined when it
+// reached the error or timeout limit. A quarantined collector is retried with exponential backoff
+const (
+	healthy     = "healthy"
+	degraded    = "degraded"
+	quarantined = "quarantined"
+)
+
+// collectionFailed counts a failed collection of collector u and degrades or quarantines it
+func (a *Agent) collectionFailed(u *BaseCollector, timeout bool) {
+	u.healthMtx.Lock()
+	if timeout {
+		u.numTimeout++
+	} else {
+		u.numErrs++
+	}
+
+	health := degraded
+	switch {
+	case u.health == quarantined:
+		// the retry failed, wait longer for the next one
+		u.backoff *= 2
+		if u.backoff > maxRetryBackoff {
+			u.backoff = maxRetryBackoff
+		}
+		u.retryAt = time.Now().Add(u.backoff)
+		health = quarantined
+		log.Infof("Collector %s is still failing and will be retried in %v.", u.name, u.backoff)
+	case u.numErrs >= a.ErrorLimit || u.numTimeout >= a.TimeoutLimit:
+		u.backoff = retryBackoff
+		u.retryAt = time.Now().Add(u.backoff)
+		health = quarantined
+		log.Infof("Collector %s has reached max number of errors or timeouts and will be retried in %v.", u.name, u.backoff)
+	}
+	previous := u.setHealth(health)
+	u.healthMtx.Unlock()
+	a.reportHealth(u, previous, health)
+}
+
+// resetHealth resets the failure counters of collector u and makes it healthy, after a successful
+// collection or when the collector is rescheduled
+func (a *Agent) resetHealth(u *BaseCollector) {
+	u.healthMtx.Lock()
+	u.numTimeout = 0
+	u.numErrs = 0
+	u.backoff = 0
+	previous := u.setHealth(healthy)
+	u.healthMtx.Unlock()
+	a.reportHealth(u, previous, healthy)
+}
+
+// due returns true if the collector is not quarantined, or its next retry is due
+func (u *BaseCollector) due(now time.Time) bool {
+	u.healthMtx.Lock()
+	defer u.healthMtx.Unlock()
+	return u.health != quarantined || !now.Before(u.retryAt)
+}
+
+// isQuarantined returns true if the collector is quarantined
+func (u *BaseCollector) isQuarantined() bool {
+	u.healthMtx.Lock()
+	defer u.healthMtx.Unlock()
+	return u.health == quarantined
+}
+
+// setLastRun records the start of a collection
+func (u *BaseCollector) setLastRun(start time.Time) {
+	u.healthMtx.Lock()
+	u.lastRun = start
+	u.healthMtx.Unlock()
+}
+
+// setHealth changes the health of the collector and returns the previous one, healthMtx must be locked
+func (u *BaseCollector) setHealth(health string) string {
+	previous := u.health
+	if previous == "" {
+		previous = healthy
+	}
+	u.health = health
+	return previous
+}
+
+// reportHealth reports a change of the health of collector u to the destinations as syslog
+// message "CollectorHealth <collector> <nodeID> <previous> <health>"
+func (a *Agent) reportHealth(u *BaseCollector, previous, health string) {
+	if previous == health {
+		return
+	}
+	log.Infof("collector %s changed from %s to %s", u.name, previous, health)
+
+	severity := syslogSeverityNotice
+	switch health {
+	case degraded:
+		severity = syslogSeverityWarning
+	case quarantined:
+		severity = syslogSeverityAlert
+	}
+	s := Syslog{
+		Tag:       "hds-agent",
+		Hostname:  a.hostname,
+		Facility:  syslogFacilityUser,
+		Severity:  severity,
+		Timestamp: collectors.Now(collectors.WithCollector(a.ctx, u.name)),
+		Message:   fmt.Sprintf("CollectorHealth %s %s %s %s", u.name, a.config().NodeID, previous, health),
+	}
+	a.NonBlockingSend(classSyslog, s.formatBytes())
+}
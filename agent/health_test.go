@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthDuringStatus(t *testing.T) {
+	a := newTestAgent()
+	a.ctx = context.Background()
+	a.ErrorLimit, a.TimeoutLimit = 2, 2
+	c := a.metricCollectors.List["cpu"]
+
+	// run with -race, collections change the health while status requests read it
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.collectionFailed(&c.BaseCollector, i%2 == 0)
+			if i%10 == 0 {
+				a.resetHealth(&c.BaseCollector)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.status()
+			c.due(time.Now())
+		}
+	}()
+	wg.Wait()
+
+	a.resetHealth(&c.BaseCollector)
+	for i := 0; i < 2; i++ {
+		a.collectionFailed(&c.BaseCollector, false)
+	}
+	for _, s := range a.status().Collectors {
+		if s.Name == "cpu" && (s.Health != quarantined || s.NumErrs != 2 || s.RetryAt == 0) {
+			t.Errorf("status of cpu = %+v, want quarantined after 2 errors", s)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// errHTTPRejected is returned when the ingest endpoint refused a batch with a status that must not be retried
+type errHTTPRejected struct {
+	status string
+}
+
+func (e errHTTPRejected) Error() string {
+	return fmt.Sprintf("batch rejected by server: %s", e.status)
+}
+
+// connectHTTP reads data queued for the destination and POSTs it in batches to an http or https destination.
+// A batch is flushed once it holds batchSize messages or when flushInterval elapses
+func (a *Agent) connectHTTP(ds *Destination) {
+	var (
+		batch      [][]byte
+		held       [][]byte // read from the queue while a post waits to be retried
+		retryTimer <-chan time.Time
+		replayc    <-chan struct{}
+		source     = ds.sendCh
+		msgc       = source
+		needInit   = true
+		sp         = ds.spool
+		retries    = ds.retries
+	)
+
+	client := &http.Client{
+		Timeout:   httpTimeout,
+		Transport: &http.Transport{TLSClientConfig: ds.tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	flushTicker := time.NewTicker(ds.flushInterval)
+	defer flushTicker.Stop()
+
+	// sleep waits before a post is retried, reading the queue meanwhile so that the data sent to it is not
+	// dropped. Up to a batch of messages is kept. It returns false when the destination is stopped
+	sleep := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			queue := source
+			if len(held) >= ds.batchSize {
+				queue = nil
+			}
+			select {
+			case <-timer.C:
+				return true
+			case <-ds.quit:
+				return false
+			case data := <-queue:
+				held = append(held, data)
+			}
+		}
+	}
+
+	// post sends messages, on failure it schedules a retry after WaitTime
+	post := func(msgs [][]byte) error {
+		body := bytes.Join(msgs, []byte{'\n'})
+		if len(body) > 0 {
+			body = append(body, '\n')
+		}
+		if needInit {
+			// the endpoint is stateless, so !nodeID and headers go in front of the first batch
+			// and again after every outage
+			body = append(a.initialSendData(ds), body...)
+		}
+
+		err := a.postBatch(client, ds, body, retries, sleep)
+		if _, rejected := err.(errHTTPRejected); err != nil && !rejected {
+			log.Errorf("can't send batch of %d messages to %s: %v", len(msgs), ds.dst, err)
+			wait := a.waitTime()
+			log.Errorf("attempting to resend in %0.f seconds", wait.Seconds())
+			a.countDestination(ds, statReconnects, 1)
+			needInit = true
+			replayc = nil
+			retryTimer = time.After(wait)
+			atomic.StoreInt32(&ds.connected, 0)
+			return err
+		} else if rejected {
+			log.Errorf("dropping batch of %d messages: %v", len(msgs), err)
+			a.countDestination(ds, statDrops, len(msgs))
+		}
+		needInit = false
+		atomic.StoreInt32(&ds.connected, 1)
+		return nil
+	}
+
+	flush := func() {
+		if len(batch) == 0 && !needInit {
+			return
+		}
+		if err := post(batch); err != nil {
+			if sp == nil {
+				// keep the batch and stop reading new data until the endpoint is back
+				msgc = nil
+				return
+			}
+			for _, data := range batch {
+				a.spoolData(ds, data)
+			}
+		}
+		batch = nil
+	}
+
+	// replay posts a batch of spooled data, it reports if there is more to send
+	replay := func() bool {
+		records, err := sp.Peek(ds.batchSize)
+		if err != nil {
+			log.Errorf("can't replay spooled data: %v", err)
+			return false
+		}
+		if len(records) == 0 {
+			log.Infof("spooled data replayed to %s", ds.dst)
+			return false
+		}
+		if err := post(records); err != nil {
+			return false
+		}
+		if err := sp.Commit(len(records)); err != nil {
+			log.Errorf("can't update spool: %v", err)
+		}
+		return true
+	}
+
+	// receive batches data read from the queue, or spools it after the data already spooled
+	receive := func(data []byte) {
+		if sp != nil && (retryTimer != nil || !sp.Empty()) {
+			// keep order, new data goes after what is already spooled
+			a.spoolData(ds, data)
+			if retryTimer == nil {
+				replayc = readyCh
+			}
+			return
+		}
+		batch = append(batch, data)
+		if len(batch) >= ds.batchSize && retryTimer == nil {
+			flush()
+		}
+	}
+
+	log.Infof("sending to %s in batches of %d messages every %0.f seconds", ds.dst, ds.batchSize, ds.flushInterval.Seconds())
+
+	// Send the inventory
+	a.sendInventory(ds)
+
+	// Replay data spooled before a restart
+	if sp != nil && !sp.Empty() {
+		log.Infof("replaying %d bytes of spooled data to %s", sp.Len(), ds.dst)
+		replayc = readyCh
+	}
+
+	for {
+		select {
+		case <-ds.quit:
+			// deliver what is still queued within the deadline, the rest goes to the spool
+			client.Timeout = shutdownTimeout
+			retries = 0
+			batch = append(batch, drainQueue(ds.sendCh)...)
+			if retryTimer == nil {
+				deadline := time.Now().Add(shutdownTimeout)
+				if sp != nil && !sp.Empty() {
+					for _, data := range batch {
+						a.spoolData(ds, data)
+					}
+					batch = nil
+					for replay() && time.Now().Before(deadline) {
+					}
+				} else if len(batch) > 0 && post(batch) == nil {
+					batch = nil
+				}
+			}
+			a.closeDestination(ds, batch)
+			return
+
+		case <-flushTicker.C:
+			if retryTimer == nil {
+				flush()
+			}
+
+		case <-retryTimer:
+			retryTimer = nil
+			msgc = source
+			flush()
+			if retryTimer == nil && sp != nil && !sp.Empty() {
+				replayc = readyCh
+			}
+
+		case <-replayc:
+			if !replay() {
+				replayc = nil
+			}
+
+		case data := <-msgc:
+			receive(data)
+		}
+
+		// data read while retrying comes after the data which was posted
+		for len(held) > 0 {
+			data := held[0]
+			held = held[1:]
+			receive(data)
+		}
+	}
+}
+
+// postBatch POSTs gzipped body to the destination, retrying on 5xx, 429 and transport errors with exponential
+// backoff. It waits for the retries with sleep, which returns false when the destination is stopped
+func (a *Agent) postBatch(client *http.Client, ds *Destination, body []byte, retries int, sleep func(time.Duration) bool) error {
+	var gzBody bytes.Buffer
+	gz := gzip.NewWriter(&gzBody)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	backoff := httpRetryBackoff
+	for attempt := 0; ; attempt++ {
+		wait, err := a.postOnce(client, ds, gzBody.Bytes())
+		if err == nil {
+			log.Infof("sent %d bytes (%d gzipped) to %s", len(body), gzBody.Len(), ds.dst)
+			a.countSent(ds, body)
+			return nil
+		}
+		if _, rejected := err.(errHTTPRejected); rejected || attempt >= retries {
+			return err
+		}
+
+		if wait <= 0 {
+			wait = backoff
+			if backoff *= 2; backoff > httpMaxRetryBackoff {
+				backoff = httpMaxRetryBackoff
+			}
+		}
+		log.Infof("post to %s failed: %v, retry %d/%d in %0.f seconds", ds.dst, err, attempt+1, retries, wait.Seconds())
+		if !sleep(wait) {
+			// stopping, the batch goes to the spool
+			return err
+		}
+	}
+}
+
+// postOnce makes a single POST request. It returns the wait time requested by the server through
+// Retry-After, if any, capped at httpMaxRetryBackoff, and an error for any non-2xx response
+func (a *Agent) postOnce(client *http.Client, ds *Destination, gzBody []byte) (time.Duration, error) {
+	req, err := http.NewRequest("POST", ds.dst, bytes.NewReader(gzBody))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-HDS-Node-ID", a.config().NodeID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, httpMaxResponseSize))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// the response may carry commands for the agent
+		if cmds := bytes.TrimSpace(respBody); len(cmds) > 0 {
+			log.Infof("received data: %s", string(cmds))
+			if ok := a.processCommands(cmds); !ok {
+				log.Error("error when processing commands from http response")
+			}
+		}
+		return 0, nil
+
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		var wait time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = httpMaxRetryBackoff
+			if secs < int(httpMaxRetryBackoff/time.Second) {
+				wait = time.Duration(secs) * time.Second
+			}
+		}
+		return wait, fmt.Errorf("server responded %s", resp.Status)
+
+	default:
+		return 0, errHTTPRejected{status: resp.Status}
+	}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPKeepsDataWhileRetrying(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		posts    int
+		received []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if posts++; posts == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("invalid body: %v", err)
+			return
+		}
+		body, _ := ioutil.ReadAll(gz)
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(line, "metric") {
+				received = append(received, line)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	a := &Agent{Config: &Config{NodeID: "node"}, ctx: context.Background()}
+	a.metricHeaders.Map = make(map[string]string)
+	a.metricMetadata.Map = make(map[string]map[string]string)
+	d, err := parseDest(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.batchSize, d.flushInterval, d.retries = 3, 100*time.Millisecond, 1
+	d.sendCh = make(chan []byte, 1)
+	d.quit, d.done = make(chan struct{}), make(chan struct{})
+	go a.connectHTTP(d)
+
+	// the first batch is retried after a second, the data sent meanwhile, up to a batch and what fits
+	// in the queue, must not be dropped
+	for _, data := range []string{"metric 1", "metric 2", "metric 3", "metric 4", "metric 5"} {
+		a.sendTo(d, []byte(data))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	want := "metric 1,metric 2,metric 3,metric 4,metric 5"
+	var got string
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+		mu.Lock()
+		got = strings.Join(received, ",")
+		mu.Unlock()
+		if got == want {
+			break
+		}
+	}
+	if got != want {
+		t.Errorf("server received %q, want %q", got, want)
+	}
+	close(d.quit)
+	<-d.done
+}
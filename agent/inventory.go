@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -8,74 +9,124 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
 	"github.com/Ericsson/ericsson-hds-agent/agent/log"
 )
 
-func handleInventoryCollection(c *InventoryCollector) Inventory {
+func handleInventoryCollection(ctx context.Context, c *InventoryCollector) Inventory {
 	resCh := make(chan []byte, 1)
 	errCh := make(chan error, 1)
+
+	// commands of the collector are killed when it times out
+	runCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	runCtx, killed := collectors.WithKillCount(runCtx)
+	runCtx = collectors.WithCollector(runCtx, c.name)
 	go func() {
-		res, err := c.collect()
+		res, err := c.collect(runCtx)
 		if err != nil {
 			errCh <- err
 			return
 		}
 		resCh <- res
 	}()
-	blobtype := "unknown"
-	switch c.collectorType {
-	case userScript:
-		blobtype = "inventory.user"
-	case builtIn:
-		blobtype = "inventory.all"
-	default:
-		blobtype = c.collectorType
-	}
-	inv := Inventory{Name: c.name, Type: blobtype}
+	inv := Inventory{Name: c.name, Type: c.blobType()}
 	select {
 	case err := <-errCh:
 		inv.err = err
+		inv.Timeout = killed() > 0
 	case data := <-resCh:
-		inv.Data = json.RawMessage(data)
-	case <-time.After(c.timeout):
+		// results of a collector whose commands were killed are incomplete
+		inv.Timeout = killed() > 0
+		if !inv.Timeout {
+			inv.Data = json.RawMessage(data)
+		}
+	case <-time.After(c.timeout + killGrace):
+		// the collector is stuck in something else than a command
 		inv.Timeout = true
+	case <-ctx.Done():
+		inv.err = ctx.Err()
+	}
+	if inv.Timeout {
+		inv.err = nil
 	}
 	return inv
 }
 
-func (a *Agent) runInvCollectors(sha1cache map[string]string, cType string, cNames []string, forceRun bool) error {
+// blobType returns type of the blob the collector output is sent in
+func (c *InventoryCollector) blobType() string {
+	switch c.collectorType {
+	case userScript:
+		return "inventory.user"
+	case builtIn:
+		return "inventory.all"
+	default:
+		return c.collectorType
+	}
+}
+
+// inventoryKey returns key of collector output in the blob
+func inventoryKey(name, blobType string) string {
+	// Avoid consolidating the sysinfo.package sub-keys because the system
+	// could have more than one package manager installed
+	if strings.HasPrefix(name, "sysinfo.package") {
+		return name
+	} else if nameparts := strings.Split(name, "."); blobType == "inventory.all" && len(nameparts) == 3 {
+		return nameparts[0] + "." + nameparts[2]
+	}
+	return name
+}
+
+// runInvCollectors runs given inventory collectors, all of them if cNames is empty, and sends the blobs.
+// Blobs equal to those last sent with sha1cache are not sent, a nil cache is an empty one which is not
+// used again
+func (a *Agent) runInvCollectors(sha1cache map[string]string, cNames []string, forceRun bool) error {
 	results := make([]Inventory, 0)
 	var keys []string
 
+	a.collecting.RLock()
+	defer a.collecting.RUnlock()
+	// runs requested with RunCollector and RefreshInventory wait for a scheduled one and the other way around
+	a.inventoryRun.Lock()
+	defer a.inventoryRun.Unlock()
+	if a.ctx.Err() != nil {
+		// agent is stopping
+		return nil
+	}
+
 	a.inventoryCollectors.RLock()
-	for k, col := range a.inventoryCollectors.List {
-		switch {
-		case len(cNames) > 0 && contains(cNames, k):
-			keys = append(keys, k)
-		case cType == "":
-			keys = append(keys, k)
-		case cType == col.collectorType:
+	for k := range a.inventoryCollectors.List {
+		if len(cNames) == 0 || contains(cNames, k) {
 			keys = append(keys, k)
 		}
 	}
 
 	sort.Strings(keys)
+	now := time.Now()
 
 	for _, k := range keys {
 		collector := a.inventoryCollectors.List[k]
-		if forceRun || collector.state == runningState {
-			inv := handleInventoryCollection(collector)
-			if len(cNames) > 0 {
-				inv.Type = cType
-			}
+		if forceRun || collector.state == runningState && collector.due(now) {
+			start := time.Now()
+			collector.setLastRun(start)
+			inv := handleInventoryCollection(a.ctx, collector)
+			a.countCollection(k, time.Since(start), inv.err != nil, inv.Timeout)
 			results = append(results, inv)
 		}
 	}
 	a.inventoryCollectors.RUnlock()
+	if a.ctx.Err() != nil {
+		log.Info("inventory collection cancelled")
+		return nil
+	}
+	a.recordInventory(results, sha1cache != nil)
+	if sha1cache == nil {
+		sha1cache = make(map[string]string)
+	}
 	return a.ProcessInv(sha1cache, results)
 }
 
-// ProcessInv collects, formats, and sends inventory.
+// ProcessInv collects, formats, and sends inventory. It is called by runInvCollectors, which serializes it
 func (a *Agent) ProcessInv(sha1cache map[string]string, inventoryResults []Inventory) error {
 	log.Info("Processing inventory set")
 	types := make(map[string]map[string]*json.RawMessage)
@@ -84,42 +135,23 @@ func (a *Agent) ProcessInv(sha1cache map[string]string, inventoryResults []Inven
 	defer a.inventoryCollectors.RUnlock()
 	for _, inventory := range inventoryResults {
 		invCol := a.inventoryCollectors.List[inventory.Name]
-		var inventoryKey string
-
-		// Avoid consolidating the sysinfo.package sub-keys because the system
-		// could have more than one package manager installed
-		if strings.HasPrefix(inventory.Name, "sysinfo.package") {
-			inventoryKey = inventory.Name
-		} else if nameparts := strings.Split(inventory.Name, "."); inventory.Type == "inventory.all" && len(nameparts) == 3 {
-			inventoryKey = nameparts[0] + "." + nameparts[2]
-		} else {
-			inventoryKey = inventory.Name
-		}
+		inventoryKey := inventoryKey(inventory.Name, inventory.Type)
 
 		// Was it a timeout or failure?
 		switch {
 		// Error while collecting
 		case inventory.err != nil:
 			log.Errorf("Error collecting inventory %v: %v.", inventory.Name, inventory.err)
-			invCol.numErrs++
-			if invCol.numErrs >= a.ErrorLimit {
-				log.Infof("Inventory Collector %v has reached max number of errors and will not be collected.", inventory.Name)
-				invCol.state = stopState
-			}
+			a.collectionFailed(&invCol.BaseCollector, false)
 
 		// Timeout while collecting
 		case inventory.Timeout:
 			log.Errorf("Timeout when collecting inventory %v.", inventory.Name)
-			invCol.numTimeout++
-			if invCol.numTimeout >= a.TimeoutLimit {
-				log.Infof("Inventory Collector %v has reached max number of timeouts and will not be collected.", inventory.Name)
-				invCol.state = stopState
-			}
+			a.collectionFailed(&invCol.BaseCollector, true)
 
 		default:
-			//Reset Error and Timeout Counters
-			invCol.numTimeout = 0
-			invCol.numErrs = 0
+			a.resetHealth(&invCol.BaseCollector)
+			invCol.last = inventory.Data
 
 			if types[inventory.Type] == nil {
 				types[inventory.Type] = make(map[string]*json.RawMessage)
@@ -136,7 +168,28 @@ func (a *Agent) ProcessInv(sha1cache map[string]string, inventoryResults []Inven
 		return fmt.Errorf("none of the specified collectors cannot be processed, for additional info refer to the agent's log")
 	}
 
-	for key, value := range types {
+	// collectors run at their own frequencies, a blob gets the latest output of the ones which didn't run now
+	for name, invCol := range a.inventoryCollectors.List {
+		blobType := invCol.blobType()
+		if types[blobType] == nil || invCol.last == nil || invCol.state != runningState || invCol.isQuarantined() {
+			continue
+		}
+		if _, ok := types[blobType][inventoryKey(name, blobType)]; !ok {
+			rjsonRaw := new(json.RawMessage)
+			*rjsonRaw = invCol.last
+			types[blobType][inventoryKey(name, blobType)] = rjsonRaw
+		}
+	}
+
+	// blobs are sent in the order of their types, which gives them the same IDs when replayed
+	blobTypes := make([]string, 0, len(types))
+	for key := range types {
+		blobTypes = append(blobTypes, key)
+	}
+	sort.Strings(blobTypes)
+	now := collectors.Now(a.ctx)
+	for _, key := range blobTypes {
+		value := types[key]
 		if len(value) == 0 {
 			continue
 		}
@@ -153,40 +206,121 @@ func (a *Agent) ProcessInv(sha1cache map[string]string, inventoryResults []Inven
 			continue
 		}
 		sha1cache[key] = sha1
-		timestamp := fmt.Sprintf("%d", time.Now().Unix())
-		blob := Blob{Type: key, NodeID: a.Config.NodeID, ID: a.ID, Content: final, Digest: sha1, Timestamp: timestamp}
+		timestamp := fmt.Sprintf("%d", now.Unix())
+		blob := Blob{Type: key, NodeID: a.config().NodeID, ID: a.ID, Content: final, Digest: sha1, Timestamp: timestamp}
 		a.ID++
-		a.NonBlockingSend(blob.Format())
+		data := blob.Format()
+		a.lastInventory.Lock()
+		a.lastInventory.Map[key] = data
+		a.lastInventory.Unlock()
+		a.NonBlockingSend(classInventory, data)
 	}
 	return nil
 }
 
-// scheduleInventory collects output from inventory collectors
+// scheduleInventory runs inventory collectors at their frequencies, collectors due at the same time run together
 func (a *Agent) scheduleInventory() {
-	var invTicker = &time.Ticker{}
 	sha1cache := make(map[string]string)
+	freqs := make(map[string]time.Duration)
+	next := make(map[string]time.Time)
 
 	log.Infof("starting Inventory collector")
 
-	if a.InvFrequency > 0 {
-		invTicker = time.NewTicker(a.InvFrequency)
-	} else {
-		log.Infof("running inventory collector once (frequency <= 0)")
-	}
-
 	go func() {
+		a.rescheduleInventory(freqs, next, time.Now())
 		for {
+			var timer <-chan time.Time
+			if first, ok := earliest(next); ok {
+				timer = time.After(time.Until(first))
+			}
+
 			select {
-			case <-invTicker.C:
+			case now := <-timer:
+				var due []string
+				for name, t := range next {
+					if !t.After(now) {
+						due = append(due, name)
+						next[name] = now.Add(freqs[name])
+					}
+				}
 				// run inventory collector now
-				a.runInvCollectors(sha1cache, "", nil, false)
+				a.runInvCollectors(sha1cache, due, false)
+
+			case <-a.invScheduleCh:
+				a.rescheduleInventory(freqs, next, time.Now())
+
+			case <-a.ctx.Done():
+				return
 			}
 		}
 	}()
 }
 
-func (a *Agent) sendInventory() error {
-	sha1cache := make(map[string]string)
-	a.runInvCollectors(sha1cache, "", nil, false)
+// rescheduleInventory updates next run time of inventory collectors which were added or whose frequency changed
+func (a *Agent) rescheduleInventory(freqs map[string]time.Duration, next map[string]time.Time, now time.Time) {
+	a.inventoryCollectors.RLock()
+	defer a.inventoryCollectors.RUnlock()
+	for name := range freqs {
+		if _, ok := a.inventoryCollectors.List[name]; !ok {
+			delete(freqs, name)
+			delete(next, name)
+		}
+	}
+	for name, col := range a.inventoryCollectors.List {
+		if freq, ok := freqs[name]; ok && freq == col.frequency {
+			continue
+		}
+		freqs[name] = col.frequency
+		delete(next, name)
+		if col.frequency > 0 {
+			log.Infof("inventory collector '%s' runs every %v", name, col.frequency)
+			next[name] = now.Add(col.frequency)
+		}
+	}
+}
+
+// signalInventorySchedule makes the inventory schedule pick up changed collectors
+func (a *Agent) signalInventorySchedule() {
+	select {
+	case a.invScheduleCh <- struct{}{}:
+	default:
+	}
+}
+
+// earliest returns the earliest of the times
+func earliest(times map[string]time.Time) (first time.Time, ok bool) {
+	for _, t := range times {
+		if !ok || t.Before(first) {
+			first, ok = t, true
+		}
+	}
+	return first, ok
+}
+
+// sendInventory sends the inventory to a destination which has just connected. The first connection
+// of any destination collects the inventory for all of them, later connections get the latest inventory
+func (a *Agent) sendInventory(ds *Destination) error {
+	a.initialInventory.Do(func() {
+		a.runInvCollectors(nil, nil, false)
+
+		a.destinationsMtx.RLock()
+		for _, d := range a.Destinations {
+			d.inventorySent = true
+		}
+		a.destinationsMtx.RUnlock()
+	})
+	if ds.inventorySent {
+		ds.inventorySent = false
+		return nil
+	}
+	if !ds.accepts(classInventory) {
+		return nil
+	}
+
+	a.lastInventory.RLock()
+	defer a.lastInventory.RUnlock()
+	for _, data := range a.lastInventory.Map {
+		a.sendTo(ds, data)
+	}
 	return nil
 }
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentInventoryRefresh(t *testing.T) {
+	a := newTestAgent()
+	a.ctx = context.Background()
+	a.lastInventory.Map = make(map[string][]byte)
+	a.inventoryCollectors.List = make(map[string]*InventoryCollector)
+	for _, name := range []string{"cpu", "memory"} {
+		a.inventoryCollectors.List[name] = &InventoryCollector{
+			BaseCollector: BaseCollector{name: name, collectorType: builtIn, state: runningState, timeout: time.Minute},
+			collect: func(ctx context.Context) ([]byte, error) {
+				return []byte(`{"value":1}`), nil
+			},
+		}
+	}
+	d := &Destination{dst: "test", sendCh: make(chan []byte, 100)}
+	a.Destinations = []*Destination{d}
+
+	// run with -race, RefreshInventory and RunCollector start runs next to the scheduled one
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.runInvCollectors(nil, nil, true)
+		}()
+	}
+	wg.Wait()
+
+	ids := make(map[int]bool)
+	for _, data := range drainQueue(d.sendCh) {
+		var blob Blob
+		if err := json.Unmarshal(data, &blob); err != nil {
+			t.Fatalf("invalid blob %q: %v", data, err)
+		}
+		if ids[blob.ID] {
+			t.Errorf("blob ID %d sent more than once", blob.ID)
+		}
+		ids[blob.ID] = true
+	}
+	if len(ids) != 4 {
+		t.Errorf("%d blobs sent, want 4", len(ids))
+	}
+}
@@ -1,8 +1,10 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 	"unicode"
@@ -22,15 +24,15 @@ import (
 )
 
 var metricCollectors = map[string]*collectors.MetricFnWrapper{
-	"disk":      &collectors.MetricFnWrapper{RunFn: disk.Run},
-	"cpu":       &collectors.MetricFnWrapper{RunFn: cpu.Run},
+	"disk":      &collectors.MetricFnWrapper{RunFn: disk.Run, DeriveFn: disk.Rates},
+	"cpu":       &collectors.MetricFnWrapper{RunFn: cpu.Run, DeriveFn: cpu.Rates},
 	"load":      &collectors.MetricFnWrapper{RunFn: load.Run},
 	"memory":    &collectors.MetricFnWrapper{RunFn: memory.Run},
-	"net":       &collectors.MetricFnWrapper{RunFn: net.Run},
+	"net":       &collectors.MetricFnWrapper{RunFn: net.Run, DeriveFn: net.Rates},
 	"uptime":    &collectors.MetricFnWrapper{RunFn: uptime.Run},
 	"diskusage": &collectors.MetricFnWrapper{RunFn: diskusage.Run},
-	"smart":     &collectors.MetricFnWrapper{RunFn: smart.Run, PrecheckFn: smart.Precheck},
-	"sensor":    &collectors.MetricFnWrapper{RunFn: sensor.IpmiSensorRun, PrecheckFn: sensor.IpmiSensorPrecheck},
+	"smart":     &collectors.MetricFnWrapper{RunFn: smart.Run, PrecheckFn: smart.Precheck, Dependencies: []string{"smartctl"}},
+	"sensor":    &collectors.MetricFnWrapper{RunFn: sensor.IpmiSensorRun, PrecheckFn: sensor.IpmiSensorPrecheck, Dependencies: []string{"ipmitool", "dmidecode"}},
 }
 
 // HeaderStrings returns the formatted string iof a metric header
@@ -39,7 +41,7 @@ func (m *metric) HeaderStrings() []string {
 
 	for _, mr := range m.Data {
 		hname := m.Name + mr.Sufix
-		result = append(result, m.formatHeaderString(hname, mr.Header))
+		result = append(result, m.formatHeaderString(hname, mr.header))
 	}
 	return result
 }
@@ -65,38 +67,49 @@ func (m *metric) Format() (result string) {
 
 	for _, v := range m.Data {
 		mname := m.Name + v.Sufix
-		if len(v.Header) == 0 {
+		if len(v.header) == 0 {
 			continue
 		}
 		if v.isNeedSendHeader {
-			result += m.formatHeaderString(mname, v.Header) + "\n"
-			for name, val := range v.Metadata {
-				result += formatMetadataString(mname, m.NodeID, m.Frequency, name, val) + "\n"
+			result += m.formatHeaderString(mname, v.header) + "\n"
+			// in the order of the names, so that a replay sends the same bytes
+			for _, name := range sortedKeys(v.metadata) {
+				result += formatMetadataString(mname, m.NodeID, m.Frequency, name, v.metadata[name]) + "\n"
 			}
 		}
-		result += m.formatDatastring(mname, v.Data) + "\n"
+		result += m.formatDatastring(mname, v.data) + "\n"
 
 	}
 	result = strings.TrimSuffix(result, "\n")
 	return
 }
 
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Collects, formats, and sends metrics
 func (a *Agent) processMetric(metric metric, c *MetricCollector) error {
-	metric.NodeID = a.Config.NodeID
+	metric.NodeID = a.config().NodeID
 
 	a.metricHeaders.Lock()
 	headers := metric.HeaderStrings()
 	for i := range metric.Data {
 		v := metric.Data[i]
-		if len(v.Header) == 0 {
+		if len(v.header) == 0 {
 			continue
 		}
 		v.isNeedSendHeader = !strings.HasSuffix(a.metricHeaders.Map[metric.Name+v.Sufix],
-			"#timestamp "+v.Header)
+			"#timestamp "+v.header)
 		if v.isNeedSendHeader {
 			a.metricHeaders.Map[metric.Name+v.Sufix] = headers[i]
-			for k, va := range v.Metadata {
+			for k, va := range v.metadata {
 				a.setOneMetadata(metric.Name+v.Sufix, k, va, false)
 			}
 		}
@@ -109,39 +122,55 @@ func (a *Agent) processMetric(metric metric, c *MetricCollector) error {
 
 	switch {
 	case metric.Err != nil:
+		a.forgetLatestMetric(metric.Name)
 		err = fmt.Errorf("Error collecting metric %s: %v", metric.Name, metric.Err)
-		c.numErrs++
-		if c.numErrs >= a.ErrorLimit {
-			log.Infof("Metric Collector %s has reached max number of errors and will not be collected.", metric.Name)
-			c.state = stopState
-		}
+		a.collectionFailed(&c.BaseCollector, false)
 	case metric.Timeout:
+		a.forgetLatestMetric(metric.Name)
 		err = fmt.Errorf("timeout when collecting metric %s", metric.Name)
-		c.numTimeout++
-		if c.numTimeout >= a.TimeoutLimit {
-			log.Infof("Metric Collector %s has reached max number of timeouts and will not be collected.", metric.Name)
-			c.state = stopState
-		}
+		a.collectionFailed(&c.BaseCollector, true)
 	default:
-		//Reset Error and Timeout Counters
-		c.numTimeout = 0
-		c.numErrs = 0
+		a.resetHealth(&c.BaseCollector)
 
 		// Compile metric data:
 		metricBytes = []byte(metric.Format())
+		a.setLatestMetric(metric)
 	}
 	log.Infof("Done processing metric: %s", metric.Name)
 
 	// sending metric data
 	if len(metricBytes) > 0 {
-		a.NonBlockingSend(metricBytes)
+		a.NonBlockingSend(classMetric, metricBytes)
 	}
 
 	return err
 }
 
 func (a *Agent) runMetricCollector(c *MetricCollector) error {
-	metric := handleMetricCollection(c)
+	a.collecting.RLock()
+	defer a.collecting.RUnlock()
+	// a run requested with RunCollector waits for a scheduled one and the other way around
+	c.runMtx.Lock()
+	defer c.runMtx.Unlock()
+	if a.ctx.Err() != nil {
+		// agent is stopping
+		return nil
+	}
+
+	// reloads change the settings of the collector while it runs
+	a.metricCollectors.RLock()
+	timeout, frequency := c.timeout, c.frequency
+	a.metricCollectors.RUnlock()
+
+	start := time.Now()
+	c.setLastRun(start)
+	metric := handleMetricCollection(a.ctx, c, timeout, frequency, a.config().CounterMode)
+	if a.ctx.Err() != nil {
+		log.Infof("collection of metric '%s' cancelled", c.name)
+		return nil
+	}
+	a.countCollection(c.name, time.Since(start), metric.Err != nil, metric.Timeout)
+	a.recordMetric(c)
 	err := a.processMetric(metric, c)
 	if err != nil {
 		log.Error(err.Error())
@@ -164,24 +193,28 @@ func (a *Agent) scheduleMetricCollector(c *MetricCollector) {
 
 	log.Infof("starting metric collector %v", c.name)
 
-	if c.state == runningState {
+	// reloads change the settings of the collector and schedule it again
+	a.metricCollectors.RLock()
+	state, frequency, killCh := c.state, c.frequency, c.killCh
+	a.metricCollectors.RUnlock()
+
+	if state == runningState {
 		workerCh <- struct{}{}
 	}
 
-	if c.frequency > 0 {
-		ticker = time.NewTicker(c.frequency)
-		if c.state == stopState {
+	if frequency > 0 {
+		ticker = time.NewTicker(frequency)
+		if state == stopState {
 			ticker.Stop()
 		}
 	} else {
 		log.Infof("ran metric collector '%v' once (frequency <= 0)", c.name)
 		// stop as it needs to run max once
+		a.metricCollectors.Lock()
 		c.state = stopState
+		a.metricCollectors.Unlock()
 	}
 
-	// initlize collector members
-
-	killCh := c.killCh
 	for {
 		select {
 		case <-killCh:
@@ -190,12 +223,21 @@ func (a *Agent) scheduleMetricCollector(c *MetricCollector) {
 			close(workerCh)
 			return
 
+		case <-a.ctx.Done():
+			ticker.Stop()
+			close(workerCh)
+			return
+
 		case <-ticker.C:
-			if c.state == stopState {
+			if state == stopState {
 				ticker.Stop()
 				log.Infof("stop metric collector '%s'", c.name)
 				continue
 			}
+			if !c.due(time.Now()) {
+				// quarantined, wait for the next retry
+				continue
+			}
 			workerCh <- struct{}{}
 		}
 	}
@@ -215,14 +257,20 @@ func (a *Agent) scheduleMetrics() {
 	a.metricCollectors.RUnlock()
 }
 
-func handleMetricCollection(c *MetricCollector) metric {
+func handleMetricCollection(ctx context.Context, c *MetricCollector, timeout, frequency time.Duration, counterMode string) metric {
 	resCh := make(chan []*collectors.MetricResult, 1)
 	errCh := make(chan error, 1)
 	m := metric{Name: c.name}
 
+	// commands of the collector are killed when it times out
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	runCtx, killed := collectors.WithKillCount(runCtx)
+	runCtx = collectors.WithCollector(runCtx, c.name)
+
 	//run collector now
 	go func() {
-		res, err := c.collect()
+		res, err := c.collect(runCtx)
 		if err != nil {
 			errCh <- err
 			return
@@ -234,53 +282,131 @@ func handleMetricCollection(c *MetricCollector) metric {
 	select {
 	case err := <-errCh:
 		m.Err = err
+		m.Timeout = killed() > 0
 
 	case result := <-resCh:
+		if killed() > 0 {
+			// results of a collector whose commands were killed are incomplete
+			m.Timeout = true
+			break
+		}
+		m.Frequency = frequency
+		m.CollectionTime = collectors.Now(runCtx)
 		for i := range result {
-			if !isHidden(c.name) {
-				result[i].Header = strings.TrimSpace(result[i].Header)
-				result[i].Data = strings.TrimSpace(result[i].Data)
+			for j := range result[i].Samples {
+				if result[i].Samples[j].Timestamp.IsZero() {
+					result[i].Samples[j].Timestamp = m.CollectionTime
+				}
 			}
-			m.Data = append(m.Data, &metricResultCollector{MetricResult: *result[i]})
 		}
-		m.Frequency = c.frequency
-		m.CollectionTime = time.Now()
+		if c.derive != nil {
+			result = c.deriveCounters(result, counterMode)
+		}
+		for i := range result {
+			mr := &metricResultCollector{MetricResult: *result[i]}
+			mr.header, mr.data, mr.metadata = mr.Legacy()
+			m.Data = append(m.Data, mr)
+		}
 
-	case <-time.After(c.timeout):
+	case <-time.After(timeout + killGrace):
+		// the collector is stuck in something else than a command
 		m.Timeout = true
+
+	case <-ctx.Done():
+		m.Err = ctx.Err()
+	}
+	if m.Timeout {
+		m.Err = nil
 	}
 	return m
 }
 
-func isHidden(name string) bool {
-	return strings.HasPrefix(name, "hidden.")
-}
+// deriveCounters adds results derived from the counters of result and of the previous collection,
+// with the suffix "-rate". The raw results are left out in rate mode
+func (c *MetricCollector) deriveCounters(result []*collectors.MetricResult, counterMode string) []*collectors.MetricResult {
+	c.counters.Lock()
+	defer c.counters.Unlock()
+	if c.counters.prev == nil {
+		c.counters.prev = make(map[string][]collectors.Sample)
+	}
 
-// Generates a function with the Script.function func signature that returns statistical summary:
-func createHistogramCollector(h gometrics.Histogram) func() ([]*collectors.MetricResult, error) {
-	return func() ([]*collectors.MetricResult, error) {
-		hsnap := h.Snapshot()
-		qs := hsnap.Percentiles([]float64{0.25, 0.5, 0.75})
-		result := &collectors.MetricResult{
-			Header:   "count sum mean stddev min max q1 median q3",
-			Metadata: nil,
-			Data:     fmt.Sprintf("%v %v %v %v %v %v %v %v %v", hsnap.Count(), hsnap.Sum(), hsnap.Mean(), hsnap.StdDev(), hsnap.Min(), hsnap.Max(), qs[0], qs[1], qs[2]),
+	var derived []*collectors.MetricResult
+	seen := make(map[string]bool)
+	for _, r := range result {
+		seen[r.Sufix] = true
+		if prev, ok := c.counters.prev[r.Sufix]; ok && counterMode != counterModeRaw {
+			if samples := c.derive(prev, r.Samples); len(samples) > 0 {
+				for i := range samples {
+					samples[i].Timestamp = r.Samples[0].Timestamp
+				}
+				derived = append(derived, &collectors.MetricResult{Sufix: r.Sufix + "-rate", Samples: samples})
+			}
+		}
+		c.counters.prev[r.Sufix] = r.Samples
+	}
+	// forget results which were not collected this time, e.g. all drives were removed
+	for sufix := range c.counters.prev {
+		if !seen[sufix] {
+			delete(c.counters.prev, sufix)
 		}
-		return []*collectors.MetricResult{result}, nil
 	}
+
+	switch counterMode {
+	case counterModeRate:
+		return derived
+	case counterModeBoth:
+		return append(result, derived...)
+	}
+	return result
 }
 
-func (a *Agent) metadataString(metric, metadataKey, metadataValue string) (string, error) {
-	pureMetricName := metric
-	if strings.HasPrefix(metric, "smart") {
-		pureMetricName = "smart"
+// histogramSamples summarises histogram h in samples named name followed by Count, Sum, Mean, Stddev, Min,
+// Max, Q1, Median and Q3
+func histogramSamples(name, unit string, h gometrics.Histogram, labels ...collectors.Label) []collectors.Sample {
+	hsnap := h.Snapshot()
+	qs := hsnap.Percentiles([]float64{0.25, 0.5, 0.75})
+	samples := []collectors.Sample{
+		{Name: name + "Count", Type: collectors.IntValue, Kind: collectors.Counter, Value: float64(hsnap.Count())},
+		{Name: name + "Sum", Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(hsnap.Sum()), Unit: unit},
+		{Name: name + "Mean", Type: collectors.FloatValue, Kind: collectors.Gauge, Value: hsnap.Mean(), Unit: unit},
+		{Name: name + "Stddev", Type: collectors.FloatValue, Kind: collectors.Gauge, Value: hsnap.StdDev(), Unit: unit},
+		{Name: name + "Min", Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(hsnap.Min()), Unit: unit},
+		{Name: name + "Max", Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(hsnap.Max()), Unit: unit},
+		{Name: name + "Q1", Type: collectors.FloatValue, Kind: collectors.Gauge, Value: qs[0], Unit: unit},
+		{Name: name + "Median", Type: collectors.FloatValue, Kind: collectors.Gauge, Value: qs[1], Unit: unit},
+		{Name: name + "Q3", Type: collectors.FloatValue, Kind: collectors.Gauge, Value: qs[2], Unit: unit},
+	}
+	for i := range samples {
+		samples[i].Labels = labels
 	}
+	return samples
+}
 
+// metadataString returns the metadata line of metric, which is the name of its collector, followed by
+// the suffix of a result for collectors with several results or derived ones, e.g. smart-ata or cpu-rate
+func (a *Agent) metadataString(metric, metadataKey, metadataValue string) (string, error) {
 	a.metricCollectors.RLock()
-	collector := a.metricCollectors.List[pureMetricName]
+	name := metric
+	collector := a.metricCollectors.List[metric]
+	if collector == nil {
+		// the collector with the longest name the metric starts with
+		name = ""
+		for n, c := range a.metricCollectors.List {
+			if strings.HasPrefix(metric, n) && len(n) > len(name) {
+				name, collector = n, c
+			}
+		}
+	}
+	var frequency time.Duration
+	if collector != nil {
+		frequency = collector.frequency
+	}
 	a.metricCollectors.RUnlock()
+	if collector == nil {
+		return "", fmt.Errorf("no collector of metric %s, its metadata is not sent", metric)
+	}
 
-	return formatMetadataString(metric, a.Config.NodeID, collector.frequency, metadataKey, metadataValue), nil
+	return formatMetadataString(metric, a.config().NodeID, frequency, metadataKey, metadataValue), nil
 }
 
 func formatMetadataString(metricName, nodeID string, frequency time.Duration, metadataKey, metadataValue string) string {
@@ -305,23 +431,24 @@ func (a *Agent) setMetadata(metric, name, value string, updateServer bool) (chan
 		return false, fmt.Errorf("Error setting metadata: `value` paraemeter (%q) contains newline", value)
 	}
 
-	//lock the metadata map then set for :all metrics or an individual metric
+	//set for :all metrics or an individual metric, the collectors are not locked meanwhile as
+	//metadataString locks them
 	a.metricCollectors.RLock()
-	defer a.metricCollectors.RUnlock()
-	if metric == ":all" {
-
-		for metric := range a.metricCollectors.List {
-			singleChange := a.setOneMetadata(metric, name, value, updateServer)
-			if singleChange {
-				changed = true
-			}
+	var metrics []string
+	for m := range a.metricCollectors.List {
+		if metric == ":all" || m == metric {
+			metrics = append(metrics, m)
 		}
-	} else {
-		//make sure the metric exists before setting
-		if _, ok := a.metricCollectors.List[metric]; !ok {
-			return false, fmt.Errorf("Error seting metadata: metric %q is neither a recognized metric nor \":all\". ", metric)
+	}
+	a.metricCollectors.RUnlock()
+	//make sure the metric exists before setting
+	if len(metrics) == 0 && metric != ":all" {
+		return false, fmt.Errorf("Error seting metadata: metric %q is neither a recognized metric nor \":all\". ", metric)
+	}
+	for _, m := range metrics {
+		if a.setOneMetadata(m, name, value, updateServer) {
+			changed = true
 		}
-		changed = a.setOneMetadata(metric, name, value, updateServer)
 	}
 
 	return changed, nil
@@ -353,7 +480,7 @@ func (a *Agent) setOneMetadata(metric, name, value string, updateServer bool) (c
 				log.Error(err.Error())
 				return changed
 			}
-			a.NonBlockingSend([]byte(data))
+			a.NonBlockingSend(classMetric, []byte(data))
 		}
 	}
 	return changed
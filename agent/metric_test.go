@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// newTestAgent returns an agent with metric collectors cpu, collecting every 10 seconds and deriving
+// rates of its counters, and smart, collecting every 30 seconds
+func newTestAgent() *Agent {
+	a := &Agent{Config: &Config{NodeID: "node"}}
+	a.metricCollectors.List = map[string]*MetricCollector{
+		"cpu":   {BaseCollector: BaseCollector{name: "cpu"}, frequency: 10 * time.Second, derive: collectors.CounterRates},
+		"smart": {BaseCollector: BaseCollector{name: "smart"}, frequency: 30 * time.Second},
+	}
+	a.metricHeaders.Map = make(map[string]string)
+	a.metricMetadata.Map = make(map[string]map[string]string)
+	a.latestMetrics.Map = make(map[string]*metric)
+	return a
+}
+
+// counterResult returns a result with counter user of given value
+func counterResult(value string, at time.Time) []*collectors.MetricResult {
+	s := collectors.NewSample("user", value, collectors.IntValue)
+	s.Kind = collectors.Counter
+	s.Timestamp = at
+	return []*collectors.MetricResult{{Samples: []collectors.Sample{s}}}
+}
+
+func TestMetadataOfDerivedMetric(t *testing.T) {
+	a := newTestAgent()
+	c := a.metricCollectors.List["cpu"]
+	now := time.Now()
+	c.deriveCounters(counterResult("100", now), counterModeRate)
+	derived := c.deriveCounters(counterResult("300", now.Add(2*time.Second)), counterModeRate)
+	if len(derived) != 1 || derived[0].Sufix != "-rate" {
+		t.Fatalf("deriveCounters() = %v, want a result with suffix -rate", derived)
+	}
+
+	a.setOneMetadata("cpu"+derived[0].Sufix, "unit", "1/s", false)
+	a.setOneMetadata("smart-ata-rate", "unit", "1/s", false)
+	a.setOneMetadata("removed-rate", "unit", "1/s", false)
+	if _, err := a.metadataString("removed-rate", "unit", "1/s"); err == nil {
+		t.Errorf("metadataString() of a metric without collector succeeded")
+	}
+	data := string(a.initialSendData(&Destination{}))
+	for _, want := range []string{
+		":=:metadata cpu-rate node 10 unit 1/s\n",
+		":=:metadata smart-ata-rate node 30 unit 1/s\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("initialSendData() = %q, want it to contain %q", data, want)
+		}
+	}
+	if strings.Contains(data, "removed-rate") {
+		t.Errorf("initialSendData() = %q, want no metadata of a metric without collector", data)
+	}
+}
+
+func TestApplyCollectorsDuringCollection(t *testing.T) {
+	a := newTestAgent()
+	a.ctx = context.Background()
+	a.MetricFrequency, a.CollectorTimeout = 10*time.Second, time.Minute
+	a.collectorFreqs = []collectorSetting{{pattern: "smart", value: 30 * time.Second}}
+	for _, c := range a.metricCollectors.List {
+		c.state, c.timeout = runningState, time.Minute
+	}
+	c := a.metricCollectors.List["cpu"]
+	started, release, collected := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	c.collect = func(ctx context.Context) ([]*collectors.MetricResult, error) {
+		close(started)
+		<-release
+		return counterResult("1", time.Now()), nil
+	}
+	go func() {
+		a.runMetricCollector(c)
+		close(collected)
+	}()
+	<-started
+	// commands run as long as -exec-timeout
+	if !a.startCommand() {
+		t.Fatalf("startCommand() = false, want true")
+	}
+
+	// reloads and control commands apply settings while collections and commands run
+	applied := make(chan struct{})
+	go func() {
+		a.applyCollectors()
+		close(applied)
+	}()
+	select {
+	case <-applied:
+	case <-time.After(5 * time.Second):
+		t.Errorf("applyCollectors() waits for a running collection or command")
+	}
+	close(release)
+	<-collected
+	a.commands.Done()
+}
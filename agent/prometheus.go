@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// promFamily is a metric family of the exposition, all of its samples are written together
+type promFamily struct {
+	name, typ, help string
+	lines           []string
+}
+
+// startMetricsServer listens on addr and serves the latest results of the metric collectors at
+// metricsPath in Prometheus text or, if the scraper asks for it, OpenMetrics format
+func (a *Agent) startMetricsServer(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, a.serveMetrics)
+	a.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: httpTimeout}
+	go func() {
+		if err := a.metricsServer.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server error: %v", err)
+		}
+	}()
+	log.Infof("serving metrics at http://%s%s", l.Addr(), metricsPath)
+	return nil
+}
+
+// stopMetricsServer stops the metrics server, if started
+func (a *Agent) stopMetricsServer() {
+	if a.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.metricsServer.Shutdown(ctx); err != nil {
+		log.Errorf("can't stop metrics server: %v", err)
+	}
+}
+
+func (a *Agent) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	a.latestMetrics.RLock()
+	names := make([]string, 0, len(a.latestMetrics.Map))
+	for name := range a.latestMetrics.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]*metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, a.latestMetrics.Map[name])
+	}
+	a.latestMetrics.RUnlock()
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var buf bytes.Buffer
+	writeExposition(&buf, metrics, openMetrics)
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+// setLatestMetric keeps the result of a successful collection for the metrics server
+func (a *Agent) setLatestMetric(m metric) {
+	a.latestMetrics.Lock()
+	a.latestMetrics.Map[m.Name] = &m
+	a.latestMetrics.Unlock()
+}
+
+// forgetLatestMetric drops the result of a failed or stopped collector, so that it is not served stale
+func (a *Agent) forgetLatestMetric(name string) {
+	a.latestMetrics.Lock()
+	delete(a.latestMetrics.Map, name)
+	a.latestMetrics.Unlock()
+}
+
+// writeExposition writes the samples of metrics as metric families named hds_<collector>_<sample>,
+// labels of the samples become Prometheus labels. String samples are written as info style
+// families with the string in the value label
+func writeExposition(w io.Writer, metrics []*metric, openMetrics bool) {
+	var families []*promFamily
+	byName := make(map[string]*promFamily)
+	seen := make(map[string]bool)
+	conflicts := make(map[string]bool)
+
+	for _, m := range metrics {
+		for _, mr := range m.Data {
+			for i := range mr.Samples {
+				s := &mr.Samples[i]
+				name := promName("hds_" + m.Name + "_" + s.Name)
+				typ, value := "gauge", s.FormatFloat()
+				labels := make([]collectors.Label, 0, len(s.Labels)+1)
+				for _, l := range s.Labels {
+					labels = append(labels, collectors.Label{Name: promLabelName(l.Name), Value: l.Value})
+				}
+				if s.Type == collectors.StringValue {
+					name += "_info"
+					labels = append(labels, collectors.Label{Name: "value", Value: s.Text})
+					value = "1"
+				} else if s.Kind == collectors.Counter {
+					typ = "counter"
+				}
+
+				f := byName[name]
+				if f != nil && f.typ != typ {
+					// samples of another type whose names sanitize to the same, e.g. a counter and a
+					// gauge, go to a family named after their type
+					name += "_" + typ
+					f = byName[name]
+				}
+				if f == nil {
+					help := s.Help
+					if s.Unit != "" {
+						help += " (" + s.Unit + ")"
+					}
+					f = &promFamily{name: name, typ: typ, help: strings.TrimSpace(help)}
+					byName[name] = f
+					families = append(families, f)
+				} else if f.typ != typ {
+					if !conflicts[name] {
+						conflicts[name] = true
+						log.Errorf("can't serve %s samples of metric family %s of type %s", typ, name, f.typ)
+					}
+					continue
+				}
+
+				series := name
+				if openMetrics && typ == "counter" {
+					series += "_total"
+				}
+				series += promLabels(labels)
+				if seen[series] {
+					// a series must be unique in the exposition
+					continue
+				}
+				seen[series] = true
+				f.lines = append(f.lines, series+" "+value)
+			}
+		}
+	}
+
+	for _, f := range families {
+		if f.help != "" {
+			io.WriteString(w, "# HELP "+f.name+" "+promEscaper.Replace(f.help)+"\n")
+		}
+		io.WriteString(w, "# TYPE "+f.name+" "+f.typ+"\n")
+		for _, line := range f.lines {
+			io.WriteString(w, line+"\n")
+		}
+	}
+	if openMetrics {
+		io.WriteString(w, "# EOF\n")
+	}
+}
+
+var (
+	promEscaper      = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func promLabels(labels []collectors.Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, l.Name+`="`+promLabelEscaper.Replace(l.Value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// promName makes name a valid metric name, runs of other characters are replaced by '_'
+func promName(name string) string {
+	return sanitizePromName(name, true)
+}
+
+// promLabelName makes name a valid label name
+func promLabelName(name string) string {
+	return sanitizePromName(name, false)
+}
+
+func sanitizePromName(name string, colon bool) string {
+	var b strings.Builder
+	underscore := false
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9') || (colon && r == ':')
+		if !valid {
+			if !underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			underscore = true
+			continue
+		}
+		b.WriteRune(r)
+		underscore = r == '_'
+	}
+	res := strings.TrimRight(b.String(), "_")
+	if res == "" {
+		return "_"
+	}
+	return res
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+func TestExpositionOfSamplesOfDifferentTypes(t *testing.T) {
+	// "read.bytes" and "read-bytes" both become hds_disk_read_bytes
+	counter := collectors.NewSample("read.bytes", "100", collectors.IntValue)
+	counter.Kind = collectors.Counter
+	gauge := collectors.NewSample("read-bytes", "5", collectors.IntValue)
+	m := &metric{Name: "disk", Data: []*metricResultCollector{
+		{MetricResult: collectors.MetricResult{Samples: []collectors.Sample{counter, gauge}}},
+	}}
+
+	var buf bytes.Buffer
+	writeExposition(&buf, []*metric{m}, false)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE hds_disk_read_bytes counter\nhds_disk_read_bytes 100\n",
+		"# TYPE hds_disk_read_bytes_gauge gauge\nhds_disk_read_bytes_gauge 5\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("writeExposition() = %q, want it to contain %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// reload re-reads the config file and applies changes of destinations, frequencies, skip list,
+// timeouts, trusted keys and execution policy to the running agent. Other settings are applied on restart
+func (a *Agent) reload() {
+	if a.config().ConfigFile == "" {
+		log.Info("no config file given with -config, nothing to reload")
+		return
+	}
+
+	a.controlMtx.Lock()
+	defer a.controlMtx.Unlock()
+	if a.ctx.Err() != nil {
+		log.Info("agent is stopping, config not reloaded")
+		return
+	}
+	log.Infof("reloading config file %s", a.Config.ConfigFile)
+	c, err := a.readConfig()
+	if err != nil {
+		log.Errorf("can't reload config, keeping current settings: %v", err)
+		return
+	}
+	keys, err := loadTrustedKeys(c.TrustedKeys)
+	if err != nil {
+		log.Errorf("can't reload config, keeping current settings: %v", err)
+		return
+	}
+	policy, err := newExecPolicy(c)
+	if err != nil {
+		log.Errorf("can't reload config, keeping current settings: %v", err)
+		return
+	}
+	if err := a.applyDestinations(c); err != nil {
+		log.Errorf("can't reload config, keeping current settings: %v", err)
+		return
+	}
+
+	a.setTrustedKeys(keys)
+	a.setExecPolicy(policy)
+
+	// collectors and destinations read the settings while they run, they are swapped at once
+	freqs, _ := parseCollectorSettings(c.CollectorFreqStr)
+	timeouts, _ := parseCollectorSettings(c.CollectorTimeoutStr)
+	skip := newSkipmap(c.SkipStr)
+	a.settingsMtx.Lock()
+	a.Config = c
+	a.WaitTime = time.Duration(c.WaitTime) * time.Second
+	a.CollectorTimeout = time.Duration(c.CollectorTimeout) * time.Second
+	a.MetricFrequency = time.Duration(c.Freq) * time.Second
+	a.InvFrequency = invFrequency
+	if a.MetricFrequency == 0 {
+		a.InvFrequency = 0
+	}
+	a.collectorFreqs = freqs
+	a.collectorTimeouts = timeouts
+	a.Skipmap = skip
+	a.settingsMtx.Unlock()
+	a.applyCollectors()
+	a.signalInventorySchedule()
+	log.Info("config reloaded")
+}
+
+// config returns the current config. It is replaced, not changed, by reloads and control commands
+func (a *Agent) config() *Config {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	return a.Config
+}
+
+// waitTime returns the wait before a destination is connected again
+func (a *Agent) waitTime() time.Duration {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	return a.WaitTime
+}
+
+// isSkipped returns true if collector name is in the skip list
+func (a *Agent) isSkipped(name string) bool {
+	a.settingsMtx.RLock()
+	defer a.settingsMtx.RUnlock()
+	_, skip := a.Skipmap[name]
+	return skip
+}
+
+// readConfig reads a fresh config from the config file, with flags given on command line applied over it
+func (a *Agent) readConfig() (*Config, error) {
+	c := NewDefaultConfig()
+	if err := c.ReadFile(a.Config.ConfigFile); err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	bindFlags(fs, c)
+	for name, value := range a.cmdFlags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag -%s: %v", value, name, err)
+		}
+	}
+
+	// these are not changed without restart
+	c.ConfigFile = a.Config.ConfigFile
+	c.NodeID = a.Config.NodeID
+	c.Chdir = a.Config.Chdir
+	c.Duration = a.Config.Duration
+	c.DryRun = a.Config.DryRun
+	c.Listen = a.Config.Listen
+	c.AdminSocket = a.Config.AdminSocket
+	c.HostRoot = a.Config.HostRoot
+	c.Record = a.Config.Record
+	c.Replay = a.Config.Replay
+
+	if err := c.CheckErrs(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyDestinations starts destinations added to the config and stops removed ones. All of them are
+// restarted when TLS, http or spool settings changed
+func (a *Agent) applyDestinations(c *Config) error {
+	dsts, err := newDestinations(c)
+	if err != nil {
+		return err
+	}
+	restartAll := destinationSettings(a.Config) != destinationSettings(c)
+
+	a.destinationsMtx.RLock()
+	running := make(map[string]*Destination)
+	var kept, started []*Destination
+	for _, d := range a.Destinations {
+		// sinks are given to New, not to the config
+		if d.proto == protoSink {
+			kept = append(kept, d)
+			continue
+		}
+		running[d.spec] = d
+	}
+	a.destinationsMtx.RUnlock()
+
+	for _, d := range dsts {
+		if old, ok := running[d.spec]; ok && !restartAll {
+			kept = append(kept, old)
+			delete(running, d.spec)
+			continue
+		}
+		started = append(started, d)
+	}
+	if len(running) == 0 && len(started) == 0 {
+		return nil
+	}
+
+	// stop removed destinations first, a changed destination reuses their spool
+	a.destinationsMtx.Lock()
+	a.Destinations = kept
+	a.destinationsMtx.Unlock()
+	for _, d := range running {
+		log.Infof("removing destination %s", d.spec)
+		close(d.quit)
+		<-d.done
+	}
+
+	for _, d := range started {
+		if err := d.open(c); err != nil {
+			log.Errorf("can't add destination %s: %v", d.spec, err)
+			continue
+		}
+		log.Infof("adding destination %s", d.spec)
+		a.destinationsMtx.Lock()
+		a.Destinations = append(a.Destinations, d)
+		a.destinationsMtx.Unlock()
+		a.startDestination(d)
+	}
+	return nil
+}
+
+// destinationSettings returns settings shared by all destinations
+func destinationSettings(c *Config) string {
+	return fmt.Sprint(c.TLSCA, c.TLSCert, c.TLSKey, c.TLSServerName, c.TLSPin,
+		c.HTTPBatchSize, c.HTTPFlushInterval, c.HTTPRetries, c.SpoolSize,
+		c.FileMaxSize, c.FileMaxAge, c.FileCompress, c.FileKeep)
+}
+
+// applyCollectors applies timeouts, frequencies and skip list to the collectors. Metric collectors are
+// rescheduled when their frequency or state changed. Collections read the settings of their collector
+// under the lock of the collector list, running inventory collections hold it until they finish
+func (a *Agent) applyCollectors() {
+	a.inventoryCollectors.Lock()
+	for name, c := range a.inventoryCollectors.List {
+		c.timeout = a.collectorTimeout(name)
+		c.frequency = a.inventoryFrequency(name)
+		if c.collectorType != userScript {
+			c.state = a.initialState(&c.BaseCollector)
+		} else if a.isSkipped(name) {
+			c.state = stopState
+		} else {
+			c.state = runningState
+		}
+	}
+	a.inventoryCollectors.Unlock()
+
+	freqChanged := false
+	a.metricCollectors.Lock()
+	for name, c := range a.metricCollectors.List {
+		c.timeout = a.collectorTimeout(name)
+		freq := a.metricFrequency(name)
+		state := runningState
+		if c.collectorType != userScript {
+			state = a.initialState(&c.BaseCollector)
+		} else if a.isSkipped(name) {
+			state = stopState
+		}
+		if c.frequency == freq && (c.frequency == 0 || c.state == state) {
+			continue
+		}
+
+		log.Infof("rescheduling metric collector '%v'", c.name)
+		freqChanged = freqChanged || c.frequency != freq
+		close(c.killCh)
+		c.killCh = make(chan struct{})
+		c.frequency = freq
+		c.state = state
+		a.resetHealth(&c.BaseCollector)
+		if state != runningState {
+			a.forgetLatestMetric(name)
+		}
+		go a.scheduleMetricCollector(c)
+	}
+	a.metricCollectors.Unlock()
+
+	if freqChanged {
+		// headers carry the frequency, make them go out again
+		a.metricHeaders.Lock()
+		a.metricHeaders.Map = make(map[string]string)
+		a.metricHeaders.Unlock()
+	}
+}
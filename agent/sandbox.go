@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+)
+
+// execPolicy restricts the commands run by ExecCommand and user scripts
+type execPolicy struct {
+	uid, gid int    // user and group commands run as, -1 keeps the agent's
+	cpu      uint64 // max seconds of CPU time, 0 is unlimited
+	memory   uint64 // max bytes of address space, 0 is unlimited
+	nofile   uint64 // max number of open files, 0 is unlimited
+	output   uint64 // max bytes of output captured and of files written, 0 is unlimited
+	cgroup   string // cgroup v2 directory commands are started in, empty for none
+}
+
+// newExecPolicy returns the execution policy set in config c
+func newExecPolicy(c *Config) (*execPolicy, error) {
+	p := &execPolicy{
+		uid:    -1,
+		gid:    -1,
+		cpu:    uint64(c.ExecCPULimit),
+		memory: uint64(c.ExecMemoryLimit) << 20,
+		nofile: uint64(c.ExecNofileLimit),
+		output: uint64(c.ExecOutputLimit) << 20,
+		cgroup: c.ExecCgroup,
+	}
+	if c.ExecUser != "" {
+		var err error
+		if p.uid, p.gid, err = lookupExecUser(c.ExecUser); err != nil {
+			return nil, err
+		}
+	}
+	if p.cgroup != "" {
+		if _, err := os.Stat(filepath.Join(p.cgroup, "cgroup.procs")); err != nil {
+			return nil, fmt.Errorf("%s is not a cgroup v2 directory: %v", p.cgroup, err)
+		}
+	}
+	return p, nil
+}
+
+// lookupExecUser returns the ids of user[:group], given as names or ids. The primary group of
+// the user is used when no group is given
+func lookupExecUser(spec string) (uid, gid int, err error) {
+	userName, groupName := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userName, groupName = spec[:i], spec[i+1:]
+	}
+
+	u, err := user.Lookup(userName)
+	if _, isID := strconv.Atoi(userName); err != nil && isID == nil {
+		u, err = user.LookupId(userName)
+	}
+	if err != nil {
+		return -1, -1, fmt.Errorf("unknown user %q: %v", userName, err)
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if _, isID := strconv.Atoi(groupName); err != nil && isID == nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown group %q: %v", groupName, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// hasLimits returns true if resource limits are applied to commands
+func (p *execPolicy) hasLimits() bool {
+	return p.cpu > 0 || p.memory > 0 || p.nofile > 0 || p.output > 0
+}
+
+// chown makes path, and all files below it, owned by the user of the policy
+func (p *execPolicy) chown(path string) error {
+	if p.uid < 0 {
+		return nil
+	}
+	return filepath.Walk(path, func(name string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(name, p.uid, p.gid)
+	})
+}
+
+// setExecPolicy replaces the execution policy of commands
+func (a *Agent) setExecPolicy(p *execPolicy) {
+	a.execPolicy.Lock()
+	a.execPolicy.policy = p
+	a.execPolicy.Unlock()
+}
+
+// getExecPolicy returns the execution policy of commands
+func (a *Agent) getExecPolicy() *execPolicy {
+	a.execPolicy.RLock()
+	defer a.execPolicy.RUnlock()
+	if a.execPolicy.policy == nil {
+		return &execPolicy{uid: -1, gid: -1}
+	}
+	return a.execPolicy.policy
+}
+
+// runSandboxed runs path with args in dir under the execution policy of the agent, with a private
+// temp dir. The process group of the command is killed when ctx is done
+func (a *Agent) runSandboxed(ctx context.Context, dir, path string, args ...string) (*collectors.Result, error) {
+	p := a.getExecPolicy()
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "hds-agent-tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error generating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := p.chown(tmpDir); err != nil {
+		return nil, fmt.Errorf("error changing owner of temporary directory: %s", err)
+	}
+
+	setup, cleanup, err := p.setup()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return collectors.RunWith(ctx, collectors.RunOptions{
+		Dir:       dir,
+		Env:       append(withoutTmpEnv(os.Environ()), "TMPDIR="+tmpDir, "TMP="+tmpDir, "TEMP="+tmpDir),
+		MaxOutput: p.output,
+		Setup:     setup,
+	}, path, args...)
+}
+
+// withoutTmpEnv returns env without the temp dir variables
+func withoutTmpEnv(env []string) []string {
+	res := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "TMPDIR=") || strings.HasPrefix(kv, "TMP=") || strings.HasPrefix(kv, "TEMP=") {
+			continue
+		}
+		res = append(res, kv)
+	}
+	return res
+}
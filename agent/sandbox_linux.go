@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sandboxHelper is the name the agent binary is re-executed with to apply resource limits to a command
+const sandboxHelper = "hds-agent-sandbox"
+
+func init() {
+	if len(os.Args) > 2 && os.Args[0] == sandboxHelper {
+		runSandboxHelper(os.Args[1], os.Args[2:])
+	}
+}
+
+// sandboxLimits are the resource limits set by the helper, in the order of limitsArg
+var sandboxLimits = []int{syscall.RLIMIT_CPU, syscall.RLIMIT_AS, syscall.RLIMIT_NOFILE, syscall.RLIMIT_FSIZE}
+
+// limitsArg returns the resource limits passed to the helper
+func (p *execPolicy) limitsArg() string {
+	return fmt.Sprintf("%d,%d,%d,%d", p.cpu, p.memory, p.nofile, p.output)
+}
+
+// runSandboxHelper sets the resource limits, then replaces the process with the command in argv
+func runSandboxHelper(limits string, argv []string) {
+	for i, s := range strings.Split(limits, ",") {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || i >= len(sandboxLimits) {
+			fmt.Fprintf(os.Stderr, "%s: invalid limits %q\n", sandboxHelper, limits)
+			os.Exit(126)
+		}
+		if v == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(sandboxLimits[i], &syscall.Rlimit{Cur: v, Max: v}); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: can't set limit: %v\n", sandboxHelper, err)
+			os.Exit(126)
+		}
+	}
+	err := syscall.Exec(argv[0], argv, os.Environ())
+	fmt.Fprintf(os.Stderr, "%s: can't run %s: %v\n", sandboxHelper, argv[0], err)
+	os.Exit(126)
+}
+
+// setup returns the function which makes a command run as the user of the policy, with its resource
+// limits and in its cgroup. cleanup must be called after the command ran
+func (p *execPolicy) setup() (setup func(*exec.Cmd) error, cleanup func(), err error) {
+	cgroupFD := -1
+	cleanup = func() {}
+	if p.cgroup != "" {
+		if cgroupFD, err = syscall.Open(p.cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0); err != nil {
+			return nil, nil, fmt.Errorf("can't open cgroup: %v", err)
+		}
+		cleanup = func() { syscall.Close(cgroupFD) }
+	}
+
+	setup = func(cmd *exec.Cmd) error {
+		if p.hasLimits() {
+			// limits are applied by the agent binary re-executed as helper, before it executes the command
+			cmd.Args = append([]string{sandboxHelper, p.limitsArg(), cmd.Path}, cmd.Args[1:]...)
+			cmd.Path = "/proc/self/exe"
+		}
+		attr := &syscall.SysProcAttr{}
+		if p.uid >= 0 {
+			attr.Credential = &syscall.Credential{Uid: uint32(p.uid), Gid: uint32(p.gid), Groups: []uint32{}}
+		}
+		if cgroupFD >= 0 {
+			attr.UseCgroupFD = true
+			attr.CgroupFD = cgroupFD
+		}
+		cmd.SysProcAttr = attr
+		return nil
+	}
+	return setup, cleanup, nil
+}
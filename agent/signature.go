@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// loadTrustedKeys reads the PEM encoded public keys, RSA, ECDSA or Ed25519, from a comma-separated list of files
+func loadTrustedKeys(files string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, file := range strings.Split(files, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("can't read trusted key: %v", err)
+		}
+
+		found := 0
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			var key crypto.PublicKey
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err = x509.ParsePKIXPublicKey(block.Bytes)
+			case "CERTIFICATE":
+				var cert *x509.Certificate
+				if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+					key = cert.PublicKey
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted key in %s: %v", file, err)
+			}
+			switch key.(type) {
+			case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			default:
+				return nil, fmt.Errorf("unsupported trusted key type %T in %s", key, file)
+			}
+			keys = append(keys, key)
+			found++
+		}
+		if found == 0 {
+			return nil, fmt.Errorf("no public keys found in %s", file)
+		}
+	}
+	return keys, nil
+}
+
+// setTrustedKeys replaces the keys trusted to sign files run by ExecCommand
+func (a *Agent) setTrustedKeys(keys []crypto.PublicKey) {
+	a.trustedKeys.Lock()
+	a.trustedKeys.List = keys
+	a.trustedKeys.Unlock()
+}
+
+// getTrustedKeys returns the keys trusted to sign files run by ExecCommand
+func (a *Agent) getTrustedKeys() []crypto.PublicKey {
+	a.trustedKeys.RLock()
+	defer a.trustedKeys.RUnlock()
+	return a.trustedKeys.List
+}
+
+// verifyPayload checks that file has the hex encoded SHA-256 digest of cmd and that its signature, base64
+// encoded, is a signature of the command for node nodeID by one of the trusted keys, see signedCommand.
+// RSA signatures are PKCS #1 v1.5 and ECDSA signatures ASN.1 encoded, both of the SHA-256 of the signed
+// data, Ed25519 signatures are of the signed data
+func verifyPayload(keys []crypto.PublicKey, nodeID string, file *os.File, cmd command) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no trusted keys configured with -trusted-keys")
+	}
+	if cmd.Digest == "" || cmd.Signature == "" {
+		return fmt.Errorf("command has no digest or signature of the file")
+	}
+	if cmd.CmdID == "" {
+		return fmt.Errorf("command has no CmdID")
+	}
+	want, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(cmd.Digest)), "sha256:"))
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid digest %q: expected hex encoded SHA-256", cmd.Digest)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cmd.Signature))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return fmt.Errorf("can't read downloaded file: %v", err)
+	}
+	sum := h.Sum(nil)
+	if string(sum) != string(want) {
+		return fmt.Errorf("digest mismatch: downloaded file has SHA-256 %x", sum)
+	}
+
+	signed := signedCommand(nodeID, cmd.CmdID, sum, cmd.RunCmd, cmd.RunArgs)
+	signedSum := sha256.Sum256(signed)
+	for _, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, signedSum[:], sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, signedSum[:], sig) {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, signed, sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature of the file and command line does not match any trusted key")
+}
+
+// signedCommand returns the data signed for an ExecCommand: the node ID of the agent it is sent to, its
+// CmdID, the digest of its file in lower case hex, RunCmd and RunArgs, separated by NUL bytes. A signature
+// is only valid for the node, the command ID and the command line it was made for
+func signedCommand(nodeID, cmdID string, digest []byte, runCmd string, runArgs []string) []byte {
+	parts := append([]string{nodeID, cmdID, hex.EncodeToString(digest), runCmd}, runArgs...)
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+// claimCmdID records that the ExecCommand with given ID runs, it returns false if one with the ID ran
+// before. A captured command is not run again when it is sent again
+func (a *Agent) claimCmdID(id string) bool {
+	a.executedCmds.Lock()
+	defer a.executedCmds.Unlock()
+	if _, ok := a.executedCmds.Map[id]; ok {
+		return false
+	}
+	if a.executedCmds.Map == nil {
+		a.executedCmds.Map = make(map[string]struct{})
+	}
+	a.executedCmds.Map[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyPayloadCoversCommandLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payload")
+	content := []byte("#!/bin/sh\necho hello\n")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sum := sha256.Sum256(content)
+	signed := signedCommand("node1", "7", sum[:], "run.sh", []string{"--verbose"})
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedSum := sha256.Sum256(signed)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, signedSum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		key crypto.PublicKey
+		sig []byte
+	}{
+		"ed25519": {edPub, ed25519.Sign(edKey, signed)},
+		"rsa":     {&rsaKey.PublicKey, rsaSig},
+	} {
+		cmd := command{CmdID: "7", RunCmd: "run.sh", RunArgs: []string{"--verbose"}, Digest: hex.EncodeToString(sum[:]),
+			Signature: base64.StdEncoding.EncodeToString(tc.sig)}
+		keys := []crypto.PublicKey{tc.key}
+		if err := verifyPayload(keys, "node1", file, cmd); err != nil {
+			t.Errorf("%s: verifyPayload() error: %v", name, err)
+		}
+		if err := verifyPayload(keys, "node2", file, cmd); err == nil {
+			t.Errorf("%s: verifyPayload() accepted a command signed for another node", name)
+		}
+		changed := cmd
+		changed.RunArgs = []string{"--verbose", "--delete-everything"}
+		if err := verifyPayload(keys, "node1", file, changed); err == nil {
+			t.Errorf("%s: verifyPayload() accepted other arguments", name)
+		}
+		changed = cmd
+		changed.RunCmd = "other.sh"
+		if err := verifyPayload(keys, "node1", file, changed); err == nil {
+			t.Errorf("%s: verifyPayload() accepted another command", name)
+		}
+		changed = cmd
+		changed.CmdID = "8"
+		if err := verifyPayload(keys, "node1", file, changed); err == nil {
+			t.Errorf("%s: verifyPayload() accepted another command ID", name)
+		}
+	}
+}
+
+func TestClaimCmdID(t *testing.T) {
+	a := &Agent{}
+	if !a.claimCmdID("7") || !a.claimCmdID("8") {
+		t.Errorf("claimCmdID() of new IDs = false, want true")
+	}
+	if a.claimCmdID("7") {
+		t.Errorf("claimCmdID() of an ID which ran = true, want false")
+	}
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+// Sink is a destination of the data of the agent which is written to rather than connected to, like the
+// file destination or one given to New with WithSink. Its messages are the lines of the tcp destinations,
+// without their newline: metric data, headers and metadata, inventory blobs, syslog messages and command
+// outputs
+type Sink interface {
+	// Open prepares the sink for sending. hello returns the !nodeID message followed by the current
+	// metric headers and metadata, each line ending with a newline, which a receiver needs before the
+	// other messages, e.g. at the start of every connection or file
+	Open(hello func() []byte) error
+	// Send sends a message. When it fails the sink is closed and opened again after -retrywait
+	Send(data []byte) error
+	// Close releases the sink, Send is not called until it is opened again
+	Close() error
+}
+
+// connectSink sends data queued for a sink destination, opening it again when sending fails
+func (a *Agent) connectSink(ds *Destination) {
+	var (
+		opened  bool
+		msgc    <-chan []byte
+		replayc <-chan struct{}
+		pending [][]byte
+	)
+
+	source := ds.sendCh
+	sp := ds.spool
+	if sp != nil {
+		// with spool data is read all the time, it goes to the spool while the sink is closed
+		msgc = source
+	}
+	reopenTimer := time.After(0)
+	send := func(data []byte) error {
+		if err := ds.sink.Send(data); err != nil {
+			log.Errorf("can't send to %s, %v", ds.spec, err)
+			return err
+		}
+		a.countDestination(ds, statBytesSent, len(data)+1)
+		a.countDestination(ds, statMessagesSent, 1)
+		return nil
+	}
+
+	closeSink := func() {
+		opened = false
+		atomic.StoreInt32(&ds.connected, 0)
+		if err := ds.sink.Close(); err != nil {
+			log.Errorf("error closing %s: %v", ds.spec, err)
+		}
+	}
+
+	// fail closes the sink after an error and opens it again after WaitTime
+	fail := func() {
+		if opened {
+			closeSink()
+		}
+		replayc = nil
+		if sp == nil {
+			msgc = nil
+		}
+		wait := a.waitTime()
+		log.Errorf("attempting to open %s again in %0.f seconds", ds.spec, wait.Seconds())
+		a.countDestination(ds, statReconnects, 1)
+		reopenTimer = time.After(wait)
+	}
+
+	// replay sends a batch of spooled data, it reports if there is more to send
+	replay := func() (bool, error) {
+		records, err := sp.Peek(spoolReplayBatch)
+		if err != nil {
+			log.Errorf("can't replay spooled data: %v", err)
+			return false, nil
+		}
+		if len(records) == 0 {
+			log.Infof("spooled data replayed to %s", ds.spec)
+			return false, nil
+		}
+		// the spool is updated once per batch, with the records sent before an error
+		sent := 0
+		for _, data := range records {
+			if err = send(data); err != nil {
+				break
+			}
+			sent++
+		}
+		if cerr := sp.Commit(sent); cerr != nil {
+			log.Errorf("can't update spool: %v", cerr)
+		}
+		return err == nil, err
+	}
+
+	openSink := func() {
+		reopenTimer = nil
+		if err := ds.sink.Open(func() []byte { return a.initialSendData(ds) }); err != nil {
+			log.Errorf("can't open %s: %v", ds.spec, err)
+			fail()
+			return
+		}
+		log.Infof("opened %s", ds.spec)
+		opened = true
+		atomic.StoreInt32(&ds.connected, 1)
+
+		// Send the inventory
+		a.sendInventory(ds)
+
+		// Send pending messages
+		for len(pending) > 0 {
+			if err := send(pending[0]); err != nil {
+				fail()
+				return
+			}
+			pending = pending[1:]
+		}
+
+		// Replay spooled data before new data
+		if sp != nil && !sp.Empty() {
+			log.Infof("replaying %d bytes of spooled data to %s", sp.Len(), ds.spec)
+			replayc = readyCh
+		}
+
+		msgc = source
+	}
+
+	for {
+		select {
+		case <-ds.quit:
+			// deliver what is still queued, the rest goes to the spool
+			queued := append(pending, drainQueue(ds.sendCh)...)
+			if opened {
+				deadline := time.Now().Add(shutdownTimeout)
+				if sp != nil && !sp.Empty() {
+					// keep order, queued data goes after what is already spooled
+					for _, data := range queued {
+						a.spoolData(ds, data)
+					}
+					queued = nil
+					for more, _ := replay(); more && time.Now().Before(deadline); more, _ = replay() {
+					}
+				}
+				for len(queued) > 0 && time.Now().Before(deadline) && send(queued[0]) == nil {
+					queued = queued[1:]
+				}
+				closeSink()
+			}
+			a.closeDestination(ds, queued)
+			return
+
+		case <-reopenTimer:
+			openSink()
+
+		case <-replayc:
+			more, err := replay()
+			if err != nil {
+				fail()
+				continue
+			}
+			if !more {
+				replayc = nil
+			}
+
+		case data := <-msgc:
+			if sp != nil && (!opened || !sp.Empty()) {
+				// keep order, new data goes after what is already spooled
+				a.spoolData(ds, data)
+				if opened {
+					replayc = readyCh
+				}
+				continue
+			}
+			if err := send(data); err != nil {
+				if sp != nil {
+					a.spoolData(ds, data)
+				} else {
+					pending = append(pending, data)
+				}
+				fail()
+			}
+		}
+	}
+}
+
+// newSinkDestination returns a destination sending the data of the given classes, all without classes,
+// to sink s
+func newSinkDestination(name string, s Sink, classes ...string) (*Destination, error) {
+	if name == "" || s == nil {
+		return nil, fmt.Errorf("sink needs a name and an implementation")
+	}
+	d := &Destination{proto: protoSink, dst: name, spec: protoSink + ":" + name, sink: s}
+	if len(classes) > 0 {
+		var err error
+		if d.classes, err = parseClasses(strings.Join(classes, "+")); err != nil {
+			return nil, fmt.Errorf("invalid classes of sink %s: %v", name, err)
+		}
+	}
+	return d, nil
+}
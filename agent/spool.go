@@ -0,0 +1,358 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/log"
+)
+
+const (
+	spoolSegments   = 8 // number of segment files the size cap is split into
+	spoolHeaderSize = 4
+	spoolExt        = ".spool"
+	spoolHeadFile   = "head"
+)
+
+// spoolPos is a position of a record in the spool
+type spoolPos struct {
+	seg int64 // sequence number of segment file
+	off int64 // offset in segment file
+}
+
+// spool is a disk-backed FIFO of messages which could not be delivered to a destination.
+// Messages are appended to segment files of length-prefixed records; once the size cap is
+// reached the oldest segment is evicted. Read position survives agent restarts
+type spool struct {
+	sync.Mutex
+	dir      string
+	maxSize  int64      // cap of total size of the segment files
+	segSize  int64      // size after which a new segment is started
+	segments []int64    // sequence numbers of segment files, oldest first
+	sizes    []int64    // sizes of segment files
+	head     spoolPos   // position of the oldest unread record
+	w        *os.File   // last segment, open for appending
+	peeked   []spoolPos // end positions of records returned by the last peek
+	evicted  int        // number of records dropped because of the size cap
+}
+
+// openSpool opens the spool in dir, creating it if needed, and recovers its state
+func openSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxSize: maxSize, segSize: maxSize / spoolSegments}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	for _, seq := range s.segments {
+		fi, err := os.Stat(s.segPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.sizes = append(s.sizes, fi.Size())
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = []int64{1}
+		s.sizes = []int64{0}
+	} else if err := s.truncateTornRecord(); err != nil {
+		return nil, err
+	}
+
+	s.head = spoolPos{seg: s.segments[0]}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, spoolHeadFile)); err == nil {
+		var pos spoolPos
+		if _, err := fmt.Sscanf(string(data), "%d %d", &pos.seg, &pos.off); err == nil && s.index(pos.seg) >= 0 {
+			s.head = pos
+		}
+	}
+	// the head file is written after the segment is truncated, a crash in between leaves it past the end
+	if i := s.index(s.head.seg); s.head.off < 0 || s.head.off > s.sizes[i] {
+		log.Errorf("read position %d of spool %s is past the end of its file, resetting it", s.head.off, dir)
+		s.head.off = s.sizes[i]
+	}
+
+	last := len(s.segments) - 1
+	if s.w, err = os.OpenFile(s.segPath(s.segments[last]), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	if s.Len() > 0 {
+		log.Infof("spool %s has %d bytes of undelivered data", dir, s.Len())
+	}
+	return s, nil
+}
+
+// truncateTornRecord drops a partially written record at the end of the last segment
+func (s *spool) truncateTornRecord() error {
+	last := len(s.segments) - 1
+	path := s.segPath(s.segments[last])
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		n, err := readRecordLen(r)
+		if err != nil || valid+spoolHeaderSize+n > s.sizes[last] {
+			break
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			break
+		}
+		valid += spoolHeaderSize + n
+	}
+	if valid < s.sizes[last] {
+		log.Errorf("truncating torn record in spool file %s at offset %d", path, valid)
+		s.sizes[last] = valid
+		return os.Truncate(path, valid)
+	}
+	return nil
+}
+
+// Push appends data to the spool, evicting oldest segments when the size cap is exceeded
+func (s *spool) Push(data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	last := len(s.segments) - 1
+	if s.sizes[last] > 0 && s.sizes[last]+spoolHeaderSize+int64(len(data)) > s.segSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last++
+	}
+
+	buf := make([]byte, spoolHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[spoolHeaderSize:], data)
+	if _, err := s.w.Write(buf); err != nil {
+		return err
+	}
+	s.sizes[last] += int64(len(buf))
+
+	for s.size() > s.maxSize && len(s.segments) > 1 {
+		s.evictOldest()
+	}
+	return nil
+}
+
+// Peek returns up to max records from the head of the spool without removing them
+func (s *spool) Peek(max int) ([][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.peeked = s.peeked[:0]
+	var records [][]byte
+	pos := s.head
+	for len(records) < max {
+		i := s.index(pos.seg)
+		if i < 0 {
+			break
+		}
+		if pos.off >= s.sizes[i] {
+			if i == len(s.segments)-1 {
+				break
+			}
+			pos = spoolPos{seg: s.segments[i+1]}
+			continue
+		}
+
+		f, err := os.Open(s.segPath(pos.seg))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(pos.off, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		for len(records) < max && pos.off < s.sizes[i] {
+			n, err := readRecordLen(r)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("corrupted spool file %s at offset %d: %v", s.segPath(pos.seg), pos.off, err)
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("corrupted spool file %s at offset %d: %v", s.segPath(pos.seg), pos.off, err)
+			}
+			pos.off += spoolHeaderSize + n
+			records = append(records, data)
+			s.peeked = append(s.peeked, pos)
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+// Commit removes the first n not yet committed records returned by the last Peek
+func (s *spool) Commit(n int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if n <= 0 || n > len(s.peeked) {
+		// records were evicted in the meantime
+		return nil
+	}
+	s.head = s.peeked[n-1]
+	s.peeked = s.peeked[n:]
+
+	// delete fully read segments, the last one is kept for writing
+	for len(s.segments) > 1 && (s.head.seg != s.segments[0] || s.head.off >= s.sizes[0]) {
+		if s.head.seg == s.segments[0] {
+			s.head = spoolPos{seg: s.segments[1]}
+		}
+		s.removeOldest()
+	}
+	// everything is read, start the last segment from scratch
+	if len(s.segments) == 1 && s.head.off >= s.sizes[0] {
+		if err := os.Truncate(s.segPath(s.segments[0]), 0); err != nil {
+			return err
+		}
+		s.sizes[0] = 0
+		s.head.off = 0
+	}
+	return s.writeHead()
+}
+
+// writeHead saves the read position, through a temporary file so that a crash leaves the old or new one
+func (s *spool) writeHead() error {
+	path := filepath.Join(s.dir, spoolHeadFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", s.head.seg, s.head.off)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Len returns number of bytes waiting in the spool
+func (s *spool) Len() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.size() - s.head.off
+}
+
+// Empty reports if there is nothing to replay
+func (s *spool) Empty() bool {
+	return s.Len() == 0
+}
+
+// Close closes the segment open for writing
+func (s *spool) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.w.Close()
+}
+
+func (s *spool) rotate() error {
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+	seq := s.segments[len(s.segments)-1] + 1
+	w, err := os.OpenFile(s.segPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.w = w
+	s.segments = append(s.segments, seq)
+	s.sizes = append(s.sizes, 0)
+	return nil
+}
+
+func (s *spool) evictOldest() {
+	path := s.segPath(s.segments[0])
+	count := s.countRecords(path)
+	log.Errorf("spool %s is over %d bytes, dropping %d oldest messages", s.dir, s.maxSize, count)
+	s.evicted += count
+	if s.head.seg == s.segments[0] {
+		s.head = spoolPos{seg: s.segments[1]}
+		s.peeked = s.peeked[:0]
+	}
+	s.removeOldest()
+}
+
+func (s *spool) removeOldest() {
+	if err := os.Remove(s.segPath(s.segments[0])); err != nil {
+		log.Errorf("can't remove spool file: %v", err)
+	}
+	s.segments = s.segments[1:]
+	s.sizes = s.sizes[1:]
+}
+
+func (s *spool) countRecords(path string) (count int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		n, err := readRecordLen(r)
+		if err != nil {
+			return count
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+// size returns total size of the segment files, without already read part of head segment
+func (s *spool) size() (size int64) {
+	for i, seq := range s.segments {
+		if seq < s.head.seg {
+			continue
+		}
+		size += s.sizes[i]
+	}
+	return size
+}
+
+func (s *spool) index(seq int64) int {
+	for i := range s.segments {
+		if s.segments[i] == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *spool) segPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", seq, spoolExt))
+}
+
+func readRecordLen(r io.Reader) (int64, error) {
+	var hdr [spoolHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint32(hdr[:])), nil
+}
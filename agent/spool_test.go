@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pushAll pushes the messages to the spool
+func pushAll(t *testing.T, s *spool, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := s.Push([]byte(msg)); err != nil {
+			t.Fatalf("Push(%q) error: %v", msg, err)
+		}
+	}
+}
+
+// peekAll returns the messages at the head of the spool, up to max
+func peekAll(t *testing.T, s *spool, max int) []string {
+	t.Helper()
+	records, err := s.Peek(max)
+	if err != nil {
+		t.Fatalf("Peek() error: %v", err)
+	}
+	var msgs []string
+	for _, data := range records {
+		msgs = append(msgs, string(data))
+	}
+	return msgs
+}
+
+func TestSpoolPushPeekCommit(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	defer s.Close()
+	if !s.Empty() {
+		t.Fatalf("new spool is not empty")
+	}
+	pushAll(t, s, "one", "two", "three")
+	if got := fmt.Sprint(peekAll(t, s, 2)); got != "[one two]" {
+		t.Errorf("Peek(2) = %s, want [one two]", got)
+	}
+	// peeking again returns the same records
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[one two three]" {
+		t.Errorf("Peek(10) = %s, want [one two three]", got)
+	}
+	if err := s.Commit(2); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[three]" {
+		t.Errorf("Peek() after Commit(2) = %s, want [three]", got)
+	}
+	if want := int64(spoolHeaderSize + len("three")); s.Len() != want {
+		t.Errorf("Len() = %d, want %d", s.Len(), want)
+	}
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if !s.Empty() || s.Len() != 0 {
+		t.Errorf("spool not empty after everything is committed, Len() = %d", s.Len())
+	}
+	if s.sizes[0] != 0 {
+		t.Errorf("segment of size %d not truncated after everything is committed", s.sizes[0])
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	// 8 segments of 32 bytes, each holding 2 records of 16 bytes
+	s, err := openSpool(t.TempDir(), 256)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < 20; i++ {
+		pushAll(t, s, fmt.Sprintf("message-%03d", i))
+	}
+	if s.size() > s.maxSize {
+		t.Errorf("spool size %d over its cap %d", s.size(), s.maxSize)
+	}
+	if s.evicted != 4 {
+		t.Errorf("evicted %d messages, want 4", s.evicted)
+	}
+	msgs := peekAll(t, s, 100)
+	if len(msgs) != 16 || msgs[0] != "message-004" || msgs[15] != "message-019" {
+		t.Errorf("Peek() = %v, want message-004 to message-019", msgs)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	pushAll(t, s, "one", "two", "three")
+	peekAll(t, s, 1)
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	s.Close()
+
+	s, err = openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	defer s.Close()
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[two three]" {
+		t.Errorf("Peek() after reopen = %s, want [two three]", got)
+	}
+	pushAll(t, s, "four")
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[two three four]" {
+		t.Errorf("Peek() after Push = %s, want [two three four]", got)
+	}
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	pushAll(t, s, "one", "two")
+	s.Close()
+
+	// a crash while writing leaves part of a record
+	f, err := os.OpenFile(s.segPath(1), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 10, 't', 'h'})
+	f.Close()
+
+	s, err = openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	defer s.Close()
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[one two]" {
+		t.Errorf("Peek() after torn record = %s, want [one two]", got)
+	}
+	pushAll(t, s, "three")
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[one two three]" {
+		t.Errorf("Peek() after Push = %s, want [one two three]", got)
+	}
+}
+
+func TestSpoolHeadPastEnd(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	pushAll(t, s, "one")
+	s.Close()
+
+	// a crash after the segment is truncated and before the head file is written
+	if err := os.Truncate(s.segPath(1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, spoolHeadFile), []byte("1 7"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("openSpool() error: %v", err)
+	}
+	defer s.Close()
+	if s.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", s.Len())
+	}
+	pushAll(t, s, "two")
+	if got := fmt.Sprint(peekAll(t, s, 10)); got != "[two]" {
+		t.Errorf("Peek() after Push = %s, want [two]", got)
+	}
+}
+
+func TestSpoolOfFailingDestination(t *testing.T) {
+	// the server resets every connection after the initial data, writes and replays keep failing
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			bufio.NewReader(conn).ReadBytes('\n')
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+
+	a := &Agent{Config: &Config{NodeID: "node"}, WaitTime: time.Millisecond, dialTimeout: time.Second,
+		ctx: context.Background()}
+	a.metricHeaders.Map = make(map[string]string)
+	a.metricMetadata.Map = make(map[string]map[string]string)
+	d, err := parseDest("tcp:" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.spool, err = openSpool(t.TempDir(), 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	d.sendCh = make(chan []byte, 100)
+	d.quit, d.done = make(chan struct{}), make(chan struct{})
+	go a.connectTCP(d)
+	for end := time.Now().Add(time.Second); time.Now().Before(end); {
+		select {
+		case d.sendCh <- []byte("metric 1"):
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(d.quit)
+	select {
+	case <-d.done:
+	case <-time.After(shutdownTimeout):
+		t.Fatalf("destination did not stop")
+	}
+	if n := atomic.LoadInt32(&accepted); n < 3 {
+		t.Errorf("destination connected %d times, want it to reconnect after failed writes", n)
+	}
+}
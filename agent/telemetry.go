@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// telemetryCollector is the name of the metric collector reporting the health of the agent itself
+const telemetryCollector = "agent"
+
+// counters of destinations, kept in the telemetry registry as destination.<spec>.<name>
+const (
+	statBytesSent    = "bytesSent"
+	statMessagesSent = "messagesSent"
+	statDrops        = "drops"
+	statReconnects   = "reconnects"
+)
+
+// countDestination adds n to the named counter of destination ds
+func (a *Agent) countDestination(ds *Destination, name string, n int) {
+	gometrics.GetOrRegisterCounter("destination."+ds.spec+"."+name, a.telemetry).Inc(int64(n))
+}
+
+// countSent counts data sent to destination ds, a message is a line
+func (a *Agent) countSent(ds *Destination, data []byte) {
+	a.countDestination(ds, statBytesSent, len(data))
+	a.countDestination(ds, statMessagesSent, countLines(data))
+}
+
+// countLines returns the number of lines in data, a last line may miss its newline
+func countLines(data []byte) int {
+	n := 0
+	for i, c := range data {
+		if c == '\n' || i == len(data)-1 {
+			n++
+		}
+	}
+	return n
+}
+
+// countCollection records the duration and the outcome of a collection by the named collector
+func (a *Agent) countCollection(name string, d time.Duration, failed, timeout bool) {
+	gometrics.GetOrRegisterHistogram("collector."+name+".duration", a.telemetry,
+		gometrics.NewExpDecaySample(1028, 0.015)).Update(d.Nanoseconds() / int64(time.Millisecond))
+	if timeout {
+		gometrics.GetOrRegisterCounter("collector."+name+".timeouts", a.telemetry).Inc(1)
+	} else if failed {
+		gometrics.GetOrRegisterCounter("collector."+name+".errors", a.telemetry).Inc(1)
+	}
+}
+
+// collectTelemetry is the run function of the agent metric collector. It reports the resource usage
+// of the agent, the queues and counters of the destinations, and durations, errors and timeouts of
+// the collectors
+func (a *Agent) collectTelemetry(ctx context.Context) ([]*collectors.MetricResult, error) {
+	rss, cpuTime, err := processUsage()
+	if err != nil {
+		return nil, err
+	}
+	res := &collectors.MetricResult{Samples: []collectors.Sample{
+		{Name: "rss", Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(rss), Unit: "bytes", Help: "resident memory of the agent"},
+		{Name: "cpuTime", Type: collectors.FloatValue, Kind: collectors.Counter, Value: cpuTime.Seconds(), Unit: "s", Help: "user and system CPU time of the agent"},
+		{Name: "goroutines", Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(runtime.NumGoroutine()), Help: "number of goroutines of the agent"},
+	}}
+
+	a.destinationsMtx.RLock()
+	for _, d := range a.Destinations {
+		label := collectors.Label{Name: "destination", Value: d.spec}
+		var spooled int64
+		if d.spool != nil {
+			spooled = d.spool.Len()
+		}
+		res.Samples = append(res.Samples,
+			collectors.Sample{Name: "queued", Labels: []collectors.Label{label}, Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(len(d.sendCh)), Help: "messages waiting in the queue of the destination"},
+			collectors.Sample{Name: "spooled", Labels: []collectors.Label{label}, Type: collectors.IntValue, Kind: collectors.Gauge, Value: float64(spooled), Unit: "bytes", Help: "data waiting in the spool of the destination"},
+		)
+		for _, name := range []string{statBytesSent, statMessagesSent, statDrops, statReconnects} {
+			c := gometrics.GetOrRegisterCounter("destination."+d.spec+"."+name, a.telemetry)
+			res.Samples = append(res.Samples, collectors.Sample{Name: name, Labels: []collectors.Label{label}, Type: collectors.IntValue, Kind: collectors.Counter, Value: float64(c.Count())})
+		}
+	}
+	a.destinationsMtx.RUnlock()
+
+	for _, name := range a.collectorNames() {
+		label := collectors.Label{Name: "collector", Value: name}
+		h := gometrics.GetOrRegisterHistogram("collector."+name+".duration", a.telemetry, gometrics.NewExpDecaySample(1028, 0.015))
+		res.Samples = append(res.Samples, histogramSamples("duration", "ms", h, label)...)
+		for _, counter := range []string{"errors", "timeouts"} {
+			c := gometrics.GetOrRegisterCounter("collector."+name+"."+counter, a.telemetry)
+			res.Samples = append(res.Samples, collectors.Sample{Name: counter, Labels: []collectors.Label{label}, Type: collectors.IntValue, Kind: collectors.Counter, Value: float64(c.Count())})
+		}
+	}
+	return []*collectors.MetricResult{res}, nil
+}
+
+// collectorNames returns the sorted names of the running metric and inventory collectors
+func (a *Agent) collectorNames() []string {
+	var names []string
+	a.metricCollectors.RLock()
+	for name, c := range a.metricCollectors.List {
+		if c.state == runningState {
+			names = append(names, name)
+		}
+	}
+	a.metricCollectors.RUnlock()
+	a.inventoryCollectors.RLock()
+	for name, c := range a.inventoryCollectors.List {
+		if c.state == runningState {
+			names = append(names, name)
+		}
+	}
+	a.inventoryCollectors.RUnlock()
+	sort.Strings(names)
+	return names
+}
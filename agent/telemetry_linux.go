@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processUsage returns the resident memory in bytes and the CPU time used by the agent
+func processUsage() (rss uint64, cpuTime time.Duration, err error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("unexpected content of /proc/self/statm: %q", statm)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, err
+	}
+	cpuTime = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	return pages * uint64(os.Getpagesize()), cpuTime, nil
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// newTLSConfig builds the client TLS configuration for tls: and https: destinations from the agent config.
+// Server name defaults to the given destination host
+func newTLSConfig(c *Config, host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLSServerName != "" {
+		tlsConfig.ServerName = c.TLSServerName
+	}
+
+	// CA bundle, system roots are used when not given
+	if c.TLSCA != "" {
+		caPEM, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", c.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// client certificate for mutual TLS
+	if c.TLSCert != "" || c.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.TLSPin != "" {
+		pins, err := parsePins(c.TLSPin)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	return tlsConfig, nil
+}
+
+// parsePins parses a comma-separated list of hex encoded SHA-256 fingerprints, colons are allowed
+func parsePins(pinStr string) (map[string]struct{}, error) {
+	pins := make(map[string]struct{})
+	for _, pin := range strings.Split(pinStr, ",") {
+		pin = strings.ToLower(strings.Replace(strings.TrimSpace(pin), ":", "", -1))
+		if pin == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate pin %q: expected hex encoded SHA-256 fingerprint", pin)
+		}
+		pins[pin] = struct{}{}
+	}
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("no certificate pins given")
+	}
+	return pins, nil
+}
+
+// verifyPins returns a verification callback which accepts the connection only if the SHA-256
+// fingerprint of the public key of a certificate in the verified chain matches one of the pins
+func verifyPins(pins map[string]struct{}) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if _, ok := pins[hex.EncodeToString(sum[:])]; ok {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("server certificate does not match any pinned public key")
+	}
+}
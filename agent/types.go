@@ -1,12 +1,17 @@
 package agent
 
 import (
+	"context"
+	"crypto"
+	"crypto/tls"
 	"encoding/json"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/Ericsson/ericsson-hds-agent/agent/collectors"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 // BaseCollector contains common information for collectors
@@ -15,10 +20,16 @@ type BaseCollector struct {
 	collectorType string
 	state         string
 	timeout       time.Duration // timeout duration
+	healthMtx     sync.Mutex    // protects the health and the last run, which collections change
 	numTimeout    int           // number of times timeout
 	numErrs       int           // number of times error
-	precheck      func() error
+	health        string        // healthy, degraded or quarantined, see health.go
+	backoff       time.Duration // wait before the next retry of a quarantined collector
+	retryAt       time.Time     // when a quarantined collector is retried
+	lastRun       time.Time     // start of the last collection
+	precheck      collectors.CollectorPrecheck
 	dependencies  []string // 3-rd party dependencies names
+	script        string   // path of a user script, empty for built-in collectors
 }
 
 // MetricCollector contains information of metric collector
@@ -26,13 +37,24 @@ type MetricCollector struct {
 	BaseCollector
 	killCh    chan struct{}
 	frequency time.Duration
-	collect   func() ([]*collectors.MetricResult, error)
+	runMtx    sync.Mutex // serializes the collections
+	collect   collectors.MetricRunner
+	derive    collectors.MetricDeriver // derives rates from counters, nil if collector has none
+	counters  counterState             // counters of the previous collection
+}
+
+// counterState keeps the samples of the previous collection by result suffix
+type counterState struct {
+	sync.Mutex
+	prev map[string][]collectors.Sample
 }
 
 // InventoryCollector contains information of inventory collector
 type InventoryCollector struct {
 	BaseCollector
-	collect func() ([]byte, error)
+	frequency time.Duration   // frequency of collection, 0 runs it only when a destination connects
+	last      json.RawMessage // output of the last successful collection
+	collect   collectors.CollectorRunner
 }
 
 // Metadata is wrapper struct for hosttype
@@ -43,13 +65,29 @@ type Metadata struct {
 // Destination contains information of destination server where metric/inventory
 // data needs to be sent
 type Destination struct {
-	dst       string // where to send collector output
-	proto     string // what protocol to send with i.e. UDP/TCP/HTTP
-	transport string // currently support tcp
+	dst           string          // where to send collector output
+	proto         string          // what protocol to send with i.e. UDP/TCP/HTTP
+	transport     string          // currently support tcp
+	tlsConfig     *tls.Config     // client TLS settings for tls and https destinations
+	batchSize     int             // max number of messages in one http POST
+	flushInterval time.Duration   // max time a message waits in an http batch
+	retries       int             // number of retries of a failed http POST
+	classes       map[string]bool // data classes sent to the destination, nil means all
+	sendCh        chan []byte     // queue of data waiting to be sent
+	spool         *spool          // undelivered data
+	inventorySent bool            // inventory collected at start was queued for the destination
+	spec          string          // destination as given in the config
+	quit          chan struct{}   // closed to stop sending to the destination
+	done          chan struct{}   // closed when sending to the destination stopped
+	connected     int32           // 1 while connected, or the last POST succeeded, accessed atomically
+	sink          Sink            // receives the data of a sink destination
 }
 
 type metricResultCollector struct {
 	collectors.MetricResult
+	header           string            // legacy header columns of the samples
+	data             string            // legacy data columns of the samples
+	metadata         map[string]string // legacy metadata of the samples
 	isNeedSendHeader bool
 }
 
@@ -74,6 +112,8 @@ type Blob struct {
 type command struct {
 	Name, CmdID, FileURL, RunCmd, NodeID string
 	RunArgs                              []string
+	Digest                               string // hex SHA-256 of the file at FileURL
+	Signature                            string // base64 detached signature of the file by a trusted key
 }
 
 type commandOutput struct {
@@ -91,16 +131,46 @@ type commandOutput struct {
 //
 //so we can run hds-agent without command line flags
 type Config struct {
-	NodeID           string `json:"-"` // ID of host machine
-	Chdir            string `json:"chdir"`
-	CollectorTimeout int    `json:"collection-timeout"` // number of seconds before a collector times out
-	Destination      string `json:"destination"`
-	DryRun           bool   `json:"dry-run"`
-	Duration         int    `json:"duration"` // How many seconds to run agent for
-	Freq             int    `json:"frequency"`
-	SkipStr          string `json:"skipStr"`
-	Stdout           bool   `json:"stdout"`
-	WaitTime         int    `json:"retrywait"` // number of seconds between attempting to reconnect to remote server
+	ConfigFile          string `json:"-" yaml:"-"`                       // file settings are read from
+	AdminSocket         string `json:"admin-socket" yaml:"admin-socket"` // unix socket the admin API is served at, empty disables it
+	NodeID              string `json:"-" yaml:"-"`                       // ID of host machine
+	Chdir               string `json:"chdir" yaml:"chdir"`
+	CollectorTimeout    int    `json:"collection-timeout" yaml:"collection-timeout"`   // number of seconds before a collector times out
+	CollectorFreqStr    string `json:"collector-frequency" yaml:"collector-frequency"` // frequencies of single collectors as name=seconds list
+	CollectorTimeoutStr string `json:"collector-timeout" yaml:"collector-timeout"`     // timeouts of single collectors as name=seconds list
+	CounterMode         string `json:"counter-mode" yaml:"counter-mode"`               // raw counters, rates derived from them, or both
+	Destination         string `json:"destination" yaml:"destination"`
+	DryRun              bool   `json:"dry-run" yaml:"dry-run"`
+	Duration            int    `json:"duration" yaml:"duration"`                   // How many seconds to run agent for
+	ExecCgroup          string `json:"exec-cgroup" yaml:"exec-cgroup"`             // cgroup v2 directory commands and user scripts are started in
+	ExecCPULimit        int    `json:"exec-cpu-limit" yaml:"exec-cpu-limit"`       // max seconds of CPU time of commands and user scripts
+	ExecMemoryLimit     int    `json:"exec-memory-limit" yaml:"exec-memory-limit"` // max megabytes of address space of commands and user scripts
+	ExecNofileLimit     int    `json:"exec-nofile-limit" yaml:"exec-nofile-limit"` // max number of open files of commands and user scripts
+	ExecOutputLimit     int    `json:"exec-output-limit" yaml:"exec-output-limit"` // max megabytes of output and of files written by commands and user scripts
+	ExecTimeout         int    `json:"exec-timeout" yaml:"exec-timeout"`           // number of seconds before a command run by ExecCommand is killed
+	ExecUser            string `json:"exec-user" yaml:"exec-user"`                 // user[:group] commands and user scripts run as
+	FileCompress        bool   `json:"file-compress" yaml:"file-compress"`         // rotated files of file destinations are compressed with gzip
+	FileKeep            int    `json:"file-keep" yaml:"file-keep"`                 // number of rotated files of a file destination kept, 0 keeps all
+	FileMaxAge          int    `json:"file-max-age" yaml:"file-max-age"`           // seconds after which the file of a file destination is rotated, 0 for no limit
+	FileMaxSize         int    `json:"file-max-size" yaml:"file-max-size"`         // megabytes at which the file of a file destination is rotated, 0 for no limit
+	Freq                int    `json:"frequency" yaml:"frequency"`
+	HTTPBatchSize       int    `json:"http-batch-size" yaml:"http-batch-size"`         // max number of messages in one http POST
+	HTTPFlushInterval   int    `json:"http-flush-interval" yaml:"http-flush-interval"` // number of seconds before a partial batch is sent
+	HTTPRetries         int    `json:"http-retries" yaml:"http-retries"`               // number of retries of a failed http POST
+	HostRoot            string `json:"host-root" yaml:"host-root"`                     // directory /proc, /sys and other files of the host are read under
+	Listen              string `json:"listen" yaml:"listen"`                           // address the latest metrics are served at for scraping
+	Record              string `json:"record" yaml:"record"`                           // directory the inputs of the collectors are recorded into
+	Replay              string `json:"replay" yaml:"replay"`                           // directory of recorded inputs the agent is run on
+	SkipStr             string `json:"skipStr" yaml:"skipStr"`
+	SpoolSize           int    `json:"spool-size" yaml:"spool-size"` // max size in megabytes of spool for undelivered data, 0 disables it
+	Stdout              bool   `json:"stdout" yaml:"stdout"`
+	TLSCA               string `json:"tls-ca" yaml:"tls-ca"`                   // CA bundle used to verify the destination server
+	TLSCert             string `json:"tls-cert" yaml:"tls-cert"`               // client certificate for mutual TLS
+	TLSKey              string `json:"tls-key" yaml:"tls-key"`                 // private key of the client certificate
+	TLSServerName       string `json:"tls-server-name" yaml:"tls-server-name"` // name to verify the server certificate against
+	TLSPin              string `json:"tls-pin" yaml:"tls-pin"`                 // comma-separated SHA-256 fingerprints of pinned server public keys
+	TrustedKeys         string `json:"trusted-keys" yaml:"trusted-keys"`       // comma-separated PEM files of keys trusted to sign ExecCommand files
+	WaitTime            int    `json:"retrywait" yaml:"retrywait"`             // number of seconds between attempting to reconnect to remote server
 }
 
 // Agent represents information of agent like metric, inventory etc
@@ -119,13 +189,40 @@ type Agent struct {
 	ErrorLimit          int                    // max number of times a collector can error out before being skipped
 	timeoutConnSend     time.Duration          // amount of time before sending data to the server times out
 	dialTimeout         time.Duration          // amount of time before a connection attempt times out
-	SendCh              chan []byte            // channel for sending data to Server
 	WaitGroup           sync.WaitGroup         // wait for collectors to finish
 	WaitTime            time.Duration          // number of seconds between attempting to reconnect to remote server
 	Config              *Config                // the agent config object
+	settingsMtx         sync.RWMutex           // protects Config, Skipmap, frequencies, timeouts and WaitTime once the agent runs
 	nodesMtx            sync.RWMutex
 	SigChan             chan os.Signal
-	Destination         *Destination // currnet destination
+	Destinations        []*Destination      // destinations data is sent to
+	destinationsMtx     sync.RWMutex        // protect list of destinations if it is being replaced
+	cmdFlags            map[string]string   // flags given on command line, they override config file
+	invScheduleCh       chan struct{}       // signals change of inventory collectors or their frequency
+	collectorFreqs      []collectorSetting  // frequencies of collectors which don't run at the common one
+	collectorTimeouts   []collectorSetting  // timeouts of collectors which don't use the common one
+	lastInventory       inventoryBlobMap    // latest inventory blobs, sent to reconnecting destinations
+	ctx                 context.Context     // cancelled when the agent stops
+	cancel              context.CancelFunc  // stops the agent
+	collecting          sync.RWMutex        // held for reading by running collections
+	commands            sync.WaitGroup      // running ExecCommand commands
+	commandsMtx         sync.Mutex          // orders starting a command with waiting for them in Stop
+	inventoryRun        sync.Mutex          // serializes inventory runs, they change the last outputs and blob IDs
+	stopOnce            sync.Once           // agent is stopped once
+	initialInventory    sync.Once           // first inventory collection
+	latestMetrics       latestMetricMap     // latest successful results of metric collectors
+	metricsServer       *http.Server        // serves latestMetrics, nil if not enabled
+	adminServer         *http.Server        // serves the admin API, nil if not enabled
+	controlMtx          sync.Mutex          // serializes config reloads and control commands
+	trustedKeys         trustedKeyList      // keys which sign files run by ExecCommand
+	executedCmds        cmdIDSet            // CmdIDs of the ExecCommand commands run since the agent started
+	execPolicy          execPolicyHolder    // restrictions of commands and user scripts
+	telemetry           gometrics.Registry  // counters and durations reported by the agent metric collector
+	standalone          bool                // collectors are run on demand by a subcommand, not scheduled
+	capture             *collectors.Capture // records the inputs of the collectors with -record, nil if not recording
+	replayConnected     chan *Destination   // tcp and tls destinations which sent their initial data during -replay
+	sinks               []*Destination      // sink destinations given to New
+	ran                 int32               // 1 once Run was called, accessed atomically
 }
 
 type metricHeaderMap struct {
@@ -138,6 +235,31 @@ type metricMetadataMap struct {
 	Map          map[string]map[string]string // map of metric metadata
 }
 
+type latestMetricMap struct {
+	sync.RWMutex                    // protect map if it is being updated
+	Map          map[string]*metric // map of metric results by collector name
+}
+
+type cmdIDSet struct {
+	sync.Mutex                     // protect set if it is being updated
+	Map        map[string]struct{} // set of command IDs
+}
+
+type trustedKeyList struct {
+	sync.RWMutex                    // protect list if it is being replaced
+	List         []crypto.PublicKey // list of public keys
+}
+
+type execPolicyHolder struct {
+	sync.RWMutex             // protect policy if it is being replaced
+	policy       *execPolicy // current policy
+}
+
+type inventoryBlobMap struct {
+	sync.RWMutex                   // protect map if it is being updated
+	Map          map[string][]byte // map of formatted blobs by inventory type
+}
+
 type inventoryCollectorList struct {
 	sync.RWMutex                                // protect list if it is being updated dynamically
 	List         map[string]*InventoryCollector // list of collectors
@@ -155,7 +277,3 @@ type Inventory struct {
 	err        error
 	Timeout    bool
 }
-
-type metricsCollector struct {
-	Collector, Header, Values string
-}
@@ -1,11 +1,11 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -42,7 +42,7 @@ func getExecutableFiles(root string, fileList *[]string) error {
 func (a *Agent) getUserCollectors(colType string) error {
 	var colsPaths []string
 
-	if err := getExecutableFiles(fmt.Sprintf("%s/%s", a.Config.Chdir, colType), &colsPaths); err != nil {
+	if err := getExecutableFiles(fmt.Sprintf("%s/%s", a.config().Chdir, colType), &colsPaths); err != nil {
 		return err
 	}
 
@@ -101,7 +101,7 @@ func (a *Agent) monitorUserScripts(scriptType string) error {
 		return err
 	}
 
-	if err := recursiveWatch(filepath.Join(a.Config.Chdir, scriptType), watcher); err != nil {
+	if err := recursiveWatch(filepath.Join(a.config().Chdir, scriptType), watcher); err != nil {
 		return err
 	}
 
@@ -168,31 +168,41 @@ func (a *Agent) addInventoryScript(path string) error {
 	if file, err := os.Stat(path); err != nil {
 		return err
 	} else if !file.IsDir() && file.Mode()&modePermExec != 0 {
-		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-		collector := &InventoryCollector{
-			collect: generateInvCollectorFunction(path),
-			BaseCollector: BaseCollector{
-				name:          name,
-				collectorType: userScript,
-				state:         runningState,
-				timeout:       a.CollectorTimeout,
-			},
-		}
+		collector := a.newInventoryScript(path)
+		name := collector.name
 		a.inventoryCollectors.Lock()
 		a.inventoryCollectors.List[collector.name] = collector
 		a.inventoryCollectors.Unlock()
+		a.signalInventorySchedule()
 		log.Infof("added inventory collector %s", name)
 	}
 
 	return nil
 }
 
+// newInventoryScript returns the collector of user inventory script path
+func (a *Agent) newInventoryScript(path string) *InventoryCollector {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &InventoryCollector{
+		collect:   a.generateInvCollectorFunction(path),
+		frequency: a.inventoryFrequency(name),
+		BaseCollector: BaseCollector{
+			name:          name,
+			collectorType: userScript,
+			state:         runningState,
+			timeout:       a.collectorTimeout(name),
+			script:        path,
+		},
+	}
+}
+
 // Remove a user inventory script from the inventory collectors list given the path to its location
 func (a *Agent) removeInventoryScript(path string) {
 	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	a.inventoryCollectors.Lock()
 	delete(a.inventoryCollectors.List, name)
 	a.inventoryCollectors.Unlock()
+	a.signalInventorySchedule()
 	log.Infof("removed inventory collector %s", name)
 }
 
@@ -221,19 +231,8 @@ func (a *Agent) addMetricsScript(path string) error {
 	} else if file.IsDir() || file.Mode()&modePermExec == 0 {
 		return errors.New(path + " is not executable file")
 	}
-	name := "user." + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-
-	collector := &MetricCollector{
-		frequency: a.MetricFrequency,
-		collect:   generateMetricsCollectorFunction(path),
-		killCh:    make(chan struct{}),
-		BaseCollector: BaseCollector{
-			name:          name,
-			collectorType: userScript,
-			timeout:       a.CollectorTimeout,
-			state:         runningState,
-		},
-	}
+	collector := a.newMetricsScript(path)
+	name := collector.name
 	a.metricCollectors.Lock()
 	defer a.metricCollectors.Unlock()
 	if _, ok := a.metricCollectors.List[collector.name]; ok {
@@ -244,11 +243,30 @@ func (a *Agent) addMetricsScript(path string) error {
 	log.Infof("added metrics collector %s", name)
 
 	// start metric collector
-	go a.scheduleMetricCollector(collector)
+	if !a.standalone {
+		go a.scheduleMetricCollector(collector)
+	}
 
 	return nil
 }
 
+// newMetricsScript returns the collector of user metric script path
+func (a *Agent) newMetricsScript(path string) *MetricCollector {
+	name := "user." + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &MetricCollector{
+		frequency: a.metricFrequency(name),
+		collect:   a.generateMetricsCollectorFunction(path),
+		killCh:    make(chan struct{}),
+		BaseCollector: BaseCollector{
+			name:          name,
+			collectorType: userScript,
+			timeout:       a.collectorTimeout(name),
+			state:         runningState,
+			script:        path,
+		},
+	}
+}
+
 // Remove a user metric script from the metric collectors list given the path to
 // its location and stop collector
 func (a *Agent) removeMetricsScript(path string) {
@@ -260,13 +278,14 @@ func (a *Agent) removeMetricsScript(path string) {
 		close(col.killCh)
 	}
 	delete(a.metricCollectors.List, name)
+	a.forgetLatestMetric(name)
 	log.Infof("removed metrics collector %s", name)
 }
 
 // Wraps a call to an external executable in a CollectorFunc for inventories
-func generateInvCollectorFunction(cmd string) func() ([]byte, error) {
-	return func() ([]byte, error) {
-		output, err := exec.Command(cmd).Output()
+func (a *Agent) generateInvCollectorFunction(cmd string) func(context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		output, err := a.runUserScript(ctx, cmd)
 		if err != nil {
 			out := ""
 			if output != nil {
@@ -283,9 +302,9 @@ func generateInvCollectorFunction(cmd string) func() ([]byte, error) {
 }
 
 // Wraps a call to an external executable in a CollectorFunc for metrics
-func generateMetricsCollectorFunction(cmd string) func() ([]*collectors.MetricResult, error) {
-	return func() ([]*collectors.MetricResult, error) {
-		output, err := exec.Command(cmd).Output()
+func (a *Agent) generateMetricsCollectorFunction(cmd string) func(context.Context) ([]*collectors.MetricResult, error) {
+	return func(ctx context.Context) ([]*collectors.MetricResult, error) {
+		output, err := a.runUserScript(ctx, cmd)
 		if err != nil {
 			out := ""
 			if output != nil {
@@ -297,12 +316,18 @@ func generateMetricsCollectorFunction(cmd string) func() ([]*collectors.MetricRe
 		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
 		if len(lines) != 2 {
 			log.Errorf("Incorrect return format: headers new line data, from script: [%s], output: [%s]", cmd, string(output))
+			if len(lines) < 2 {
+				return nil, fmt.Errorf("user script [%s] returned no data line", cmd)
+			}
 		}
-		result := &collectors.MetricResult{
-			Header:   lines[0],
-			Data:     lines[1],
-			Sufix:    "",
-			Metadata: nil,
+		headers, values := strings.Fields(lines[0]), strings.Fields(lines[1])
+		if len(headers) != len(values) {
+			log.Errorf("Number of headers %d and values %d differ, from script: [%s]", len(headers), len(values), cmd)
+		}
+		// value types are not declared by scripts, they are inferred from the values
+		result := &collectors.MetricResult{}
+		for i := 0; i < len(headers) && i < len(values); i++ {
+			result.Samples = append(result.Samples, collectors.InferSample(headers[i], values[i]))
 		}
 		return []*collectors.MetricResult{result}, nil
 	}
@@ -331,3 +356,12 @@ func recursiveWatch(root string, watcher *fsnotify.Watcher) error {
 
 	return err
 }
+
+// runUserScript runs script cmd under the execution policy and returns its stdout
+func (a *Agent) runUserScript(ctx context.Context, cmd string) ([]byte, error) {
+	res, err := a.runSandboxed(ctx, "", cmd)
+	if res == nil {
+		return nil, err
+	}
+	return res.Stdout, err
+}